@@ -0,0 +1,648 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/proto-gen/api_v2/metrics"
+	"github.com/jaegertracing/jaeger/storage/metricsstore"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/rs/zerolog"
+)
+
+// ====================
+// 扩展 HTTP API
+// ====================
+//
+// Jaeger 的 spanstore.Reader 接口无法表达 trace 级搜索等能力，
+// 这些能力通过插件自己的 HTTP 接口暴露（-http-addr，为空时不启动）。
+// 响应格式与 Jaeger Query 的 HTTP API 一致：{"data": ..., "errors": [...]}
+
+// defaultAPILookback 未指定时间范围时的默认回溯时间
+const defaultAPILookback = time.Hour
+
+// 指标查询参数的默认值，与 Jaeger Query 一致
+const (
+	defaultMetricsLookback = time.Hour
+	defaultMetricsStep     = 5 * time.Second
+	defaultMetricsRatePer  = 10 * time.Minute
+	defaultMetricsSpanKind = "server"
+)
+
+// apiResponse 统一的响应结构
+type apiResponse struct {
+	Data   interface{} `json:"data"`
+	Errors []apiError  `json:"errors,omitempty"`
+}
+
+// apiError 错误信息
+type apiError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// apiServer 扩展 HTTP API
+type apiServer struct {
+	reader      *MySQLSpanReader
+	deps        *MySQLDependencyReader
+	metrics     metricsstore.Reader
+	spanMetrics *spanMetrics
+	cacheStats  func() []namedCacheStats
+	logger      zerolog.Logger
+}
+
+func newAPIServer(store *MySQLStore) *apiServer {
+	return &apiServer{
+		reader:      store.SpanReader().(*MySQLSpanReader),
+		deps:        store.DependencyReader().(*MySQLDependencyReader),
+		metrics:     store.MetricsReader(),
+		spanMetrics: store.spanMetrics,
+		cacheStats:  store.cacheStats,
+		logger:      store.logger,
+	}
+}
+
+// handler 返回注册了全部路由的 http.Handler
+func (a *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/traces/summaries", a.handleTraceSummaries)
+	mux.HandleFunc("/api/errors/summary", a.handleErrorSummary)
+	mux.HandleFunc("/api/traces/search", a.handleSearchTraces)
+	mux.HandleFunc("/api/lookup", a.handleLookup)
+	mux.HandleFunc("/api/traces/critical-path", a.handleCriticalPath)
+	mux.HandleFunc("/api/traces/diff", a.handleTraceDiff)
+	mux.HandleFunc("/api/dependencies", a.handleDependencies)
+	mux.HandleFunc("/api/metrics/latencies", a.handleLatencies)
+	mux.HandleFunc("/api/metrics/calls", a.handleCallRates)
+	mux.HandleFunc("/api/metrics/errors", a.handleErrorRates)
+	mux.HandleFunc("/api/metrics/minstep", a.handleMinStep)
+	mux.HandleFunc("/metrics", a.handlePrometheus)
+	return mux
+}
+
+// serve 启动 HTTP 服务，ctx 结束时优雅关闭
+func (a *apiServer) serve(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           a.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			a.logger.Warn().Err(err).Msg("Failed to shut down HTTP server")
+		}
+	}()
+
+	a.logger.Info().Str("address", addr).Msg("Starting HTTP API server")
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// handleTraceSummaries GET /api/traces/summaries
+//
+// 参数：service, rootService, rootOperation, start, end（Unix 微秒）, lookback,
+// minDuration, maxDuration（Go duration）, minSpans, errors, sort, limit
+func (a *apiServer) handleTraceSummaries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	p := queryParams{values: r.URL.Query()}
+	start, end := p.timeRange()
+	q := &traceSummaryQuery{
+		ServiceName:   p.str("service"),
+		RootService:   p.str("rootService"),
+		RootOperation: p.str("rootOperation"),
+		StartTimeMin:  start,
+		StartTimeMax:  end,
+		DurationMin:   p.duration("minDuration"),
+		DurationMax:   p.duration("maxDuration"),
+		MinSpans:      p.int("minSpans", 0),
+		ErrorsOnly:    p.bool("errors", false),
+		SortBy:        p.str("sort"),
+		Limit:         p.int("limit", 20),
+	}
+	if p.err != nil {
+		a.writeError(w, http.StatusBadRequest, p.err)
+		return
+	}
+
+	summaries, err := a.reader.FindTraceSummaries(r.Context(), q)
+	if err != nil {
+		a.writeQueryError(w, err)
+		return
+	}
+	if summaries == nil {
+		summaries = []*traceSummary{}
+	}
+	a.writeJSON(w, http.StatusOK, apiResponse{Data: summaries})
+}
+
+// handleErrorSummary GET /api/errors/summary
+//
+// 参数：service（可选）, start, end, lookback, limit
+func (a *apiServer) handleErrorSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	p := queryParams{values: r.URL.Query()}
+	start, end := p.timeRange()
+	q := &errorSummaryQuery{
+		ServiceName:  p.str("service"),
+		StartTimeMin: start,
+		StartTimeMax: end,
+		Limit:        p.int("limit", 0),
+	}
+	if p.err != nil {
+		a.writeError(w, http.StatusBadRequest, p.err)
+		return
+	}
+
+	summary, err := a.reader.ErrorSummary(r.Context(), q)
+	if err != nil {
+		a.writeQueryError(w, err)
+		return
+	}
+	if summary == nil {
+		summary = []errorSummary{}
+	}
+	a.writeJSON(w, http.StatusOK, apiResponse{Data: summary})
+}
+
+// handleSearchTraces GET /api/traces/search
+//
+// 参数：service（可选，为空时跨所有服务搜索）, operation, tags（JSON 对象）, tag（可重复，key:value）,
+// start, end, lookback, minDuration, maxDuration, limit, cursor
+// 翻页时除 cursor 外的参数必须与第一页相同（end 需显式指定，否则时间窗口会移动）
+func (a *apiServer) handleSearchTraces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	p := queryParams{values: r.URL.Query()}
+	start, end := p.timeRange()
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:   p.str("service"),
+		OperationName: p.str("operation"),
+		Tags:          p.tags(),
+		StartTimeMin:  start,
+		StartTimeMax:  end,
+		DurationMin:   p.duration("minDuration"),
+		DurationMax:   p.duration("maxDuration"),
+		NumTraces:     p.int("limit", defaultSearchPageSize),
+	}
+	if p.err != nil {
+		a.writeError(w, http.StatusBadRequest, p.err)
+		return
+	}
+
+	page, err := a.reader.SearchTraces(r.Context(), query, p.str("cursor"))
+	if err != nil {
+		a.writeQueryError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, apiResponse{Data: page})
+}
+
+// handleLookup GET /api/lookup
+//
+// 参数：q（span ID、trace ID 或任意标识）, start, end, lookback, limit
+func (a *apiServer) handleLookup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	p := queryParams{values: r.URL.Query()}
+	start, end := p.timeRange()
+	q := &lookupQuery{
+		Token:        strings.TrimSpace(p.str("q")),
+		StartTimeMin: start,
+		StartTimeMax: end,
+		Limit:        p.int("limit", defaultLookupLimit),
+	}
+	if p.err != nil {
+		a.writeError(w, http.StatusBadRequest, p.err)
+		return
+	}
+
+	traces, err := a.reader.LookupTraces(r.Context(), q)
+	if err != nil {
+		a.writeQueryError(w, err)
+		return
+	}
+	if traces == nil {
+		traces = []lookupTrace{}
+	}
+	a.writeJSON(w, http.StatusOK, apiResponse{Data: traces})
+}
+
+// handleCriticalPath GET /api/traces/critical-path
+//
+// 参数：traceID
+func (a *apiServer) handleCriticalPath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	p := queryParams{values: r.URL.Query()}
+	traceID := p.traceID("traceID")
+	if p.err != nil {
+		a.writeError(w, http.StatusBadRequest, p.err)
+		return
+	}
+
+	path, err := a.reader.CriticalPath(r.Context(), traceID)
+	if err != nil {
+		a.writeTraceError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, apiResponse{Data: path})
+}
+
+// handleTraceDiff GET /api/traces/diff
+//
+// 参数：a、b（trace ID，通常 a 为正常请求、b 为慢请求）
+func (a *apiServer) handleTraceDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	p := queryParams{values: r.URL.Query()}
+	traceA := p.traceID("a")
+	traceB := p.traceID("b")
+	if p.err != nil {
+		a.writeError(w, http.StatusBadRequest, p.err)
+		return
+	}
+
+	diff, err := a.reader.DiffTraces(r.Context(), traceA, traceB)
+	if err != nil {
+		a.writeTraceError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, apiResponse{Data: diff})
+}
+
+// writeQueryError 写出查询错误：查询条件不合法时返回 400，其余（数据库错误等）返回 500
+func (a *apiServer) writeQueryError(w http.ResponseWriter, err error) {
+	if isInvalidQuery(err) {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	a.writeError(w, http.StatusInternalServerError, err)
+}
+
+// writeTraceError 写出读取 trace 的错误，trace 不存在时返回 404
+func (a *apiServer) writeTraceError(w http.ResponseWriter, err error) {
+	if errors.Is(err, spanstore.ErrTraceNotFound) {
+		a.writeError(w, http.StatusNotFound, err)
+		return
+	}
+	a.writeError(w, http.StatusInternalServerError, err)
+}
+
+// handleDependencies GET /api/dependencies
+//
+// 参数：service（可选）, operations（是否按操作名细分）, virtual（是否包含虚拟边，默认 true）,
+// start, end, lookback。
+// 返回 {links, truncated}，span 数超过 DEPENDENCIES_MAX_SPANS 的窗口返回 400
+func (a *apiServer) handleDependencies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	p := queryParams{values: r.URL.Query()}
+	start, end := p.timeRange()
+	q := &dependencyQuery{
+		StartTime:  start,
+		EndTime:    end,
+		Service:    p.str("service"),
+		Operations: p.bool("operations", false),
+		Virtual:    p.bool("virtual", true),
+	}
+	if p.err != nil {
+		a.writeError(w, http.StatusBadRequest, p.err)
+		return
+	}
+
+	deps, err := a.deps.GetRichDependencies(r.Context(), q)
+	if err != nil {
+		a.writeQueryError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, apiResponse{Data: deps})
+}
+
+// handleLatencies GET /api/metrics/latencies
+//
+// 参数与 Jaeger Query 一致：service（必填，可重复）, quantile（必填）, groupByOperation,
+// spanKind（可重复）, endTs, lookback, step, ratePer（毫秒）
+func (a *apiServer) handleLatencies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	p := queryParams{values: r.URL.Query()}
+	quantile, err := strconv.ParseFloat(p.str("quantile"), 64)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid quantile %q: %w", p.str("quantile"), err))
+		return
+	}
+	a.serveMetrics(w, r, &p, func(ctx context.Context, base metricsstore.BaseQueryParameters) (*metrics.MetricFamily, error) {
+		return a.metrics.GetLatencies(ctx, &metricsstore.LatenciesQueryParameters{BaseQueryParameters: base, Quantile: quantile})
+	})
+}
+
+// handleCallRates GET /api/metrics/calls
+func (a *apiServer) handleCallRates(w http.ResponseWriter, r *http.Request) {
+	p := queryParams{values: r.URL.Query()}
+	a.serveMetrics(w, r, &p, func(ctx context.Context, base metricsstore.BaseQueryParameters) (*metrics.MetricFamily, error) {
+		return a.metrics.GetCallRates(ctx, &metricsstore.CallRateQueryParameters{BaseQueryParameters: base})
+	})
+}
+
+// handleErrorRates GET /api/metrics/errors
+func (a *apiServer) handleErrorRates(w http.ResponseWriter, r *http.Request) {
+	p := queryParams{values: r.URL.Query()}
+	a.serveMetrics(w, r, &p, func(ctx context.Context, base metricsstore.BaseQueryParameters) (*metrics.MetricFamily, error) {
+		return a.metrics.GetErrorRates(ctx, &metricsstore.ErrorRateQueryParameters{BaseQueryParameters: base})
+	})
+}
+
+// handleMinStep GET /api/metrics/minstep，返回毫秒
+func (a *apiServer) handleMinStep(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	step, err := a.metrics.GetMinStepDuration(r.Context(), &metricsstore.MinStepDurationQueryParameters{})
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, apiResponse{Data: step.Milliseconds()})
+}
+
+// serveMetrics 解析公共指标参数，执行查询并以 protobuf JSON 格式返回 MetricFamily
+func (a *apiServer) serveMetrics(w http.ResponseWriter, r *http.Request, p *queryParams,
+	get func(context.Context, metricsstore.BaseQueryParameters) (*metrics.MetricFamily, error)) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	base := p.metricsParams()
+	if p.err != nil {
+		a.writeError(w, http.StatusBadRequest, p.err)
+		return
+	}
+	family, err := get(r.Context(), base)
+	if err != nil {
+		a.writeQueryError(w, err)
+		return
+	}
+	a.writeProto(w, family)
+}
+
+// handlePrometheus GET /metrics，Prometheus 文本格式的写入端 span 指标（SPAN_METRICS_ENABLED）和缓存统计
+func (a *apiServer) handlePrometheus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		a.writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if a.spanMetrics != nil {
+		if err := a.spanMetrics.writePrometheus(w); err != nil {
+			a.logger.Warn().Err(err).Msg("Failed to write metrics")
+			return
+		}
+	}
+	if err := writeCachePrometheus(w, a.cacheStats()); err != nil {
+		a.logger.Warn().Err(err).Msg("Failed to write metrics")
+	}
+}
+
+// writeProto 以 protobuf JSON 格式写出响应（与 Jaeger Query 的指标接口一致）
+func (a *apiServer) writeProto(w http.ResponseWriter, msg proto.Message) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := (&jsonpb.Marshaler{}).Marshal(w, msg); err != nil {
+		a.logger.Warn().Err(err).Msg("Failed to write HTTP response")
+	}
+}
+
+// writeJSON 写出 JSON 响应
+func (a *apiServer) writeJSON(w http.ResponseWriter, status int, resp apiResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		a.logger.Warn().Err(err).Msg("Failed to write HTTP response")
+	}
+}
+
+// writeError 写出错误响应
+func (a *apiServer) writeError(w http.ResponseWriter, status int, err error) {
+	a.writeJSON(w, status, apiResponse{Errors: []apiError{{Code: status, Msg: err.Error()}}})
+}
+
+// ====================
+// 查询参数解析
+// ====================
+
+// queryParams 解析 URL 参数，记录第一个错误
+type queryParams struct {
+	values map[string][]string
+	err    error
+}
+
+func (p *queryParams) str(key string) string {
+	if v := p.values[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (p *queryParams) fail(key, value string, err error) {
+	if p.err == nil {
+		p.err = fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+}
+
+func (p *queryParams) int(key string, defaultVal int) int {
+	v := p.str(key)
+	if v == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		p.fail(key, v, err)
+		return defaultVal
+	}
+	return n
+}
+
+func (p *queryParams) bool(key string, defaultVal bool) bool {
+	v := p.str(key)
+	if v == "" {
+		return defaultVal
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.fail(key, v, err)
+		return defaultVal
+	}
+	return b
+}
+
+func (p *queryParams) duration(key string) time.Duration {
+	v := p.str(key)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		p.fail(key, v, err)
+	}
+	return d
+}
+
+// tags 解析 tags（JSON 对象）和 tag（key:value，可重复），与 Jaeger Query API 一致
+func (p *queryParams) tags() map[string]string {
+	tags := make(map[string]string)
+	if v := p.str("tags"); v != "" {
+		if err := json.Unmarshal([]byte(v), &tags); err != nil {
+			p.fail("tags", v, err)
+		}
+	}
+	for _, v := range p.values["tag"] {
+		key, value, ok := strings.Cut(v, ":")
+		if !ok || key == "" {
+			p.fail("tag", v, errors.New("expected key:value"))
+			continue
+		}
+		tags[key] = value
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// micros 解析 Unix 微秒时间戳（与 Jaeger Query API 一致）
+func (p *queryParams) micros(key string) (time.Time, bool) {
+	v := p.str(key)
+	if v == "" {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		p.fail(key, v, err)
+		return time.Time{}, false
+	}
+	return time.UnixMicro(n), true
+}
+
+// traceID 解析必填的 trace ID
+func (p *queryParams) traceID(key string) model.TraceID {
+	v := p.str(key)
+	if v == "" {
+		p.fail(key, v, errors.New("required"))
+		return model.TraceID{}
+	}
+	id, err := model.TraceIDFromString(v)
+	if err != nil {
+		p.fail(key, v, err)
+	}
+	return id
+}
+
+// millis 解析毫秒数表示的时长（与 Jaeger Query 的指标接口一致）
+func (p *queryParams) millis(key string, defaultVal time.Duration) time.Duration {
+	v := p.str(key)
+	if v == "" {
+		return defaultVal
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		p.fail(key, v, err)
+		return defaultVal
+	}
+	return time.Duration(n) * time.Millisecond
+}
+
+// metricsParams 解析指标查询的公共参数，spanKind 转换为 OTel 名称（SPAN_KIND_SERVER 等）
+func (p *queryParams) metricsParams() metricsstore.BaseQueryParameters {
+	base := metricsstore.BaseQueryParameters{
+		ServiceNames:     p.values["service"],
+		GroupByOperation: p.bool("groupByOperation", false),
+	}
+	if len(base.ServiceNames) == 0 && p.err == nil {
+		p.err = errors.New("please provide at least one service name")
+	}
+
+	kinds := p.values["spanKind"]
+	if len(kinds) == 0 {
+		kinds = []string{defaultMetricsSpanKind}
+	}
+	for _, kind := range kinds {
+		otelKind := "SPAN_KIND_" + strings.ToUpper(kind)
+		if _, ok := metrics.SpanKind_value[otelKind]; !ok {
+			p.fail("spanKind", kind, errors.New("unsupported span kind"))
+			continue
+		}
+		base.SpanKinds = append(base.SpanKinds, otelKind)
+	}
+
+	end := time.Now()
+	if v := p.str("endTs"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			p.fail("endTs", v, err)
+		}
+		end = time.UnixMilli(n)
+	}
+	lookback := p.millis("lookback", defaultMetricsLookback)
+	step := p.millis("step", defaultMetricsStep)
+	ratePer := p.millis("ratePer", defaultMetricsRatePer)
+	base.EndTime, base.Lookback, base.Step, base.RatePer = &end, &lookback, &step, &ratePer
+	return base
+}
+
+// timeRange 解析 start/end/lookback，默认为最近 defaultAPILookback
+func (p *queryParams) timeRange() (time.Time, time.Time) {
+	end, ok := p.micros("end")
+	if !ok {
+		end = time.Now()
+	}
+	start, ok := p.micros("start")
+	if !ok {
+		lookback := p.duration("lookback")
+		if lookback <= 0 {
+			lookback = defaultAPILookback
+		}
+		start = end.Add(-lookback)
+	}
+	return start, end
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// 旧数据回填
+// ====================
+
+var (
+	// 启动时是否为旧数据回填 span_kind
+	spanKindBackfillEnabled = getBoolEnv("SPAN_KIND_BACKFILL", true)
+	// 每批回填的 span 数
+	spanKindBackfillBatch = getIntEnv("SPAN_KIND_BACKFILL_BATCH", 1000)
+	// 启动时是否为旧数据重新计算 tag_kv、tag_attrs、is_error 等派生列
+	derivedBackfillEnabled = getBoolEnv("DERIVED_BACKFILL", true)
+	// 每批重写的 span 数（整行 REPLACE，批次不宜过大）
+	derivedBackfillBatch = getIntEnv("DERIVED_BACKFILL_BATCH", 500)
+	// 是否从 jaeger_spans 初始化 jaeger_operations（只执行一次，完成后记录在 jaeger_state）
+	operationsCatalogSeedEnabled = getBoolEnv("OPERATIONS_CATALOG_SEED", true)
+	// 初始化目录失败后的重试间隔
+	operationsCatalogSeedRetry = getDurationEnv("OPERATIONS_CATALOG_SEED_RETRY", time.Minute)
+)
+
+// stopContext 返回在 Close 时取消的 context，供后台任务使用
+func (s *MySQLStore) stopContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-s.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// startupTasks 依次执行启动时的旧数据任务：先补齐 span_kind 再初始化目录，
+// 保证目录中旧操作的 span_kind 已回填；最后重写派生列。
+// 几个任务都会改写 jaeger_spans 的同一批行（包括 span_kind），必须串行执行
+func (s *MySQLStore) startupTasks() {
+	defer s.wg.Done()
+
+	ctx, cancel := s.stopContext()
+	defer cancel()
+
+	if spanKindBackfillEnabled {
+		s.backfillSpanKind(ctx)
+	}
+	if operationsCatalogSeedEnabled {
+		s.seedOperationCatalogUntilDone(ctx)
+	}
+	if derivedBackfillEnabled {
+		s.backfillDerivedColumns(ctx)
+	}
+}
+
+// backfillSpanKind 为新增 span_kind 列之前写入的 span 补齐 span_kind
+// 只扫描 tags 中含有 span.kind 的行（全文索引过滤），按 id 递增分批处理，
+// 回填完成后再次启动只会命中少量新行
+func (s *MySQLStore) backfillSpanKind(ctx context.Context) {
+	var lastID int64
+	total := 0
+	for {
+		n, nextID, err := s.backfillSpanKindBatch(ctx, lastID)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Warn().Err(err).Int64("last_id", lastID).Msg("Span kind backfill stopped")
+			}
+			return
+		}
+		total += n
+		if nextID == lastID {
+			break
+		}
+		lastID = nextID
+	}
+
+	if total > 0 {
+		s.logger.Info().Int("updated", total).Msg("Span kind backfill completed")
+	}
+}
+
+// backfillSpanKindBatch 处理 id > afterID 的一批数据，返回更新行数和本批最大 id
+func (s *MySQLStore) backfillSpanKindBatch(ctx context.Context, afterID int64) (int, int64, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tags
+		FROM jaeger_spans
+		WHERE MATCH('@tags "span kind"') AND span_kind = '' AND id > ?
+		ORDER BY id ASC
+		LIMIT ?
+	`, afterID, spanKindBackfillBatch)
+	if err != nil {
+		return 0, afterID, err
+	}
+
+	lastID := afterID
+	idsByKind := make(map[string][]interface{})
+	for rows.Next() {
+		var (
+			id       int64
+			tagsJSON string
+		)
+		if err := rows.Scan(&id, &tagsJSON); err != nil {
+			continue
+		}
+		lastID = id
+
+		var tags []model.KeyValue
+		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
+			continue
+		}
+		if kind := spanKindOf(tags); kind != "" {
+			idsByKind[kind] = append(idsByKind[kind], id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, afterID, err
+	}
+
+	updated := 0
+	for kind, ids := range idsByKind {
+		query := fmt.Sprintf(
+			"UPDATE jaeger_spans SET span_kind = ? WHERE id IN (%s)",
+			strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "),
+		)
+		args := append([]interface{}{kind}, ids...)
+		if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+			return updated, afterID, fmt.Errorf("update span_kind failed: %w", err)
+		}
+		updated += len(ids)
+	}
+
+	return updated, lastID, nil
+}
+
+// seedOperationCatalogUntilDone 初始化目录，失败时每隔 operationsCatalogSeedRetry 重试，直到成功或关闭
+func (s *MySQLStore) seedOperationCatalogUntilDone(ctx context.Context) {
+	for {
+		err := s.seedOperationCatalog(ctx)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		s.logger.Warn().Err(err).Dur("retry_in", operationsCatalogSeedRetry).Msg("Failed to seed operations catalog")
+		select {
+		case <-time.After(operationsCatalogSeedRetry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// seedOperationCatalog 从 jaeger_spans 聚合初始化目录（首次升级时），之后由批量写入维护
+// 是否已完成以 jaeger_state 中的标记为准：批量写入可能先写入新操作，目录非空不代表已初始化
+func (s *MySQLStore) seedOperationCatalog(ctx context.Context) error {
+	if _, seeded, err := loadState(ctx, s.db, stateOperationsCatalogSeeded); err != nil || seeded {
+		return err
+	}
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT service_name, operation_name, span_kind, MAX(start_time) AS last_seen
+		FROM jaeger_spans
+		GROUP BY service_name, operation_name, span_kind
+		LIMIT %d OPTION max_matches=%d
+	`, catalogQueryLimit, catalogQueryLimit))
+	if err != nil {
+		return fmt.Errorf("aggregate operations failed: %w", err)
+	}
+
+	keys := make(map[operationKey]int64)
+	for rows.Next() {
+		var (
+			key      operationKey
+			lastSeen int64
+		)
+		if err := rows.Scan(&key.service, &key.operation, &key.spanKind, &lastSeen); err != nil {
+			continue
+		}
+		keys[key] = lastSeen
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("aggregate operations failed: %w", err)
+	}
+
+	if len(keys) > 0 {
+		if err := upsertOperations(ctx, s.db, keys); err != nil {
+			return err
+		}
+		s.invalidateServicesCache()
+		s.logger.Info().Int("operations", len(keys)).Msg("Operations catalog seeded from spans")
+	}
+	return saveState(ctx, s.db, stateOperationsCatalogSeeded, time.Now().UnixNano())
+}
+
+// backfillDerivedColumns 为 derived_version 落后的旧数据重新计算派生列
+// tag_kv 是全文字段，无法 UPDATE，只能按原 id 整行 REPLACE。按 id 递增分批处理，
+// 完成后 tag 过滤、错误过滤和错误率对旧数据同样生效
+func (s *MySQLStore) backfillDerivedColumns(ctx context.Context) {
+	var lastID int64
+	total := 0
+	for {
+		n, nextID, err := s.backfillDerivedBatch(ctx, lastID)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Warn().Err(err).Int64("last_id", lastID).Msg("Derived column backfill stopped")
+			}
+			return
+		}
+		total += n
+		if nextID == lastID {
+			break
+		}
+		lastID = nextID
+	}
+
+	if total > 0 {
+		s.logger.Info().Int("updated", total).Msg("Derived column backfill completed")
+	}
+}
+
+// backfillDerivedBatch 重写 id > afterID 的一批旧数据，返回重写行数和本批最大 id
+// 先只读取 id 确定批次范围，无法解析的行跳过并记录，不会使回填提前结束
+func (s *MySQLStore) backfillDerivedBatch(ctx context.Context, afterID int64) (int, int64, error) {
+	idRows, err := s.db.QueryContext(ctx, `
+		SELECT id
+		FROM jaeger_spans
+		WHERE derived_version < ? AND id > ?
+		ORDER BY id ASC
+		LIMIT ?
+	`, spanDerivedVersion, afterID, derivedBackfillBatch)
+	if err != nil {
+		return 0, afterID, err
+	}
+	lastID := afterID
+	var ids []interface{}
+	for idRows.Next() {
+		var id int64
+		if err := idRows.Scan(&id); err != nil {
+			continue
+		}
+		lastID = id
+		ids = append(ids, id)
+	}
+	idRows.Close()
+	if err := idRows.Err(); err != nil {
+		return 0, afterID, err
+	}
+	if len(ids) == 0 {
+		return 0, lastID, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT trace_id, span_id, operation_name, flags,
+			   start_time, duration, tags, logs, refs, process, service_name, id
+		FROM jaeger_spans
+		WHERE id IN (%s)
+		LIMIT %d OPTION max_matches=%d
+	`, strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), len(ids), len(ids)), ids...)
+	if err != nil {
+		return 0, afterID, err
+	}
+	var args []interface{}
+	for rows.Next() {
+		var id int64
+		span, err := scanSpan(rows, &id)
+		if err != nil {
+			continue
+		}
+		args = appendSpanValues(append(args, id), span)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, afterID, err
+	}
+
+	n := len(args) / (spanInsertFieldCount + 1)
+	if skipped := len(ids) - n; skipped > 0 {
+		s.logger.Warn().Int("skipped", skipped).Int64("after_id", afterID).Int64("last_id", lastID).
+			Msg("Derived column backfill skipped unreadable spans")
+	}
+	if n == 0 {
+		return 0, lastID, nil
+	}
+
+	placeholder := "(?, " + strings.TrimPrefix(spanInsertPlaceholders, "(")
+	query := "REPLACE INTO jaeger_spans (id, " + spanInsertColumns + ") VALUES " +
+		strings.TrimSuffix(strings.Repeat(placeholder+", ", n), ", ")
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		return 0, afterID, fmt.Errorf("rewrite derived columns failed: %w", err)
+	}
+	return n, lastID, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestBackfillDerivedBatch(t *testing.T) {
+	f, reader := newTestReader(t)
+	store := reader.store
+	old := pendingSpan(model.NewTraceID(0, 1), 1, time.Unix(10, 0),
+		model.String("http.method", "GET"), model.Bool("error", true))
+	f.onFunc("WHERE derived_version < ?", []string{"id"}, func(args []interface{}) [][]interface{} {
+		if args[1] != int64(0) {
+			return nil
+		}
+		return [][]interface{}{{int64(42)}}
+	})
+	f.on("WHERE id IN", append(append([]string(nil), spanColumns...), "id"), append(spanRow(old), int64(42)))
+
+	n, lastID, err := store.backfillDerivedBatch(context.Background(), 0)
+	if err != nil || n != 1 || lastID != 42 {
+		t.Fatalf("backfillDerivedBatch = (%d, %d, %v), want (1, 42, nil)", n, lastID, err)
+	}
+	writes := f.matching("REPLACE INTO jaeger_spans (id, ")
+	if len(writes) != 1 {
+		t.Fatalf("expected 1 REPLACE, got %d", len(writes))
+	}
+	args := writes[0].args
+	if len(args) != spanInsertFieldCount+1 || args[0] != int64(42) {
+		t.Fatalf("unexpected REPLACE args %v", args)
+	}
+	// id 之后依次为 spanInsertColumns：tag_kv、tag_attrs、span_kind、is_error、derived_version 在末尾
+	if args[12] != "http.method=GET\nerror=true" || args[15] != 1 || args[16] != spanDerivedVersion {
+		t.Errorf("derived columns not recomputed: %v", args[12:])
+	}
+
+	// 下一批为空时 lastID 不变，循环结束
+	n, next, err := store.backfillDerivedBatch(context.Background(), lastID)
+	if err != nil || n != 0 || next != lastID {
+		t.Errorf("second batch = (%d, %d, %v), want (0, %d, nil)", n, next, err, lastID)
+	}
+	if len(f.matching("REPLACE INTO jaeger_spans (id, ")) != 1 {
+		t.Error("empty batch should not write")
+	}
+}
+
+func TestBackfillDerivedSkipsUnreadableBatch(t *testing.T) {
+	f, reader := newTestReader(t)
+	store := reader.store
+	good := pendingSpan(model.NewTraceID(0, 2), 1, time.Unix(10, 0))
+	// 第一批的行无法解析，第二批正常
+	f.onFunc("WHERE derived_version < ?", []string{"id"}, func(args []interface{}) [][]interface{} {
+		switch args[1] {
+		case int64(0):
+			return [][]interface{}{{int64(5)}, {int64(6)}}
+		case int64(6):
+			return [][]interface{}{{int64(9)}}
+		}
+		return nil
+	})
+	f.onFunc("WHERE id IN", append(append([]string(nil), spanColumns...), "id"), func(args []interface{}) [][]interface{} {
+		if args[0] == int64(9) {
+			return [][]interface{}{append(spanRow(good), int64(9))}
+		}
+		bad := append(spanRow(good), int64(5))
+		bad[4] = "not a number"
+		return [][]interface{}{bad}
+	})
+
+	n, lastID, err := store.backfillDerivedBatch(context.Background(), 0)
+	if err != nil || n != 0 || lastID != 6 {
+		t.Fatalf("unreadable batch = (%d, %d, %v), want (0, 6, nil)", n, lastID, err)
+	}
+
+	// 完整循环越过无法解析的批次，继续处理之后的行
+	store.backfillDerivedColumns(context.Background())
+	writes := f.matching("REPLACE INTO jaeger_spans (id, ")
+	if len(writes) != 1 || writes[0].args[0] != int64(9) {
+		t.Errorf("expected id 9 to be rewritten, got %v", writes)
+	}
+}
+
+func TestBackfillSpanKindBatch(t *testing.T) {
+	f, reader := newTestReader(t)
+	store := reader.store
+	tagsWithKind := func(kind string) string {
+		return marshalTags([]model.KeyValue{model.String("span.kind", kind)})
+	}
+	// 两批数据：id 1-3 和 id 7，之后为空
+	f.onFunc("SELECT id, tags", []string{"id", "tags"}, func(args []interface{}) [][]interface{} {
+		switch args[0] {
+		case int64(0):
+			return [][]interface{}{
+				{int64(1), tagsWithKind("server")},
+				{int64(2), tagsWithKind("client")},
+				{int64(3), tagsWithKind("server")},
+			}
+		case int64(3):
+			return [][]interface{}{{int64(7), `not json`}}
+		}
+		return nil
+	})
+
+	n, lastID, err := store.backfillSpanKindBatch(context.Background(), 0)
+	if err != nil || n != 3 || lastID != 3 {
+		t.Fatalf("first batch = (%d, %d, %v), want (3, 3, nil)", n, lastID, err)
+	}
+	updates := make(map[string]int)
+	for _, q := range f.matching("UPDATE jaeger_spans SET span_kind") {
+		updates[q.args[0].(string)] = len(q.args) - 1
+	}
+	if updates["server"] != 2 || updates["client"] != 1 {
+		t.Errorf("updates by kind = %v, want server:2 client:1", updates)
+	}
+
+	// 无法解析的行跳过但推进 lastID，避免重复扫描
+	n, lastID, err = store.backfillSpanKindBatch(context.Background(), lastID)
+	if err != nil || n != 0 || lastID != 7 {
+		t.Fatalf("second batch = (%d, %d, %v), want (0, 7, nil)", n, lastID, err)
+	}
+	n, next, err := store.backfillSpanKindBatch(context.Background(), lastID)
+	if err != nil || n != 0 || next != lastID {
+		t.Errorf("last batch = (%d, %d, %v), want (0, 7, nil)", n, next, err)
+	}
+
+	// 完整循环依次处理所有批次后退出
+	store.backfillSpanKind(context.Background())
+	if got := f.matching("SELECT id, tags"); len(got) != 6 {
+		t.Errorf("backfillSpanKind ran %d batch queries, want 6", len(got))
+	}
+}
+
+func TestBackfillSpanKindBatchUpdateError(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("SELECT id, tags", []string{"id", "tags"},
+		[]interface{}{int64(5), marshalTags([]model.KeyValue{model.String("span.kind", "server")})})
+	f.onError("UPDATE jaeger_spans SET span_kind", errors.New("index busy"))
+
+	_, lastID, err := reader.store.backfillSpanKindBatch(context.Background(), 2)
+	if err == nil || lastID != 2 {
+		t.Errorf("batch = (%d, %v), want error and lastID unchanged", lastID, err)
+	}
+}
+
+// operationsGroupColumns 是目录初始化 GROUP BY 查询的列
+var operationsGroupColumns = []string{"service_name", "operation_name", "span_kind", "last_seen"}
+
+func TestSeedOperationCatalog(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("GROUP BY service_name, operation_name, span_kind", operationsGroupColumns,
+		[]interface{}{"order", "GET /orders", "server", int64(100)},
+		[]interface{}{"order", "SELECT", "client", int64(200)},
+	)
+
+	if err := reader.store.seedOperationCatalog(context.Background()); err != nil {
+		t.Fatalf("seedOperationCatalog: %v", err)
+	}
+	writes := f.matching("REPLACE INTO jaeger_operations")
+	if len(writes) != 1 || len(writes[0].args) != 2*5 {
+		t.Fatalf("unexpected catalog writes %v", writes)
+	}
+	marks := f.matching("REPLACE INTO jaeger_state")
+	if len(marks) != 1 || marks[0].args[1] != stateOperationsCatalogSeeded {
+		t.Errorf("seeded marker not saved: %v", marks)
+	}
+}
+
+func TestSeedOperationCatalogSkipsWhenSeeded(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("FROM jaeger_state", []string{"value"}, []interface{}{int64(1)})
+
+	if err := reader.store.seedOperationCatalog(context.Background()); err != nil {
+		t.Fatalf("seedOperationCatalog: %v", err)
+	}
+	if n := len(f.matching("GROUP BY")); n != 0 {
+		t.Errorf("seeded catalog aggregated spans %d times", n)
+	}
+}
+
+func TestSeedOperationCatalogRetriesOnError(t *testing.T) {
+	old := operationsCatalogSeedRetry
+	operationsCatalogSeedRetry = time.Millisecond
+	t.Cleanup(func() { operationsCatalogSeedRetry = old })
+
+	f, reader := newTestReader(t)
+	attempts := 0
+	f.onQuery("GROUP BY service_name, operation_name, span_kind", operationsGroupColumns,
+		func(context.Context, []interface{}) ([][]interface{}, error) {
+			attempts++
+			if attempts == 1 {
+				return nil, errors.New("query timeout")
+			}
+			return [][]interface{}{{"order", "GET /orders", "server", int64(100)}}, nil
+		})
+
+	// 第一次失败不写标记，重试成功后写入一次
+	reader.store.seedOperationCatalogUntilDone(context.Background())
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+	if n := len(f.matching("REPLACE INTO jaeger_operations")); n != 1 {
+		t.Errorf("catalog writes = %d, want 1", n)
+	}
+	if n := len(f.matching("REPLACE INTO jaeger_state")); n != 1 {
+		t.Errorf("marker writes = %d, want 1", n)
+	}
+}
+
+func TestSeedOperationCatalogStopsOnClose(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.onError("GROUP BY", errors.New("query timeout"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// 已关闭时不再重试
+	reader.store.seedOperationCatalogUntilDone(ctx)
+	if n := len(f.matching("GROUP BY")); n > 1 {
+		t.Errorf("retried %d times after close", n)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// 有界 LRU 缓存
+// ====================
+
+// lruEntry 缓存条目
+type lruEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	cost      int
+	expiresAt time.Time
+}
+
+// lruCache 带容量上限和 TTL 的 LRU 缓存，并发安全
+// 每个条目有一个成本（默认为 1，即按条目数限制；也可按估算字节数限制），
+// 总成本超过容量时淘汰最久未使用的条目；过期条目在读取时删除
+type lruCache[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	used     int
+	ttl      time.Duration
+	cost     func(V) int
+	ll       *list.List // 头部为最近使用
+	items    map[K]*list.Element
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// cacheStats 缓存命中统计
+type cacheStats struct {
+	Size      int    `json:"size"`
+	Cost      int    `json:"cost"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// newLRUCache 创建按条目数限制的 LRU 缓存，capacity <= 0 时容量为 1
+func newLRUCache[K comparable, V any](capacity int, ttl time.Duration) *lruCache[K, V] {
+	return newCostLRUCache[K, V](capacity, ttl, nil)
+}
+
+// newCostLRUCache 创建按成本限制的 LRU 缓存，cost 为 nil 时每个条目成本为 1
+// 单个条目成本超过容量时不缓存
+func newCostLRUCache[K comparable, V any](capacity int, ttl time.Duration, cost func(V) int) *lruCache[K, V] {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	if cost == nil {
+		cost = func(V) int { return 1 }
+	}
+	return &lruCache[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		cost:     cost,
+		ll:       list.New(),
+		items:    make(map[K]*list.Element),
+	}
+}
+
+// Get 读取未过期的缓存值
+func (c *lruCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		return zero, false
+	}
+	entry := el.Value.(*lruEntry[K, V])
+	if !time.Now().Before(entry.expiresAt) {
+		c.removeElement(el)
+		c.misses.Add(1)
+		return zero, false
+	}
+	c.ll.MoveToFront(el)
+	c.hits.Add(1)
+	return entry.value, true
+}
+
+// Put 写入缓存，使用默认 TTL
+func (c *lruCache[K, V]) Put(key K, value V) {
+	c.PutWithTTL(key, value, c.ttl)
+}
+
+// PutWithTTL 写入缓存并指定 TTL
+func (c *lruCache[K, V]) PutWithTTL(key K, value V, ttl time.Duration) {
+	cost := c.cost(value)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	if cost > c.capacity {
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	c.items[key] = c.ll.PushFront(&lruEntry[K, V]{key: key, value: value, cost: cost, expiresAt: expiresAt})
+	c.used += cost
+	for c.used > c.capacity {
+		c.removeElement(c.ll.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Delete 删除指定 key
+func (c *lruCache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Purge 清空缓存
+func (c *lruCache[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[K]*list.Element)
+	c.used = 0
+}
+
+// Len 当前条目数（含尚未清理的过期条目）
+func (c *lruCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Stats 返回命中统计
+func (c *lruCache[K, V]) Stats() cacheStats {
+	c.mu.Lock()
+	size, used := c.ll.Len(), c.used
+	c.mu.Unlock()
+
+	return cacheStats{
+		Size:      size,
+		Cost:      used,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+}
+
+func (c *lruCache[K, V]) removeElement(el *list.Element) {
+	entry := el.Value.(*lruEntry[K, V])
+	c.ll.Remove(el)
+	c.used -= entry.cost
+	delete(c.items, entry.key)
+}
+
+// ====================
+// GetTrace 最近 trace 缓存
+// ====================
+
+var (
+	// trace 缓存的内存上限（按 protobuf 编码大小估算），<= 0 时禁用
+	traceCacheMaxBytes = getIntEnv("TRACE_CACHE_MAX_BYTES", 64<<20)
+	// trace 缓存过期时间
+	traceCacheTTL = getDurationEnv("TRACE_CACHE_TTL", 30*time.Second)
+	// 未找到的 trace 的缓存时间（避免重复查询，同时很快能看到新写入的数据）
+	traceCacheNotFoundTTL = getDurationEnv("TRACE_CACHE_NOT_FOUND_TTL", 2*time.Second)
+)
+
+// notFoundTraceCost 未找到结果的缓存成本
+const notFoundTraceCost = 64
+
+// newTraceCache 创建 trace 缓存，值为 nil 表示 trace 不存在
+func newTraceCache() *lruCache[model.TraceID, *model.Trace] {
+	if traceCacheMaxBytes <= 0 {
+		return nil
+	}
+	return newCostLRUCache[model.TraceID, *model.Trace](traceCacheMaxBytes, traceCacheTTL, func(t *model.Trace) int {
+		if t == nil {
+			return notFoundTraceCost
+		}
+		return t.Size()
+	})
+}
+
+// cachedTrace 从缓存读取 trace，found 为 false 表示缓存的是"不存在"
+func (s *MySQLStore) cachedTrace(traceID model.TraceID) (trace *model.Trace, found bool, ok bool) {
+	if s.traceCache == nil {
+		return nil, false, false
+	}
+	trace, ok = s.traceCache.Get(traceID)
+	return trace, trace != nil, ok
+}
+
+// traceGenStripes trace 写入代数的分片数
+const traceGenStripes = 256
+
+// traceGenerations 按 trace ID 分片的写入代数
+// 写入 span 只递增对应分片，其他 trace 正在进行的查询仍可写入缓存；
+// 代数校验与写入缓存、递增与清除缓存分别在同一把锁内完成，二者互斥
+type traceGenerations struct {
+	mu   sync.Mutex
+	gens [traceGenStripes]uint64
+}
+
+func traceGenStripe(traceID model.TraceID) int {
+	return int((traceID.High ^ traceID.Low) % traceGenStripes)
+}
+
+// traceCacheGeneration 返回 trace 当前的写入代数，查询前获取，写入缓存时校验
+func (s *MySQLStore) traceCacheGeneration(traceID model.TraceID) uint64 {
+	s.traceGens.mu.Lock()
+	defer s.traceGens.mu.Unlock()
+	return s.traceGens.gens[traceGenStripe(traceID)]
+}
+
+// cacheTrace 缓存 trace，trace 为 nil 时按未找到缓存（使用较短的 TTL）
+// 若查询期间该 trace 有新 span 写入（代数变化），结果可能已过时，不写入缓存
+func (s *MySQLStore) cacheTrace(traceID model.TraceID, trace *model.Trace, gen uint64) {
+	if s.traceCache == nil {
+		return
+	}
+	s.traceGens.mu.Lock()
+	defer s.traceGens.mu.Unlock()
+	if s.traceGens.gens[traceGenStripe(traceID)] != gen {
+		return
+	}
+	if trace == nil {
+		s.traceCache.PutWithTTL(traceID, nil, traceCacheNotFoundTTL)
+		return
+	}
+	s.traceCache.Put(traceID, trace)
+}
+
+// invalidateTraces 写入新 span 后清除对应 trace 的缓存
+func (s *MySQLStore) invalidateTraces(spans []*model.Span) {
+	if s.traceCache == nil {
+		return
+	}
+	s.traceGens.mu.Lock()
+	defer s.traceGens.mu.Unlock()
+	for _, span := range spans {
+		s.traceGens.gens[traceGenStripe(span.TraceID)]++
+		s.traceCache.Delete(span.TraceID)
+	}
+}
+
+// namedCacheStats 带名称的缓存统计
+type namedCacheStats struct {
+	name string
+	cacheStats
+}
+
+// cacheStats 返回所有缓存的统计，trace 缓存禁用时不包含
+func (s *MySQLStore) cacheStats() []namedCacheStats {
+	stats := []namedCacheStats{
+		{"services", s.servicesCache.Stats()},
+		{"operations", s.operationsCache.Stats()},
+	}
+	if s.traceCache != nil {
+		stats = append(stats, namedCacheStats{"traces", s.traceCache.Stats()})
+	}
+	return stats
+}
+
+// writeCachePrometheus 以 Prometheus 文本格式输出缓存统计
+func writeCachePrometheus(w io.Writer, stats []namedCacheStats) error {
+	bw := bufio.NewWriter(w)
+	metrics := []struct {
+		name, typ, help string
+		value           func(cacheStats) uint64
+	}{
+		{"jaeger_storage_cache_hits_total", "counter", "Cache lookups that found a live entry.",
+			func(c cacheStats) uint64 { return c.Hits }},
+		{"jaeger_storage_cache_misses_total", "counter", "Cache lookups that found no entry or an expired one.",
+			func(c cacheStats) uint64 { return c.Misses }},
+		{"jaeger_storage_cache_evictions_total", "counter", "Entries evicted to stay within capacity.",
+			func(c cacheStats) uint64 { return c.Evictions }},
+		{"jaeger_storage_cache_entries", "gauge", "Entries currently cached.",
+			func(c cacheStats) uint64 { return uint64(c.Size) }},
+		{"jaeger_storage_cache_cost", "gauge", "Total cost of cached entries (entries, or estimated bytes for traces).",
+			func(c cacheStats) uint64 { return uint64(c.Cost) }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, s := range stats {
+			fmt.Fprintf(bw, "%s{cache=\"%s\"} %d\n", m.name, s.name, m.value(s.cacheStats))
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache[string, int](2, time.Minute)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Get("a") // a 变为最近使用
+	c.Put("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %v, %v", v, ok)
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 {
+		t.Errorf("Get(c) = %v, %v", v, ok)
+	}
+
+	stats := c.Stats()
+	if stats.Size != 2 || stats.Hits != 3 || stats.Misses != 1 || stats.Evictions != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestLRUCacheExpires(t *testing.T) {
+	c := newLRUCache[string, int](10, time.Minute)
+	c.PutWithTTL("short", 1, -time.Second)
+	c.Put("long", 2)
+
+	if _, ok := c.Get("short"); ok {
+		t.Error("expired entry should not be returned")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len = %d, want expired entry removed", c.Len())
+	}
+
+	c.Delete("long")
+	if _, ok := c.Get("long"); ok {
+		t.Error("deleted entry should not be returned")
+	}
+}
+
+func TestNewOperationInvalidatesCaches(t *testing.T) {
+	f, reader := newTestReader(t)
+	store := reader.store
+	ctx := context.Background()
+
+	store.servicesCache.Put(servicesCacheKey, []string{"order"})
+	store.operationsCache.Put(operationsCacheKey{service: "order"}, []spanstore.Operation{{Name: "GET /orders"}})
+	store.operationsCache.Put(operationsCacheKey{service: "payment"}, []spanstore.Operation{{Name: "charge"}})
+
+	span := catalogSpan("order", "POST /orders", "server", time.Now())
+	if err := store.recordOperations(ctx, []*model.Span{span}); err != nil {
+		t.Fatalf("recordOperations: %v", err)
+	}
+
+	if _, ok := store.operationsCache.Get(operationsCacheKey{service: "order"}); ok {
+		t.Error("operations cache for order should be invalidated")
+	}
+	if _, ok := store.operationsCache.Get(operationsCacheKey{service: "payment"}); !ok {
+		t.Error("operations cache for payment should be kept")
+	}
+	if _, ok := store.servicesCache.Get(servicesCacheKey); ok {
+		t.Error("services cache should be invalidated for a new service")
+	}
+
+	// 已知服务的新操作不清除服务缓存
+	store.servicesCache.Put(servicesCacheKey, []string{"order"})
+	span = catalogSpan("order", "DELETE /orders", "server", time.Now())
+	if err := store.recordOperations(ctx, []*model.Span{span}); err != nil {
+		t.Fatalf("recordOperations: %v", err)
+	}
+	if _, ok := store.servicesCache.Get(servicesCacheKey); !ok {
+		t.Error("services cache should be kept for a known service")
+	}
+	if got := len(f.matching("REPLACE INTO jaeger_operations")); got != 2 {
+		t.Errorf("REPLACE count = %d, want 2", got)
+	}
+}
+
+func TestLRUCacheCostLimit(t *testing.T) {
+	c := newCostLRUCache[string, string](10, time.Minute, func(v string) int { return len(v) })
+	c.Put("a", "12345")
+	c.Put("b", "1234")
+	c.Put("c", "123") // 总成本 12 > 10，淘汰 a
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("a should have been evicted")
+	}
+	if stats := c.Stats(); stats.Cost != 7 {
+		t.Errorf("cost = %d, want 7", stats.Cost)
+	}
+
+	c.Put("huge", "12345678901") // 超过容量，不缓存
+	if _, ok := c.Get("huge"); ok {
+		t.Error("entry larger than capacity should not be cached")
+	}
+}
+
+func TestGetTraceUsesCache(t *testing.T) {
+	f, reader := newTestReader(t)
+	ctx := context.Background()
+	traceID := model.NewTraceID(0, 7)
+	span := &model.Span{
+		TraceID:       traceID,
+		SpanID:        model.NewSpanID(1),
+		OperationName: "op",
+		StartTime:     time.Unix(100, 0),
+		Process:       &model.Process{ServiceName: "svc"},
+	}
+	f.on("WHERE trace_id = ?", spanColumns, spanRow(span))
+
+	for i := 0; i < 3; i++ {
+		if _, err := reader.GetTrace(ctx, traceID); err != nil {
+			t.Fatalf("GetTrace: %v", err)
+		}
+	}
+	if got := len(f.matching("WHERE trace_id = ?")); got != 1 {
+		t.Errorf("queries = %d, want 1 (cached)", got)
+	}
+
+	// 写入新 span 后缓存失效
+	reader.store.invalidateTraces([]*model.Span{span})
+	if _, err := reader.GetTrace(ctx, traceID); err != nil {
+		t.Fatalf("GetTrace: %v", err)
+	}
+	if got := len(f.matching("WHERE trace_id = ?")); got != 2 {
+		t.Errorf("queries = %d, want 2 after invalidation", got)
+	}
+}
+
+func TestGetTraceCachesNotFound(t *testing.T) {
+	f, reader := newTestReader(t)
+	ctx := context.Background()
+	traceID := model.NewTraceID(0, 8)
+
+	for i := 0; i < 2; i++ {
+		if _, err := reader.GetTrace(ctx, traceID); err != spanstore.ErrTraceNotFound {
+			t.Fatalf("GetTrace err = %v, want ErrTraceNotFound", err)
+		}
+	}
+	if got := len(f.matching("WHERE trace_id = ?")); got != 1 {
+		t.Errorf("queries = %d, want 1 (not-found cached)", got)
+	}
+}
+
+func TestCacheTraceSkipsStaleGeneration(t *testing.T) {
+	_, reader := newTestReader(t)
+	store := reader.store
+	traceID := model.NewTraceID(0, 9)
+	span := &model.Span{TraceID: traceID, Process: &model.Process{}}
+
+	gen := store.traceCacheGeneration(traceID)
+	store.invalidateTraces([]*model.Span{span}) // 查询期间有写入
+	store.cacheTrace(traceID, &model.Trace{Spans: []*model.Span{span}}, gen)
+
+	if _, _, ok := store.cachedTrace(traceID); ok {
+		t.Error("stale result should not be cached")
+	}
+}
+
+func TestCacheTraceIgnoresWritesToOtherTraces(t *testing.T) {
+	_, reader := newTestReader(t)
+	store := reader.store
+	traceID, other := model.NewTraceID(0, 9), model.NewTraceID(0, 10)
+	span := &model.Span{TraceID: traceID, Process: &model.Process{}}
+
+	gen := store.traceCacheGeneration(traceID)
+	store.invalidateTraces([]*model.Span{{TraceID: other, Process: &model.Process{}}})
+	store.cacheTrace(traceID, &model.Trace{Spans: []*model.Span{span}}, gen)
+
+	if _, found, ok := store.cachedTrace(traceID); !ok || !found {
+		t.Error("write to another trace should not prevent caching")
+	}
+}
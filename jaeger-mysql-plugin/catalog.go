@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// 服务/操作目录（jaeger_operations）
+// ====================
+//
+// GetServices / GetOperations 原本在整张 jaeger_spans 上做 GROUP BY，
+// 表越大越慢。批量写入时把 (service, operation, span_kind) 写入小表
+// jaeger_operations，读取时只查这张表。
+// 写入端用内存集合去重：同一组合在 operationsRefreshInterval 内只写一次，
+// 因此 last_seen 的精度为该刷新间隔。
+
+var (
+	// 同一 (service, operation, span_kind) 重新写入 last_seen 的间隔
+	operationsRefreshInterval = getDurationEnv("OPERATIONS_REFRESH_INTERVAL", time.Hour)
+	// 读取时只返回 last_seen 在该时间范围内的服务和操作
+	operationsMaxAge = getDurationEnv("OPERATIONS_MAX_AGE", 7*24*time.Hour)
+)
+
+// catalogQueryLimit 目录查询最多返回的行数（ManticoreSearch 默认只返回 20 行）
+const catalogQueryLimit = 10000
+
+// operationKey 唯一标识一个操作
+type operationKey struct {
+	service   string
+	operation string
+	spanKind  string
+}
+
+// id 生成稳定的文档 ID，REPLACE 时覆盖同一行
+func (k operationKey) id() int64 {
+	return stableID(k.service, k.operation, k.spanKind)
+}
+
+// stableID 由若干字符串计算稳定的正整数文档 ID（FNV-64a），用于 REPLACE 覆盖同一行
+func stableID(parts ...string) int64 {
+	h := fnv.New64a()
+	for i, part := range parts {
+		if i > 0 {
+			h.Write([]byte{0})
+		}
+		h.Write([]byte(part))
+	}
+	id := int64(h.Sum64() & 0x7fffffffffffffff)
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
+
+// operationCatalog 记录已写入 jaeger_operations 的组合及写入时间
+type operationCatalog struct {
+	mu       sync.Mutex
+	written  map[operationKey]time.Time
+	services map[string]struct{}
+}
+
+func newOperationCatalog() *operationCatalog {
+	return &operationCatalog{
+		written:  make(map[operationKey]time.Time),
+		services: make(map[string]struct{}),
+	}
+}
+
+// pending 返回本批中需要写入（新出现或已超过刷新间隔）的组合及其最新 start_time
+func (c *operationCatalog) pending(spans []*model.Span, now time.Time) map[operationKey]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make(map[operationKey]int64)
+	for _, span := range spans {
+		key := operationKey{
+			service:   span.Process.ServiceName,
+			operation: span.OperationName,
+			spanKind:  spanKindOf(span.Tags),
+		}
+		if at, ok := c.written[key]; ok && now.Sub(at) < operationsRefreshInterval {
+			continue
+		}
+		if ts := span.StartTime.UnixNano(); ts > out[key] {
+			out[key] = ts
+		}
+	}
+	return out
+}
+
+// isNew 判断组合是否从未写入过（本进程内）
+func (c *operationCatalog) isNew(key operationKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.written[key]
+	return !ok
+}
+
+// hasService 判断服务是否已写入过（本进程内）
+func (c *operationCatalog) hasService(service string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.services[service]
+	return ok
+}
+
+// markWritten 标记组合已写入
+func (c *operationCatalog) markWritten(keys map[operationKey]int64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range keys {
+		c.written[key] = now
+		c.services[key.service] = struct{}{}
+	}
+}
+
+// recordOperations 将本批 span 中新出现的服务/操作写入 jaeger_operations
+func (s *MySQLStore) recordOperations(ctx context.Context, spans []*model.Span) error {
+	now := time.Now()
+	keys := s.catalog.pending(spans, now)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if err := upsertOperations(ctx, s.db, keys); err != nil {
+		return err
+	}
+
+	// 只为新出现的服务/操作清除缓存，刷新 last_seen 不影响缓存内容
+	newServices := make(map[string]bool)
+	for key := range keys {
+		if !s.catalog.isNew(key) {
+			continue
+		}
+		s.invalidateOperationsCache(key.service, key.spanKind)
+		if !s.catalog.hasService(key.service) {
+			newServices[key.service] = true
+		}
+	}
+	if len(newServices) > 0 {
+		s.invalidateServicesCache()
+	}
+
+	s.catalog.markWritten(keys, now)
+	return nil
+}
+
+// upsertOperations 以 REPLACE 写入目录行（id 由组合哈希得到，重复写入会覆盖）
+func upsertOperations(ctx context.Context, db *sql.DB, keys map[operationKey]int64) error {
+	var sb strings.Builder
+	sb.WriteString("REPLACE INTO jaeger_operations (id, service_name, operation_name, span_kind, last_seen) VALUES ")
+	args := make([]interface{}, 0, len(keys)*5)
+	i := 0
+	for key, lastSeen := range keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?)")
+		args = append(args, key.id(), key.service, key.operation, key.spanKind, lastSeen)
+		i++
+	}
+
+	if _, err := db.ExecContext(ctx, sb.String(), args...); err != nil {
+		return fmt.Errorf("upsert operations failed: %w", err)
+	}
+	return nil
+}
+
+// operationsCutoff 返回目录查询的 last_seen 下限
+func operationsCutoff() int64 {
+	return time.Now().Add(-operationsMaxAge).UnixNano()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func catalogSpan(service, operation, kind string, start time.Time) *model.Span {
+	return &model.Span{
+		TraceID:       model.NewTraceID(0, 1),
+		SpanID:        model.NewSpanID(1),
+		OperationName: operation,
+		StartTime:     start,
+		Tags:          []model.KeyValue{model.String("span.kind", kind)},
+		Process:       &model.Process{ServiceName: service},
+	}
+}
+
+func TestRecordOperationsWritesEachPairOnce(t *testing.T) {
+	f, reader := newTestReader(t)
+	store := reader.store
+	now := time.Now()
+
+	spans := []*model.Span{
+		catalogSpan("order", "GET /orders", "server", now),
+		catalogSpan("order", "GET /orders", "server", now.Add(time.Second)),
+		catalogSpan("order", "SELECT", "client", now),
+	}
+	if err := store.recordOperations(context.Background(), spans); err != nil {
+		t.Fatalf("recordOperations: %v", err)
+	}
+
+	writes := f.matching("REPLACE INTO jaeger_operations")
+	if len(writes) != 1 {
+		t.Fatalf("expected 1 REPLACE, got %d", len(writes))
+	}
+	if got := len(writes[0].args); got != 2*5 {
+		t.Errorf("REPLACE args = %d, want 2 rows", got)
+	}
+
+	// 相同组合在刷新间隔内不再写入
+	if err := store.recordOperations(context.Background(), spans[:1]); err != nil {
+		t.Fatalf("recordOperations: %v", err)
+	}
+	if got := len(f.matching("REPLACE INTO jaeger_operations")); got != 1 {
+		t.Errorf("expected no additional REPLACE, got %d total", got)
+	}
+}
+
+func TestRecordOperationsRetriesAfterFailure(t *testing.T) {
+	f, reader := newTestReader(t)
+	store := reader.store
+	f.onError("REPLACE INTO jaeger_operations", context.DeadlineExceeded)
+
+	spans := []*model.Span{catalogSpan("order", "GET /orders", "server", time.Now())}
+	if err := store.recordOperations(context.Background(), spans); err == nil {
+		t.Fatal("expected error")
+	}
+
+	keys := store.catalog.pending(spans, time.Now())
+	if len(keys) != 1 {
+		t.Errorf("failed write should stay pending, got %d keys", len(keys))
+	}
+}
+
+func TestWriteSpanDirectRecordsOperations(t *testing.T) {
+	f, reader := newTestReader(t)
+	// 目录查询返回已写入 jaeger_operations 的服务
+	f.onQuery("FROM jaeger_operations", []string{"service_name"}, func(context.Context, []interface{}) ([][]interface{}, error) {
+		var rows [][]interface{}
+		for _, q := range f.matching("REPLACE INTO jaeger_operations") {
+			for i := 0; i+4 < len(q.args); i += 5 {
+				rows = append(rows, []interface{}{q.args[i+1]})
+			}
+		}
+		return rows, nil
+	})
+
+	// 关闭后写入走直接写入路径
+	if err := reader.store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	span := catalogSpan("billing", "POST /invoices", "server", time.Now())
+	if err := reader.store.SpanWriter().WriteSpan(context.Background(), span); err != nil {
+		t.Fatalf("WriteSpan: %v", err)
+	}
+	if n := len(f.matching("INSERT INTO jaeger_spans")); n != 1 {
+		t.Fatalf("expected 1 direct INSERT, got %d", n)
+	}
+
+	services, err := reader.GetServices(context.Background())
+	if err != nil {
+		t.Fatalf("GetServices: %v", err)
+	}
+	if len(services) != 1 || services[0] != "billing" {
+		t.Errorf("services = %v, want [billing]", services)
+	}
+}
+
+func TestOperationKeyIDStable(t *testing.T) {
+	a := operationKey{service: "s", operation: "op", spanKind: "server"}
+	b := operationKey{service: "s", operation: "op", spanKind: "client"}
+	if a.id() != a.id() {
+		t.Error("id should be deterministic")
+	}
+	if a.id() == b.id() {
+		t.Error("different keys should have different ids")
+	}
+	if a.id() <= 0 {
+		t.Errorf("id = %d, want positive", a.id())
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// trace 关键路径分析
+// ====================
+//
+// 关键路径是决定端到端耗时的 span 链：从根 span 的结束时间向前回溯，每次选择
+// 在当前时间点之前最后结束的子 span 进入其内部继续回溯，子 span 之间的空隙
+// 计入父 span 自身。与选中子 span 重叠、但结束得更晚的兄弟 span 不在关键路径上
+// （与 Jaeger UI 的算法一致）。
+//
+// 引用关系的处理：
+//   - CHILD_OF 子 span 截断到父 span 的时间范围内（容忍时钟偏差）
+//   - FOLLOWS_FROM 子 span 是异步的，可以在父 span 结束后才结束。父 span 的时间窗口
+//     延长到异步后代的结束时间，父 span 结束到异步 span 开始之间的等待（如消息排队）
+//     计入父 span
+//
+// 每个 span 的 contribution 是它在关键路径上的独占时间，selfTime 是它未被任何
+// 子 span 覆盖的时间（不论是否在关键路径上）。
+
+// spanNode trace 树中的一个 span
+type spanNode struct {
+	span     *model.Span
+	parent   *spanNode
+	async    bool // 与父 span 的引用为 FOLLOWS_FROM
+	children []*spanNode
+}
+
+func (n *spanNode) start() int64 { return n.span.StartTime.UnixNano() }
+func (n *spanNode) end() int64   { return n.span.StartTime.Add(n.span.Duration).UnixNano() }
+
+// buildSpanTree 按引用关系构建 span 树，返回按开始时间排序的根节点
+// 父 span 优先取 CHILD_OF 引用，只有 FOLLOWS_FROM 时取第一个 FOLLOWS_FROM；父 span 不在 trace 中的视为根
+func buildSpanTree(trace *model.Trace) []*spanNode {
+	nodes := make(map[model.SpanID]*spanNode, len(trace.Spans))
+	for _, span := range trace.Spans {
+		if _, ok := nodes[span.SpanID]; !ok {
+			nodes[span.SpanID] = &spanNode{span: span}
+		}
+	}
+
+	var roots []*spanNode
+	for _, span := range trace.Spans {
+		node := nodes[span.SpanID]
+		if node.span != span {
+			continue // 重复的 span ID
+		}
+		var parentRef *model.SpanRef
+		for i := range span.References {
+			ref := &span.References[i]
+			if ref.TraceID != span.TraceID || ref.SpanID == span.SpanID {
+				continue
+			}
+			if _, ok := nodes[ref.SpanID]; !ok {
+				continue
+			}
+			if parentRef == nil || (ref.RefType == model.SpanRefType_CHILD_OF && parentRef.RefType != model.SpanRefType_CHILD_OF) {
+				parentRef = ref
+			}
+		}
+		if parentRef == nil || createsCycle(nodes[parentRef.SpanID], node) {
+			roots = append(roots, node)
+			continue
+		}
+		node.parent = nodes[parentRef.SpanID]
+		node.async = parentRef.RefType == model.SpanRefType_FOLLOWS_FROM
+		node.parent.children = append(node.parent.children, node)
+	}
+
+	sortSpanNodes(roots)
+	for _, node := range nodes {
+		sortSpanNodes(node.children)
+	}
+	return roots
+}
+
+// sortSpanNodes 按开始时间排序，同时开始的较长 span 在前
+func sortSpanNodes(nodes []*spanNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].start() != nodes[j].start() {
+			return nodes[i].start() < nodes[j].start()
+		}
+		return nodes[i].end() > nodes[j].end()
+	})
+}
+
+// createsCycle 判断把 child 挂到 parent 下是否会形成环
+func createsCycle(parent, child *spanNode) bool {
+	for p := parent; p != nil; p = p.parent {
+		if p == child {
+			return true
+		}
+	}
+	return false
+}
+
+// criticalPathSegment 关键路径上的一段，时间为纳秒
+type criticalPathSegment struct {
+	SpanID    string `json:"spanID"`
+	Service   string `json:"service"`
+	Operation string `json:"operation"`
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+}
+
+// criticalPathSpan 单个 span 的耗时构成，时间为纳秒
+type criticalPathSpan struct {
+	SpanID       string `json:"spanID"`
+	Service      string `json:"service"`
+	Operation    string `json:"operation"`
+	Duration     int64  `json:"duration"`
+	SelfTime     int64  `json:"selfTime"`
+	Contribution int64  `json:"contribution"` // 关键路径上的独占时间
+}
+
+// criticalPathService 按服务汇总，时间为纳秒
+type criticalPathService struct {
+	Service      string  `json:"service"`
+	SelfTime     int64   `json:"selfTime"`
+	Contribution int64   `json:"contribution"`
+	Percent      float64 `json:"percent"` // contribution 占端到端耗时的百分比
+}
+
+// criticalPath 关键路径分析结果
+type criticalPath struct {
+	TraceID  string                `json:"traceID"`
+	RootSpan string                `json:"rootSpanID"`
+	Start    int64                 `json:"start"`
+	Duration int64                 `json:"duration"` // 端到端耗时（含异步后代）
+	Segments []criticalPathSegment `json:"segments"` // 按时间顺序
+	Spans    []criticalPathSpan    `json:"spans"`    // 按 contribution 降序，只含关键路径上的 span
+	Services []criticalPathService `json:"services"` // 按 contribution 降序
+}
+
+// criticalPathWalker 关键路径计算状态
+type criticalPathWalker struct {
+	asyncEnd map[*spanNode]int64 // 异步后代的最晚结束时间
+	segments []criticalPathSegment
+}
+
+// computeAsyncEnd 计算每个节点经 FOLLOWS_FROM 延伸的最晚结束时间（不含自身）
+func (w *criticalPathWalker) computeAsyncEnd(node *spanNode) int64 {
+	var latest int64
+	for _, child := range node.children {
+		childEnd := w.computeAsyncEnd(child)
+		if child.async && child.end() > childEnd {
+			childEnd = child.end()
+		}
+		if childEnd > latest {
+			latest = childEnd
+		}
+	}
+	w.asyncEnd[node] = latest
+	return latest
+}
+
+// windowEnd 返回节点的时间窗口结束时间（自身结束或异步后代结束，取较晚者）
+func (w *criticalPathWalker) windowEnd(node *spanNode) int64 {
+	if end := w.asyncEnd[node]; end > node.end() {
+		return end
+	}
+	return node.end()
+}
+
+// walk 在 [node.start, end] 内从后向前回溯，segments 按时间倒序追加
+func (w *criticalPathWalker) walk(node *spanNode, end int64) {
+	type candidate struct {
+		node       *spanNode
+		start, end int64
+	}
+	start := node.start()
+	candidates := make([]candidate, 0, len(node.children))
+	for _, child := range node.children {
+		// CHILD_OF 截断到父 span 结束；其异步后代和 FOLLOWS_FROM 子 span 可以延伸到窗口结束
+		cEnd := child.end()
+		if !child.async && cEnd > node.end() {
+			cEnd = node.end()
+		}
+		if ext := w.asyncEnd[child]; ext > cEnd {
+			cEnd = ext
+		}
+		if cEnd > end {
+			cEnd = end
+		}
+		cStart := child.start()
+		if cStart < start {
+			cStart = start
+		}
+		if cEnd <= cStart {
+			continue
+		}
+		candidates = append(candidates, candidate{node: child, start: cStart, end: cEnd})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].end > candidates[j].end })
+
+	cursor := end
+	for _, c := range candidates {
+		if c.end > cursor {
+			continue // 与已选中的子 span 重叠
+		}
+		if c.end < cursor {
+			w.addSegment(node, c.end, cursor)
+		}
+		w.walk(c.node, c.end)
+		cursor = c.start
+		if cursor <= start {
+			break
+		}
+	}
+	if cursor > start {
+		w.addSegment(node, start, cursor)
+	}
+}
+
+func (w *criticalPathWalker) addSegment(node *spanNode, start, end int64) {
+	w.segments = append(w.segments, criticalPathSegment{
+		SpanID:    node.span.SpanID.String(),
+		Service:   node.span.Process.ServiceName,
+		Operation: node.span.OperationName,
+		Start:     start,
+		End:       end,
+	})
+}
+
+// spanSelfTime 返回 span 未被任何子 span 覆盖的时间
+func spanSelfTime(node *spanNode) int64 {
+	start, end := node.start(), node.end()
+	type interval struct{ start, end int64 }
+	covered := make([]interval, 0, len(node.children))
+	for _, child := range node.children {
+		s, e := child.start(), child.end()
+		if s < start {
+			s = start
+		}
+		if e > end {
+			e = end
+		}
+		if e > s {
+			covered = append(covered, interval{s, e})
+		}
+	}
+	sort.Slice(covered, func(i, j int) bool { return covered[i].start < covered[j].start })
+
+	self := end - start
+	var curStart, curEnd int64
+	for i, iv := range covered {
+		if i == 0 || iv.start > curEnd {
+			self -= curEnd - curStart
+			curStart, curEnd = iv.start, iv.end
+		} else if iv.end > curEnd {
+			curEnd = iv.end
+		}
+	}
+	self -= curEnd - curStart
+	return self
+}
+
+// computeCriticalPath 计算 trace 的关键路径
+func computeCriticalPath(trace *model.Trace) *criticalPath {
+	roots := buildSpanTree(trace)
+	if len(roots) == 0 {
+		return nil
+	}
+	// 多个根（父 span 缺失）时取最早开始的
+	root := roots[0]
+
+	w := &criticalPathWalker{asyncEnd: make(map[*spanNode]int64)}
+	w.computeAsyncEnd(root)
+	end := w.windowEnd(root)
+	w.walk(root, end)
+
+	// 按时间顺序排列，合并同一 span 的相邻片段
+	segments := make([]criticalPathSegment, 0, len(w.segments))
+	for i := len(w.segments) - 1; i >= 0; i-- {
+		seg := w.segments[i]
+		if n := len(segments); n > 0 && segments[n-1].SpanID == seg.SpanID && segments[n-1].End == seg.Start {
+			segments[n-1].End = seg.End
+			continue
+		}
+		segments = append(segments, seg)
+	}
+
+	result := &criticalPath{
+		TraceID:  root.span.TraceID.String(),
+		RootSpan: root.span.SpanID.String(),
+		Start:    root.start(),
+		Duration: end - root.start(),
+		Segments: segments,
+	}
+
+	// 每个 span 的独占时间
+	contributions := make(map[string]int64)
+	for _, seg := range segments {
+		contributions[seg.SpanID] += seg.End - seg.Start
+	}
+	services := make(map[string]*criticalPathService)
+	var visit func(node *spanNode)
+	visit = func(node *spanNode) {
+		id := node.span.SpanID.String()
+		service := node.span.Process.ServiceName
+		self := spanSelfTime(node)
+		svc, ok := services[service]
+		if !ok {
+			svc = &criticalPathService{Service: service}
+			services[service] = svc
+		}
+		svc.SelfTime += self
+		if c := contributions[id]; c > 0 {
+			svc.Contribution += c
+			result.Spans = append(result.Spans, criticalPathSpan{
+				SpanID:       id,
+				Service:      service,
+				Operation:    node.span.OperationName,
+				Duration:     node.span.Duration.Nanoseconds(),
+				SelfTime:     self,
+				Contribution: c,
+			})
+		}
+		for _, child := range node.children {
+			visit(child)
+		}
+	}
+	visit(root)
+
+	sort.SliceStable(result.Spans, func(i, j int) bool { return result.Spans[i].Contribution > result.Spans[j].Contribution })
+	for _, svc := range services {
+		if result.Duration > 0 {
+			svc.Percent = float64(svc.Contribution) * 100 / float64(result.Duration)
+		}
+		result.Services = append(result.Services, *svc)
+	}
+	sort.Slice(result.Services, func(i, j int) bool {
+		if result.Services[i].Contribution != result.Services[j].Contribution {
+			return result.Services[i].Contribution > result.Services[j].Contribution
+		}
+		return result.Services[i].Service < result.Services[j].Service
+	})
+	return result
+}
+
+// CriticalPath 读取 trace 并计算关键路径，trace 不存在时返回 spanstore.ErrTraceNotFound
+func (r *MySQLSpanReader) CriticalPath(ctx context.Context, traceID model.TraceID) (*criticalPath, error) {
+	started := time.Now()
+	trace, err := r.GetTrace(ctx, traceID)
+	if err != nil {
+		return nil, err
+	}
+	result := computeCriticalPath(trace)
+	r.logger.Debug().Str("trace_id", traceID.String()).Int("spans", len(trace.Spans)).
+		Dur("elapsed", time.Since(started)).Msg("Critical path computed")
+	return result, nil
+}
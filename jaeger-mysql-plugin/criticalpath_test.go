@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// treeSpan 构造 trace 1 中的 span，时间单位为毫秒，parent 为 0 表示根 span
+func treeSpan(id, parent uint64, refType model.SpanRefType, service, operation string, start, duration int64) *model.Span {
+	traceID := model.NewTraceID(0, 1)
+	span := &model.Span{
+		TraceID:       traceID,
+		SpanID:        model.NewSpanID(id),
+		OperationName: operation,
+		StartTime:     time.UnixMilli(1000 + start),
+		Duration:      time.Duration(duration) * time.Millisecond,
+		Process:       &model.Process{ServiceName: service},
+	}
+	if parent != 0 {
+		span.References = []model.SpanRef{{TraceID: traceID, SpanID: model.NewSpanID(parent), RefType: refType}}
+	}
+	return span
+}
+
+// segmentSummary 将关键路径片段转换为 "spanID:开始-结束"（毫秒，相对 trace 开始）
+func segmentSummary(path *criticalPath) []string {
+	var out []string
+	for _, seg := range path.Segments {
+		out = append(out, strings.TrimLeft(seg.SpanID, "0")+":"+
+			time.Duration(seg.Start-path.Start).String()+"-"+time.Duration(seg.End-path.Start).String())
+	}
+	return out
+}
+
+func TestComputeCriticalPathOverlappingChildren(t *testing.T) {
+	childOf := model.SpanRefType_CHILD_OF
+	trace := &model.Trace{Spans: []*model.Span{
+		treeSpan(1, 0, childOf, "gateway", "GET /checkout", 0, 100),
+		treeSpan(2, 1, childOf, "order", "load", 10, 30),     // 与 3 重叠且更早结束，不在关键路径上
+		treeSpan(3, 1, childOf, "payment", "charge", 30, 60), // [30, 90]
+		treeSpan(4, 3, childOf, "mysql", "INSERT", 40, 40),   // [40, 80]
+	}}
+
+	path := computeCriticalPath(trace)
+	want := []string{"1:0s-30ms", "3:30ms-40ms", "4:40ms-80ms", "3:80ms-90ms", "1:90ms-100ms"}
+	if got := segmentSummary(path); !reflect.DeepEqual(got, want) {
+		t.Errorf("segments = %v, want %v", got, want)
+	}
+	if path.Duration != (100 * time.Millisecond).Nanoseconds() {
+		t.Errorf("duration = %v", time.Duration(path.Duration))
+	}
+
+	contributions := make(map[string]time.Duration)
+	selfTimes := make(map[string]time.Duration)
+	for _, s := range path.Spans {
+		contributions[strings.TrimLeft(s.SpanID, "0")] = time.Duration(s.Contribution)
+		selfTimes[strings.TrimLeft(s.SpanID, "0")] = time.Duration(s.SelfTime)
+	}
+	if contributions["1"] != 40*time.Millisecond || contributions["3"] != 20*time.Millisecond || contributions["4"] != 40*time.Millisecond {
+		t.Errorf("contributions = %v", contributions)
+	}
+	if _, ok := contributions["2"]; ok {
+		t.Error("span 2 should not be on the critical path")
+	}
+	// gateway 的 self time：100ms 减去子 span 覆盖的 [10, 90]
+	if selfTimes["1"] != 20*time.Millisecond {
+		t.Errorf("self time of root = %v, want 20ms", selfTimes["1"])
+	}
+
+	var order *criticalPathService
+	for i := range path.Services {
+		if path.Services[i].Service == "order" {
+			order = &path.Services[i]
+		}
+	}
+	if order == nil || order.Contribution != 0 || order.SelfTime != (30*time.Millisecond).Nanoseconds() {
+		t.Errorf("unexpected order service summary %+v", order)
+	}
+}
+
+func TestComputeCriticalPathAsync(t *testing.T) {
+	trace := &model.Trace{Spans: []*model.Span{
+		treeSpan(1, 0, model.SpanRefType_CHILD_OF, "api", "POST /orders", 0, 50),
+		treeSpan(2, 1, model.SpanRefType_CHILD_OF, "api", "publish", 40, 5),
+		// 异步消费者在生产者结束后才开始
+		treeSpan(3, 2, model.SpanRefType_FOLLOWS_FROM, "worker", "consume", 70, 30),
+		// CHILD_OF 子 span 超出父 span 的部分被截断
+		treeSpan(4, 1, model.SpanRefType_CHILD_OF, "audit", "log", 45, 100),
+	}}
+
+	path := computeCriticalPath(trace)
+	if path.Duration != (100 * time.Millisecond).Nanoseconds() {
+		t.Errorf("duration = %v, want 100ms (including async consumer)", time.Duration(path.Duration))
+	}
+	want := []string{"1:0s-40ms", "2:40ms-70ms", "3:70ms-100ms"}
+	if got := segmentSummary(path); !reflect.DeepEqual(got, want) {
+		t.Errorf("segments = %v, want %v", got, want)
+	}
+	if path.Services[0].Service != "api" || path.Services[0].Contribution != (70*time.Millisecond).Nanoseconds() {
+		t.Errorf("unexpected services %+v", path.Services)
+	}
+}
+
+func TestBuildSpanTreeCycle(t *testing.T) {
+	childOf := model.SpanRefType_CHILD_OF
+	trace := &model.Trace{Spans: []*model.Span{
+		treeSpan(1, 2, childOf, "a", "x", 0, 10),
+		treeSpan(2, 1, childOf, "b", "y", 0, 10),
+	}}
+	roots := buildSpanTree(trace)
+	if len(roots) != 1 || len(roots[0].children) != 1 {
+		t.Fatalf("cycle not broken: %d roots", len(roots))
+	}
+}
+
+func TestCriticalPathEndpoint(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("WHERE trace_id = ?", spanColumns,
+		spanRow(treeSpan(1, 0, model.SpanRefType_CHILD_OF, "gateway", "GET /", 0, 10)))
+	handler := newAPIServer(reader.store).handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/traces/critical-path?traceID=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/traces/critical-path", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("missing traceID: status = %d", rec.Code)
+	}
+
+	if _, err := reader.CriticalPath(context.Background(), model.NewTraceID(0, 1)); err != nil {
+		t.Errorf("CriticalPath: %v", err)
+	}
+}
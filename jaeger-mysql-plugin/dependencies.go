@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// 依赖关系预聚合（jaeger_dependencies）
+// ====================
+//
+// GetDependencies 原本把时间窗口内所有 span 读入内存再统计父子服务关系，
+// 7 天窗口足以撑爆插件的内存限制。后台任务按固定时间桶（默认 1 小时）计算
+// parent→child 调用次数写入 jaeger_dependencies，读取时只需对窗口覆盖的桶求和。
+//
+// 子 span 落在桶内才计数；父 span 可能早于桶开始，因此读取 span 时向前多取
+// dependenciesBucketOverlap。每轮重新计算最近一个已聚合的桶及其之前的
+// dependenciesLateBuckets 个桶（REPLACE 覆盖），以包含延迟写入的 span；
+// 多个插件实例同时计算同一个桶结果相同，互不影响。
+//
+// 已聚合的范围记录在 jaeger_state（最早和最近的桶），不从 jaeger_dependencies
+// 推断：没有调用的桶不写入任何行，但同样推进进度。读取时窗口中不在已聚合范围内的
+// 部分（例如升级后只回填了 DEPENDENCIES_BACKFILL 而查询 7 天）从 span 现算。
+
+var (
+	// 是否启用后台依赖聚合，启用后 GetDependencies 读取聚合结果，未聚合的部分现算
+	dependenciesJobEnabled = getBoolEnv("DEPENDENCIES_JOB", true)
+	// 聚合时间桶大小
+	dependenciesBucket = getDurationEnv("DEPENDENCIES_BUCKET", time.Hour)
+	// 聚合任务运行间隔
+	dependenciesJobInterval = getDurationEnv("DEPENDENCIES_JOB_INTERVAL", 5*time.Minute)
+	// 首次运行时向前聚合的时间范围
+	dependenciesBackfill = getDurationEnv("DEPENDENCIES_BACKFILL", 24*time.Hour)
+	// 每轮除最近一个已聚合的桶外再重新计算的之前的桶数，用于计入延迟写入的 span
+	dependenciesLateBuckets = getIntEnv("DEPENDENCIES_LATE_BUCKETS", 1)
+	// 读取 span 时向桶开始之前多取的时间，用于找到更早开始的父 span
+	dependenciesBucketOverlap = getDurationEnv("DEPENDENCIES_BUCKET_OVERLAP", 10*time.Minute)
+	// 计算依赖时每次读取的 span 数
+	dependenciesScanBatch = getIntEnv("DEPENDENCIES_SCAN_BATCH", 5000)
+	// 单次计算最多扫描的 span 数，超过后返回部分结果，<= 0 表示不限制；
+	// 扩展依赖 API 拒绝 span 数超过该值的时间窗口
+	dependenciesMaxSpans = getIntEnv("DEPENDENCIES_MAX_SPANS", 5000000)
+	// 单个 trace 最多读取的 span 数
+	dependenciesMaxTraceSpans = getIntEnv("DEPENDENCIES_MAX_TRACE_SPANS", 50000)
+)
+
+// dependencyKey 一条 parent→child 依赖边
+type dependencyKey struct {
+	parent string
+	child  string
+}
+
+// dependencyCounts 依赖边的调用次数
+type dependencyCounts map[dependencyKey]uint64
+
+// merge 累加另一组调用次数
+func (c dependencyCounts) merge(other dependencyCounts) {
+	for key, count := range other {
+		c[key] += count
+	}
+}
+
+// links 转换为 DependencyLink，按 parent/child 排序
+func (c dependencyCounts) links() []model.DependencyLink {
+	deps := make([]model.DependencyLink, 0, len(c))
+	for key, count := range c {
+		deps = append(deps, model.DependencyLink{Parent: key.parent, Child: key.child, CallCount: count})
+	}
+	sort.Slice(deps, func(i, j int) bool {
+		if deps[i].Parent != deps[j].Parent {
+			return deps[i].Parent < deps[j].Parent
+		}
+		return deps[i].Child < deps[j].Child
+	})
+	return deps
+}
+
+// depRef span 对另一个 span 的引用
+type depRef struct {
+	key     string // 被引用 span 的 traceID:spanID
+	refType model.SpanRefType
+}
+
+// depSpan 计算依赖所需的 span 信息
+type depSpan struct {
+	traceID   string // 扫描顺序和分页的依据
+	key       string
+	service   string
+	operation string
+	startTime int64
+	duration  int64
+	isError   bool
+	refs      []depRef
+	peer      string // 客户端 span 的调用对象（见 virtualPeerOf），用于生成虚拟依赖边
+	virtual   bool   // 虚拟叶子节点，不对应实际的 span
+}
+
+// dependencyVisitor 处理 trace 内的一条引用，parent 与 child 可能属于同一服务
+type dependencyVisitor func(parent, child *depSpan, refType model.SpanRefType)
+
+// countDependencies 统计子 span 的 start_time 在 [childFrom, to) 内的跨服务 CHILD_OF 调用，
+// 读取 [from, to) 内的 span（from 可早于 childFrom，用于找到父 span）。
+// 虚拟边只在启用 dependenciesVirtualEdges 时计入
+func countDependencies(ctx context.Context, s *MySQLStore, from, childFrom, to time.Time) (dependencyCounts, error) {
+	counts := make(dependencyCounts)
+	_, err := scanDependencies(ctx, s, from, childFrom, to, func(parent, child *depSpan, refType model.SpanRefType) {
+		if child.virtual && !dependenciesVirtualEdges {
+			return
+		}
+		if refType == model.SpanRefType_CHILD_OF && parent.service != child.service {
+			counts[dependencyKey{parent: parent.service, child: child.service}]++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// scanDependencies 对子 span 的 start_time 在 [childFrom, to) 内的每条引用调用 visit
+//
+// 按 trace_id 排序分页流式处理：同一 trace 的 span 总是相邻，每处理完一个 trace
+// 即释放，峰值内存取决于页大小和单个 trace 的大小，而不是时间窗口。页末可能不完整
+// 的 trace 丢弃，下一页从上一个完整 trace 之后（trace_id > ?）开始。扫描的 span 数
+// 超过 dependenciesMaxSpans 或单个 trace 超过 dependenciesMaxTraceSpans 时
+// 只处理已读取的部分，记录警告并返回 truncated。
+func scanDependencies(ctx context.Context, s *MySQLStore, from, childFrom, to time.Time, visit dependencyVisitor) (truncated bool, err error) {
+	childFromNanos := childFrom.UnixNano()
+	scanned := 0
+
+	after := ""
+	for {
+		page, err := s.scanDependencySpans(ctx, from, to, "trace_id > ?", after, dependenciesScanBatch)
+		if err != nil {
+			return false, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		full := len(page) == dependenciesScanBatch
+		lastTrace := page[len(page)-1].traceID
+
+		switch {
+		case !full:
+			countDependencyGroups(page, childFromNanos, visit)
+		case page[0].traceID == lastTrace:
+			// 一个 trace 就超过一页：单独读取这个 trace（有上限）
+			group, err := s.scanDependencySpans(ctx, from, to, "trace_id = ?", lastTrace, dependenciesMaxTraceSpans)
+			if err != nil {
+				return false, err
+			}
+			if len(group) == dependenciesMaxTraceSpans {
+				truncated = true
+				s.logger.Warn().Int("limit", dependenciesMaxTraceSpans).Str("trace_id", lastTrace).
+					Msg("Trace too large for dependency calculation, truncated")
+			}
+			countDependencyGroups(group, childFromNanos, visit)
+			page = group
+		default:
+			// 丢弃页末可能不完整的 trace，下一页从它开始
+			cut := len(page)
+			for cut > 0 && page[cut-1].traceID == lastTrace {
+				cut--
+			}
+			page = page[:cut]
+			countDependencyGroups(page, childFromNanos, visit)
+		}
+
+		scanned += len(page)
+		if !full {
+			break
+		}
+		if dependenciesMaxSpans > 0 && scanned >= dependenciesMaxSpans {
+			s.logger.Warn().Int("scanned", scanned).Int("limit", dependenciesMaxSpans).
+				Time("from", from).Time("to", to).
+				Msg("Dependency scan limit reached, returning partial results")
+			return true, nil
+		}
+		after = page[len(page)-1].traceID
+	}
+	return truncated, nil
+}
+
+// scanDependencySpans 按 trace_id 顺序读取一页 span，cond 为 trace_id 上的条件
+func (s *MySQLStore) scanDependencySpans(ctx context.Context, from, to time.Time, cond, traceID string, limit int) ([]depSpan, error) {
+	query := fmt.Sprintf(`
+		SELECT trace_id, span_id, operation_name, refs, service_name, start_time, duration, is_error,
+			span_kind, tag_attrs
+		FROM jaeger_spans
+		WHERE start_time >= ? AND start_time < ? AND %s
+		ORDER BY trace_id ASC
+		LIMIT %d OPTION max_matches=%d
+	`, cond, limit, limit)
+	rows, err := s.db.QueryContext(ctx, query, from.UnixNano(), to.UnixNano(), traceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := make([]depSpan, 0, limit)
+	for rows.Next() {
+		var (
+			span             depSpan
+			spanID, refsJSON string
+			isError          int
+			kind             string
+			attrs            sql.NullString
+		)
+		if err := rows.Scan(&span.traceID, &spanID, &span.operation, &refsJSON, &span.service,
+			&span.startTime, &span.duration, &isError, &kind, &attrs); err != nil {
+			continue
+		}
+		span.key = span.traceID + ":" + spanID
+		span.isError = isError != 0
+		span.refs = parseDepRefs(span.traceID, refsJSON)
+		span.peer = virtualPeerOf(kind, span.service, attrs.String)
+		page = append(page, span)
+	}
+	return page, rows.Err()
+}
+
+// countDependencyGroups 按 trace 分组统计（spans 已按 trace_id 排序），每组处理完即释放索引
+func countDependencyGroups(spans []depSpan, childFrom int64, visit dependencyVisitor) {
+	for start := 0; start < len(spans); {
+		end := start + 1
+		for end < len(spans) && spans[end].traceID == spans[start].traceID {
+			end++
+		}
+		countDependencyGroup(spans[start:end], childFrom, visit)
+		start = end
+	}
+}
+
+// countDependencyGroup 遍历一个 trace 内能找到被引用 span 的引用，
+// 以及没有子 span 的客户端 span 产生的虚拟边
+func countDependencyGroup(group []depSpan, childFrom int64, visit dependencyVisitor) {
+	byKey := make(map[string]*depSpan, len(group))
+	for i := range group {
+		byKey[group[i].key] = &group[i]
+	}
+	hasChild := make(map[*depSpan]bool)
+	for i := range group {
+		span := &group[i]
+		for _, ref := range span.refs {
+			parent, ok := byKey[ref.key]
+			if !ok {
+				continue
+			}
+			hasChild[parent] = true
+			if span.startTime >= childFrom {
+				visit(parent, span, ref.refType)
+			}
+		}
+	}
+	visitVirtualEdges(group, hasChild, childFrom, visit)
+}
+
+// parseDepRefs 从 refs JSON 中解析 CHILD_OF 和 FOLLOWS_FROM 引用
+func parseDepRefs(traceID, refsJSON string) []depRef {
+	var refs []model.SpanRef
+	if err := json.Unmarshal([]byte(refsJSON), &refs); err != nil {
+		return nil
+	}
+	out := make([]depRef, 0, len(refs))
+	for _, ref := range refs {
+		out = append(out, depRef{key: traceID + ":" + ref.SpanID.String(), refType: ref.RefType})
+	}
+	return out
+}
+
+// ====================
+// 后台聚合任务
+// ====================
+
+// bucketStart 返回 t 所在桶的开始时间
+func bucketStart(t time.Time) time.Time {
+	return t.Truncate(dependenciesBucket)
+}
+
+// dependencyJob 定期聚合依赖关系
+func (s *MySQLStore) dependencyJob() {
+	defer s.wg.Done()
+
+	ctx, cancel := s.stopContext()
+	defer cancel()
+
+	ticker := time.NewTicker(dependenciesJobInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.aggregateDependencies(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			s.logger.Warn().Err(err).Msg("Dependency aggregation failed")
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// aggregateDependencies 从最近一个已聚合的桶之前 dependenciesLateBuckets 个桶开始，
+// 聚合到 now 所在的桶，每个桶完成后记录进度
+func (s *MySQLStore) aggregateDependencies(ctx context.Context, now time.Time) error {
+	_, hasFirst, err := loadState(ctx, s.db, stateDependenciesFirstBucket)
+	if err != nil {
+		return err
+	}
+	last, hasLast, err := loadState(ctx, s.db, stateDependenciesLastBucket)
+	if err != nil {
+		return err
+	}
+
+	start := bucketStart(now.Add(-dependenciesBackfill))
+	if hasLast {
+		late := time.Duration(dependenciesLateBuckets) * dependenciesBucket
+		if resume := time.Unix(0, last).Add(-late); resume.After(start) {
+			start = resume
+		}
+	}
+
+	buckets := 0
+	for b := start; !b.After(now); b = b.Add(dependenciesBucket) {
+		if err := s.aggregateDependencyBucket(ctx, b); err != nil {
+			return err
+		}
+		if !hasFirst {
+			if err := saveState(ctx, s.db, stateDependenciesFirstBucket, b.UnixNano()); err != nil {
+				return err
+			}
+			hasFirst = true
+		}
+		if err := saveState(ctx, s.db, stateDependenciesLastBucket, b.UnixNano()); err != nil {
+			return err
+		}
+		buckets++
+	}
+	s.logger.Debug().Int("buckets", buckets).Time("from", start).Msg("Dependencies aggregated")
+	return nil
+}
+
+// aggregateDependencyBucket 计算一个桶的依赖关系并写入 jaeger_dependencies
+func (s *MySQLStore) aggregateDependencyBucket(ctx context.Context, bucket time.Time) error {
+	end := bucket.Add(dependenciesBucket)
+	counts, err := countDependencies(ctx, s, bucket.Add(-dependenciesBucketOverlap), bucket, end)
+	if err != nil {
+		return fmt.Errorf("count dependencies for bucket %s failed: %w", bucket.Format(time.RFC3339), err)
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("REPLACE INTO jaeger_dependencies (id, bucket_start, parent, child, call_count) VALUES ")
+	args := make([]interface{}, 0, len(counts)*5)
+	i := 0
+	for key, count := range counts {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?)")
+		bucketNanos := bucket.UnixNano()
+		args = append(args, stableID(strconv.FormatInt(bucketNanos, 10), key.parent, key.child),
+			bucketNanos, key.parent, key.child, int64(count))
+		i++
+	}
+	if _, err := s.db.ExecContext(ctx, sb.String(), args...); err != nil {
+		return fmt.Errorf("write dependencies failed: %w", err)
+	}
+	return nil
+}
+
+// ====================
+// 读取
+// ====================
+
+// dependencyCoverage 返回已聚合的时间范围 [from, to)，尚未聚合过时 ok 为 false
+func dependencyCoverage(ctx context.Context, s *MySQLStore) (from, to time.Time, ok bool, err error) {
+	first, hasFirst, err := loadState(ctx, s.db, stateDependenciesFirstBucket)
+	if err != nil {
+		return from, to, false, err
+	}
+	last, hasLast, err := loadState(ctx, s.db, stateDependenciesLastBucket)
+	if err != nil || !hasFirst || !hasLast || last < first {
+		return from, to, false, err
+	}
+	return time.Unix(0, first), time.Unix(0, last).Add(dependenciesBucket), true, nil
+}
+
+// readDependencies 统计 [start, end] 内的依赖：已聚合范围内对桶求和，
+// 范围之外的部分从 span 现算
+func readDependencies(ctx context.Context, s *MySQLStore, start, end time.Time) (dependencyCounts, error) {
+	from, to, ok, err := dependencyCoverage(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || !end.After(from) || !start.Before(to) {
+		return countDependencies(ctx, s, start, start, end.Add(time.Nanosecond))
+	}
+
+	loadStart, loadEnd := start, end
+	if loadStart.Before(from) {
+		loadStart = from
+	}
+	if !loadEnd.Before(to) {
+		loadEnd = to.Add(-time.Nanosecond)
+	}
+	counts, err := loadDependencies(ctx, s, loadStart, loadEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	// 与聚合任务一致，向前多取 dependenciesBucketOverlap 以找到父 span
+	if start.Before(from) {
+		live, err := countDependencies(ctx, s, start.Add(-dependenciesBucketOverlap), start, from)
+		if err != nil {
+			return nil, err
+		}
+		counts.merge(live)
+	}
+	if !end.Before(to) {
+		live, err := countDependencies(ctx, s, to.Add(-dependenciesBucketOverlap), to, end.Add(time.Nanosecond))
+		if err != nil {
+			return nil, err
+		}
+		counts.merge(live)
+	}
+	return counts, nil
+}
+
+// loadDependencies 对与 [start, end] 重叠的桶求和
+func loadDependencies(ctx context.Context, s *MySQLStore, start, end time.Time) (dependencyCounts, error) {
+	query := fmt.Sprintf(`
+		SELECT parent, child, SUM(call_count) AS calls
+		FROM jaeger_dependencies
+		WHERE bucket_start > ? AND bucket_start <= ?
+		GROUP BY parent, child
+		LIMIT %d OPTION max_matches=%d
+	`, catalogQueryLimit, catalogQueryLimit)
+	rows, err := s.db.QueryContext(ctx, query, start.Add(-dependenciesBucket).UnixNano(), end.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(dependencyCounts)
+	for rows.Next() {
+		var (
+			key   dependencyKey
+			calls int64
+		)
+		if err := rows.Scan(&key.parent, &key.child, &calls); err != nil {
+			continue
+		}
+		counts[key] += uint64(calls)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+var depScanColumns = []string{
+	"trace_id", "span_id", "operation_name", "refs", "service_name",
+	"start_time", "duration", "is_error", "span_kind", "tag_attrs",
+}
+
+// depRow 构造依赖扫描的一行，parent 为 0 表示根 span
+func depRow(traceID string, spanID, parent uint64, service string, start int64) []interface{} {
+	var refs []model.SpanRef
+	if parent != 0 {
+		refs = []model.SpanRef{{SpanID: model.NewSpanID(parent), RefType: model.SpanRefType_CHILD_OF}}
+	}
+	return depRowWith(traceID, spanID, refs, service, "op", start, 0, false)
+}
+
+// depRowWith 构造依赖扫描的一行，可指定引用、操作名、耗时和错误状态
+func depRowWith(traceID string, spanID uint64, refs []model.SpanRef,
+	service, operation string, start int64, duration time.Duration, isError bool) []interface{} {
+	return []interface{}{
+		traceID, model.NewSpanID(spanID).String(), operation, marshalRefs(refs), service,
+		start, duration.Nanoseconds(), int64(boolToInt(isError)), "", "{}",
+	}
+}
+
+// withKind 设置依赖扫描行的 span_kind 和 tag_attrs
+func withKind(row []interface{}, kind string, tags ...model.KeyValue) []interface{} {
+	row[8], row[9] = kind, marshalTagAttrs(tags)
+	return row
+}
+
+// depFixture 按 trace_id 排序的测试数据
+var depFixture = [][]interface{}{
+	depRow("t1", 1, 0, "gateway", 50),  // 父 span 在子 span 范围之前
+	depRow("t1", 2, 1, "order", 150),   // gateway -> order
+	depRow("t1", 3, 2, "order", 160),   // 同服务，不计数
+	depRow("t1", 4, 2, "mysql", 170),   // order -> mysql
+	depRow("t2", 1, 0, "gateway", 90),  // 子 span 不在范围内，不计数
+	depRow("t2", 2, 1, "order", 95),    // 子 span 不在范围内，不计数
+	depRow("t3", 2, 9, "payment", 180), // 父 span 不存在
+	depRow("t4", 1, 0, "gateway", 110), // gateway -> payment
+	depRow("t4", 2, 1, "payment", 120),
+}
+
+// serveDepFixture 模拟 trace_id 上的分页查询
+func serveDepFixture(args []interface{}) [][]interface{} {
+	after := args[2].(string)
+	var out [][]interface{}
+	for _, row := range depFixture {
+		if row[0].(string) > after {
+			out = append(out, row)
+		}
+	}
+	if len(out) > dependenciesScanBatch {
+		out = out[:dependenciesScanBatch]
+	}
+	return out
+}
+
+func TestCountDependencies(t *testing.T) {
+	want := dependencyCounts{
+		{parent: "gateway", child: "order"}:   1,
+		{parent: "order", child: "mysql"}:     1,
+		{parent: "gateway", child: "payment"}: 1,
+	}
+
+	for _, batch := range []int{100, 5, 3, 2} {
+		func() {
+			defer func(v int) { dependenciesScanBatch = v }(dependenciesScanBatch)
+			dependenciesScanBatch = batch
+
+			f, reader := newTestReader(t)
+			f.onFunc("trace_id > ?", depScanColumns, serveDepFixture)
+			f.onFunc("trace_id = ?", depScanColumns, func(args []interface{}) [][]interface{} {
+				var out [][]interface{}
+				for _, row := range depFixture {
+					if row[0] == args[2] {
+						out = append(out, row)
+					}
+				}
+				return out
+			})
+
+			counts, err := countDependencies(context.Background(), reader.store,
+				time.Unix(0, 0), time.Unix(0, 100), time.Unix(0, 200))
+			if err != nil {
+				t.Fatalf("batch %d: countDependencies: %v", batch, err)
+			}
+			if !reflect.DeepEqual(counts, want) {
+				t.Errorf("batch %d: counts = %v, want %v", batch, counts, want)
+			}
+		}()
+	}
+}
+
+func TestCountDependenciesScanLimit(t *testing.T) {
+	defer func(batch, max int) {
+		dependenciesScanBatch, dependenciesMaxSpans = batch, max
+	}(dependenciesScanBatch, dependenciesMaxSpans)
+	dependenciesScanBatch, dependenciesMaxSpans = 5, 1
+
+	f, reader := newTestReader(t)
+	f.onFunc("trace_id > ?", depScanColumns, serveDepFixture)
+
+	counts, err := countDependencies(context.Background(), reader.store,
+		time.Unix(0, 0), time.Unix(0, 100), time.Unix(0, 200))
+	if err != nil {
+		t.Fatalf("countDependencies: %v", err)
+	}
+	if got := len(f.matching("trace_id > ?")); got != 1 {
+		t.Errorf("expected scan to stop after 1 page, got %d", got)
+	}
+	// 只处理了第一页中完整的 trace（t1）
+	want := dependencyCounts{
+		{parent: "gateway", child: "order"}: 1,
+		{parent: "order", child: "mysql"}:   1,
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+}
+
+func TestAggregateDependencyBucket(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("FROM jaeger_spans", depScanColumns,
+		depRow("t1", 1, 0, "gateway", time.Unix(3600, 0).UnixNano()),
+		depRow("t1", 2, 1, "order", time.Unix(3601, 0).UnixNano()),
+	)
+
+	bucket := time.Unix(3600, 0)
+	if err := reader.store.aggregateDependencyBucket(context.Background(), bucket); err != nil {
+		t.Fatalf("aggregateDependencyBucket: %v", err)
+	}
+
+	scan := f.matching("FROM jaeger_spans")[0]
+	if scan.args[0] != bucket.Add(-dependenciesBucketOverlap).UnixNano() {
+		t.Errorf("scan should start before bucket, got %v", scan.args[0])
+	}
+	writes := f.matching("REPLACE INTO jaeger_dependencies")
+	if len(writes) != 1 {
+		t.Fatalf("expected 1 REPLACE, got %d", len(writes))
+	}
+	if got := writes[0].args[1:]; !reflect.DeepEqual(got, []interface{}{bucket.UnixNano(), "gateway", "order", int64(1)}) {
+		t.Errorf("args = %v", got)
+	}
+}
+
+// serveState 模拟 jaeger_state 中已有的状态
+func serveState(f *fakeDB, states map[string]int64) {
+	f.onFunc("FROM jaeger_state", []string{"value"}, func(args []interface{}) [][]interface{} {
+		for name, value := range states {
+			if args[0] == stableID(name) {
+				return [][]interface{}{{value}}
+			}
+		}
+		return nil
+	})
+}
+
+func TestAggregateDependenciesTracksProgress(t *testing.T) {
+	defer func(v time.Duration) { dependenciesBackfill = v }(dependenciesBackfill)
+	dependenciesBackfill = 2 * time.Hour
+	now := time.Unix(3*3600+1800, 0)
+
+	// 首次运行：回填 1h、2h、3h 三个空桶，不写依赖行但记录进度
+	f, reader := newTestReader(t)
+	f.on("FROM jaeger_spans", depScanColumns)
+	if err := reader.store.aggregateDependencies(context.Background(), now); err != nil {
+		t.Fatalf("aggregateDependencies: %v", err)
+	}
+	if n := len(f.matching("REPLACE INTO jaeger_dependencies")); n != 0 {
+		t.Errorf("empty buckets wrote %d times", n)
+	}
+	saved := make(map[string][]interface{})
+	for _, q := range f.matching("REPLACE INTO jaeger_state") {
+		saved[q.args[1].(string)] = append(saved[q.args[1].(string)], q.args[2])
+	}
+	if got := saved[stateDependenciesFirstBucket]; !reflect.DeepEqual(got, []interface{}{time.Unix(3600, 0).UnixNano()}) {
+		t.Errorf("first bucket saves = %v", got)
+	}
+	if got := saved[stateDependenciesLastBucket]; len(got) != 3 || got[2] != time.Unix(3*3600, 0).UnixNano() {
+		t.Errorf("last bucket saves = %v", got)
+	}
+
+	// 之后从记录的桶继续，重新计算当前桶和之前的 dependenciesLateBuckets 个桶
+	f, reader = newTestReader(t)
+	serveState(f, map[string]int64{
+		stateDependenciesFirstBucket: time.Unix(3600, 0).UnixNano(),
+		stateDependenciesLastBucket:  time.Unix(3*3600, 0).UnixNano(),
+	})
+	f.on("FROM jaeger_spans", depScanColumns)
+	if err := reader.store.aggregateDependencies(context.Background(), now); err != nil {
+		t.Fatalf("aggregateDependencies: %v", err)
+	}
+	scans := f.matching("FROM jaeger_spans")
+	if len(scans) != 1+dependenciesLateBuckets {
+		t.Fatalf("recomputed %d buckets, want %d", len(scans), 1+dependenciesLateBuckets)
+	}
+	// 第一个重新计算的是已聚合过的 2h 桶，延迟写入的 span 会被计入
+	if want := time.Unix(2*3600, 0).Add(-dependenciesBucketOverlap).UnixNano(); scans[0].args[0] != want {
+		t.Errorf("first recomputed scan starts at %v, want %v", scans[0].args[0], want)
+	}
+	if n := len(f.matching("REPLACE INTO jaeger_state")); n != 1+dependenciesLateBuckets {
+		t.Errorf("state writes = %d, want %d", n, 1+dependenciesLateBuckets)
+	}
+}
+
+func TestGetDependenciesComputesUncoveredWindow(t *testing.T) {
+	f, reader := newTestReader(t)
+	// 只聚合了 [1h, 2h)
+	serveState(f, map[string]int64{
+		stateDependenciesFirstBucket: time.Unix(3600, 0).UnixNano(),
+		stateDependenciesLastBucket:  time.Unix(3600, 0).UnixNano(),
+	})
+	f.on("FROM jaeger_dependencies", []string{"parent", "child", "calls"},
+		[]interface{}{"gateway", "order", int64(3)},
+	)
+	spans := [][]interface{}{
+		depRow("t1", 1, 0, "gateway", time.Unix(100, 0).UnixNano()),
+		depRow("t1", 2, 1, "order", time.Unix(101, 0).UnixNano()),
+		depRow("t2", 1, 0, "gateway", time.Unix(8000, 0).UnixNano()),
+		depRow("t2", 2, 1, "payment", time.Unix(8001, 0).UnixNano()),
+	}
+	f.onFunc("FROM jaeger_spans", depScanColumns, func(args []interface{}) [][]interface{} {
+		var out [][]interface{}
+		for _, row := range spans {
+			if start := row[5].(int64); start >= args[0].(int64) && start < args[1].(int64) {
+				out = append(out, row)
+			}
+		}
+		return out
+	})
+
+	deps, err := reader.store.DependencyReader().GetDependencies(context.Background(), time.Unix(3*3600, 0), 3*time.Hour)
+	if err != nil {
+		t.Fatalf("GetDependencies: %v", err)
+	}
+	want := []model.DependencyLink{
+		{Parent: "gateway", Child: "order", CallCount: 4},
+		{Parent: "gateway", Child: "payment", CallCount: 1},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("deps = %v, want %v", deps, want)
+	}
+	// 聚合范围之前和之后各现算一次
+	scans := f.matching("FROM jaeger_spans")
+	if len(scans) != 2 {
+		t.Fatalf("expected 2 live scans, got %d", len(scans))
+	}
+	if scans[0].args[1] != time.Unix(3600, 0).UnixNano() || scans[1].args[0] != time.Unix(2*3600, 0).Add(-dependenciesBucketOverlap).UnixNano() {
+		t.Errorf("live scan ranges: %v, %v", scans[0].args, scans[1].args)
+	}
+	load := f.matching("FROM jaeger_dependencies")[0]
+	if load.args[1] != time.Unix(2*3600, 0).UnixNano()-1 {
+		t.Errorf("load should stop before the uncovered part, got %v", load.args)
+	}
+}
+
+func TestGetDependenciesReadsBuckets(t *testing.T) {
+	f, reader := newTestReader(t)
+	serveState(f, map[string]int64{
+		stateDependenciesFirstBucket: 0,
+		stateDependenciesLastBucket:  time.Unix(7200, 0).UnixNano(),
+	})
+	f.on("FROM jaeger_dependencies", []string{"parent", "child", "calls"},
+		[]interface{}{"order", "mysql", int64(7)},
+		[]interface{}{"gateway", "order", int64(3)},
+	)
+
+	deps, err := reader.store.DependencyReader().GetDependencies(context.Background(), time.Unix(7200, 0), time.Hour)
+	if err != nil {
+		t.Fatalf("GetDependencies: %v", err)
+	}
+	want := []model.DependencyLink{
+		{Parent: "gateway", Child: "order", CallCount: 3},
+		{Parent: "order", Child: "mysql", CallCount: 7},
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("deps = %v, want %v", deps, want)
+	}
+	if len(f.matching("FROM jaeger_spans")) != 0 {
+		t.Error("GetDependencies should not scan spans when aggregation is enabled")
+	}
+	if q := f.matching("FROM jaeger_dependencies")[0].query; !strings.Contains(q, "SUM(call_count)") {
+		t.Errorf("query:\n%s", q)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// 带统计信息的依赖关系
+// ====================
+//
+// model.DependencyLink 只有调用次数。扩展 API（/api/dependencies）在此基础上为每条边提供：
+//   - 子 span 的错误数和 p50/p95/p99 耗时
+//   - 可选的按 parent/child 操作名细分
+//   - FOLLOWS_FROM 引用作为单独类型的边（GetDependencies 仍只统计 CHILD_OF）
+//   - 到虚拟叶子节点的边标记 virtual（见 virtualdeps.go），默认返回，可隐藏
+//
+// 百分位无法从预聚合的桶中合并，因此实时按 trace 流式扫描 span（见 scanDependencies），
+// 耗时用对数分桶的直方图统计，每条边的内存与调用次数无关。扫描前先统计窗口内的
+// span 数，超过 DEPENDENCIES_MAX_SPANS 的窗口直接拒绝，而不是返回不完整的结果；
+// 扫描中仍可能因单个 trace 过大等原因截断，此时结果标记 truncated。
+
+const (
+	// latencyHistogramScale 直方图每个 2 倍区间的分桶数，相对误差约 9%
+	latencyHistogramScale = 8
+	// maxDependencyWindow 扩展依赖查询允许的最大时间范围
+	maxDependencyWindow = 7 * 24 * time.Hour
+)
+
+// latencyHistogram 对数分桶的耗时直方图（微秒）
+type latencyHistogram struct {
+	counts []uint64
+	total  uint64
+}
+
+// latencyBucket 返回耗时所在的桶
+func latencyBucket(micros int64) int {
+	if micros <= 1 {
+		return 0
+	}
+	return int(math.Log2(float64(micros))*latencyHistogramScale) + 1
+}
+
+// latencyBucketValue 返回桶的代表值（桶上下界的几何中点）
+func latencyBucketValue(bucket int) int64 {
+	if bucket == 0 {
+		return 1
+	}
+	return int64(math.Round(math.Exp2((float64(bucket) - 0.5) / latencyHistogramScale)))
+}
+
+// add 记录一次耗时
+func (h *latencyHistogram) add(d time.Duration) {
+	b := latencyBucket(d.Microseconds())
+	if b >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, b+1-len(h.counts))...)
+	}
+	h.counts[b]++
+	h.total++
+}
+
+// merge 合并另一个直方图
+func (h *latencyHistogram) merge(other *latencyHistogram) {
+	if len(other.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, len(other.counts)-len(h.counts))...)
+	}
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	h.total += other.total
+}
+
+// quantile 返回分位数 q（0~1）的近似值（微秒），无数据时返回 0
+func (h *latencyHistogram) quantile(q float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return latencyBucketValue(i)
+		}
+	}
+	return latencyBucketValue(len(h.counts) - 1)
+}
+
+// dependencyStats 一条边（或一组操作）的调用统计，耗时为子 span 的耗时（微秒）
+type dependencyStats struct {
+	CallCount  uint64 `json:"callCount"`
+	ErrorCount uint64 `json:"errorCount"`
+	P50        int64  `json:"p50"`
+	P95        int64  `json:"p95"`
+	P99        int64  `json:"p99"`
+}
+
+// operationLink 边内按操作名细分的统计
+type operationLink struct {
+	ParentOperation string `json:"parentOperation"`
+	ChildOperation  string `json:"childOperation"`
+	dependencyStats
+}
+
+// richDependencyLink 带统计信息的依赖边
+type richDependencyLink struct {
+	Parent     string          `json:"parent"`
+	Child      string          `json:"child"`
+	Type       string          `json:"type"`              // CHILD_OF / FOLLOWS_FROM
+	Virtual    bool            `json:"virtual,omitempty"` // child 为虚拟叶子节点
+	Operations []operationLink `json:"operations,omitempty"`
+	dependencyStats
+}
+
+// richDependencies 扩展依赖查询的结果
+type richDependencies struct {
+	Links     []richDependencyLink `json:"links"`
+	Truncated bool                 `json:"truncated"` // 扫描未完成，调用次数和耗时只反映部分 span
+}
+
+// dependencyQuery 扩展依赖查询
+type dependencyQuery struct {
+	StartTime  time.Time
+	EndTime    time.Time
+	Service    string // 非空时只返回一端为该服务的边
+	Operations bool   // 是否按操作名细分
+	Virtual    bool   // 是否包含到虚拟叶子节点的边
+}
+
+// richDependencyKey 聚合键，不细分操作名时操作名为空
+type richDependencyKey struct {
+	parent, child                   string
+	refType                         model.SpanRefType
+	virtual                         bool
+	parentOperation, childOperation string
+}
+
+// richDependencyAcc 聚合中的统计
+type richDependencyAcc struct {
+	calls, errors uint64
+	latency       latencyHistogram
+}
+
+func (a *richDependencyAcc) add(child *depSpan) {
+	a.calls++
+	if child.isError {
+		a.errors++
+	}
+	a.latency.add(time.Duration(child.duration))
+}
+
+func (a *richDependencyAcc) merge(other *richDependencyAcc) {
+	a.calls += other.calls
+	a.errors += other.errors
+	a.latency.merge(&other.latency)
+}
+
+func (a *richDependencyAcc) stats() dependencyStats {
+	return dependencyStats{
+		CallCount:  a.calls,
+		ErrorCount: a.errors,
+		P50:        a.latency.quantile(0.50),
+		P95:        a.latency.quantile(0.95),
+		P99:        a.latency.quantile(0.99),
+	}
+}
+
+// richDependencyCollector 按边（可选按操作名）聚合引用
+type richDependencyCollector struct {
+	query *dependencyQuery
+	accs  map[richDependencyKey]*richDependencyAcc
+}
+
+func newRichDependencyCollector(query *dependencyQuery) *richDependencyCollector {
+	return &richDependencyCollector{query: query, accs: make(map[richDependencyKey]*richDependencyAcc)}
+}
+
+// visit 实现 dependencyVisitor，只统计跨服务的边
+func (c *richDependencyCollector) visit(parent, child *depSpan, refType model.SpanRefType) {
+	if parent.service == child.service || (child.virtual && !c.query.Virtual) {
+		return
+	}
+	if c.query.Service != "" && parent.service != c.query.Service && child.service != c.query.Service {
+		return
+	}
+	key := richDependencyKey{parent: parent.service, child: child.service, refType: refType, virtual: child.virtual}
+	if c.query.Operations {
+		key.parentOperation, key.childOperation = parent.operation, child.operation
+	}
+	acc, ok := c.accs[key]
+	if !ok {
+		acc = &richDependencyAcc{}
+		c.accs[key] = acc
+	}
+	acc.add(child)
+}
+
+// links 汇总为依赖边，按 parent/child/type 排序，操作细分按调用次数降序
+func (c *richDependencyCollector) links() []richDependencyLink {
+	type edgeKey struct {
+		parent, child string
+		refType       model.SpanRefType
+		virtual       bool
+	}
+	type edge struct {
+		acc richDependencyAcc
+		ops []operationLink
+	}
+
+	edges := make(map[edgeKey]*edge)
+	for key, acc := range c.accs {
+		ek := edgeKey{parent: key.parent, child: key.child, refType: key.refType, virtual: key.virtual}
+		e, ok := edges[ek]
+		if !ok {
+			e = &edge{}
+			edges[ek] = e
+		}
+		e.acc.merge(acc)
+		if c.query.Operations {
+			e.ops = append(e.ops, operationLink{
+				ParentOperation: key.parentOperation,
+				ChildOperation:  key.childOperation,
+				dependencyStats: acc.stats(),
+			})
+		}
+	}
+
+	links := make([]richDependencyLink, 0, len(edges))
+	for key, e := range edges {
+		sort.Slice(e.ops, func(i, j int) bool {
+			if e.ops[i].CallCount != e.ops[j].CallCount {
+				return e.ops[i].CallCount > e.ops[j].CallCount
+			}
+			if e.ops[i].ParentOperation != e.ops[j].ParentOperation {
+				return e.ops[i].ParentOperation < e.ops[j].ParentOperation
+			}
+			return e.ops[i].ChildOperation < e.ops[j].ChildOperation
+		})
+		links = append(links, richDependencyLink{
+			Parent:          key.parent,
+			Child:           key.child,
+			Type:            key.refType.String(),
+			Virtual:         key.virtual,
+			Operations:      e.ops,
+			dependencyStats: e.acc.stats(),
+		})
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Parent != links[j].Parent {
+			return links[i].Parent < links[j].Parent
+		}
+		if links[i].Child != links[j].Child {
+			return links[i].Child < links[j].Child
+		}
+		if links[i].Type != links[j].Type {
+			return links[i].Type < links[j].Type
+		}
+		return !links[i].Virtual && links[j].Virtual
+	})
+	return links
+}
+
+// countWindowSpans 返回 [from, to) 内的 span 数
+func (s *MySQLStore) countWindowSpans(ctx context.Context, from, to time.Time) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) AS spans FROM jaeger_spans WHERE start_time >= ? AND start_time < ?",
+		from.UnixNano(), to.UnixNano()).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count spans failed: %w", err)
+	}
+	return n, nil
+}
+
+// GetRichDependencies 返回 [StartTime, EndTime] 内带统计信息的依赖边
+func (r *MySQLDependencyReader) GetRichDependencies(ctx context.Context, query *dependencyQuery) (*richDependencies, error) {
+	if !query.EndTime.After(query.StartTime) {
+		return nil, invalidQuery(errors.New("end time must be after start time"))
+	}
+	if query.EndTime.Sub(query.StartTime) > maxDependencyWindow {
+		return nil, invalidQuery(fmt.Errorf("time range too large: at most %s", maxDependencyWindow))
+	}
+
+	// 与 GetDependencies 一致：父子 span 都在窗口内才计数
+	from, to := query.StartTime, query.EndTime.Add(time.Nanosecond)
+	if dependenciesMaxSpans > 0 {
+		spans, err := r.store.countWindowSpans(ctx, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if spans > int64(dependenciesMaxSpans) {
+			return nil, invalidQuery(fmt.Errorf("time range too large: %d spans exceed the limit of %d, narrow the window",
+				spans, dependenciesMaxSpans))
+		}
+	}
+
+	collector := newRichDependencyCollector(query)
+	truncated, err := scanDependencies(ctx, r.store, from, from, to, collector.visit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &richDependencies{Links: collector.links(), Truncated: truncated}
+	r.logger.Debug().Int("count", len(result.Links)).Bool("operations", query.Operations).
+		Bool("truncated", truncated).Msg("Rich dependencies calculated")
+	return result, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestLatencyHistogramQuantile(t *testing.T) {
+	var h latencyHistogram
+	if got := h.quantile(0.5); got != 0 {
+		t.Errorf("empty quantile = %d, want 0", got)
+	}
+	for i := 1; i <= 100; i++ {
+		h.add(time.Duration(i) * time.Millisecond)
+	}
+	for _, tt := range []struct {
+		q    float64
+		want int64
+	}{{0.50, 50000}, {0.95, 95000}, {0.99, 99000}} {
+		got := h.quantile(tt.q)
+		if diff := float64(got-tt.want) / float64(tt.want); diff < -0.1 || diff > 0.1 {
+			t.Errorf("quantile(%v) = %d, want ~%d", tt.q, got, tt.want)
+		}
+	}
+
+	var other latencyHistogram
+	other.add(time.Second)
+	h.merge(&other)
+	if h.total != 101 {
+		t.Errorf("merged total = %d, want 101", h.total)
+	}
+}
+
+func TestGetRichDependencies(t *testing.T) {
+	childOf := func(id uint64) []model.SpanRef {
+		return []model.SpanRef{{SpanID: model.NewSpanID(id), RefType: model.SpanRefType_CHILD_OF}}
+	}
+	followsFrom := func(id uint64) []model.SpanRef {
+		return []model.SpanRef{{SpanID: model.NewSpanID(id), RefType: model.SpanRefType_FOLLOWS_FROM}}
+	}
+
+	f, reader := newTestReader(t)
+	f.on("COUNT(*) AS spans", []string{"spans"}, []interface{}{int64(5)})
+	f.on("trace_id > ?", depScanColumns,
+		depRowWith("t1", 1, nil, "gateway", "GET /orders", 100, 50*time.Millisecond, false),
+		depRowWith("t1", 2, childOf(1), "order", "list", 110, 10*time.Millisecond, false),
+		depRowWith("t1", 3, followsFrom(2), "mailer", "send", 120, time.Millisecond, false),
+		depRowWith("t2", 1, nil, "gateway", "POST /orders", 100, 80*time.Millisecond, false),
+		depRowWith("t2", 2, childOf(1), "order", "create", 110, 40*time.Millisecond, true),
+	)
+
+	deps := reader.store.DependencyReader().(*MySQLDependencyReader)
+	result, err := deps.GetRichDependencies(context.Background(), &dependencyQuery{
+		StartTime:  time.Unix(0, 0),
+		EndTime:    time.Unix(0, 200),
+		Operations: true,
+	})
+	if err != nil {
+		t.Fatalf("GetRichDependencies: %v", err)
+	}
+	if result.Truncated {
+		t.Error("complete scan marked truncated")
+	}
+	links := result.Links
+	if len(links) != 2 {
+		t.Fatalf("got %d links, want 2: %+v", len(links), links)
+	}
+
+	orders := links[0]
+	if orders.Parent != "gateway" || orders.Child != "order" || orders.Type != "CHILD_OF" {
+		t.Errorf("unexpected first link %+v", orders)
+	}
+	if orders.CallCount != 2 || orders.ErrorCount != 1 {
+		t.Errorf("calls/errors = %d/%d, want 2/1", orders.CallCount, orders.ErrorCount)
+	}
+	if orders.P99 < 36000 || orders.P99 > 44000 {
+		t.Errorf("p99 = %dus, want ~40000", orders.P99)
+	}
+	if len(orders.Operations) != 2 || orders.Operations[0].ParentOperation != "GET /orders" {
+		t.Errorf("unexpected operations %+v", orders.Operations)
+	}
+
+	mail := links[1]
+	if mail.Parent != "order" || mail.Child != "mailer" || mail.Type != "FOLLOWS_FROM" || mail.CallCount != 1 {
+		t.Errorf("unexpected FOLLOWS_FROM link %+v", mail)
+	}
+
+	// GetDependencies 只统计 CHILD_OF
+	defer func(v bool) { dependenciesJobEnabled = v }(dependenciesJobEnabled)
+	dependenciesJobEnabled = false
+	plain, err := deps.GetDependencies(context.Background(), time.Unix(0, 200), 200*time.Nanosecond)
+	if err != nil {
+		t.Fatalf("GetDependencies: %v", err)
+	}
+	if len(plain) != 1 || plain[0].Child != "order" || plain[0].CallCount != 2 {
+		t.Errorf("unexpected plain links %+v", plain)
+	}
+}
+
+func TestGetRichDependenciesSpanLimit(t *testing.T) {
+	defer func(batch, max int) {
+		dependenciesScanBatch, dependenciesMaxSpans = batch, max
+	}(dependenciesScanBatch, dependenciesMaxSpans)
+	dependenciesScanBatch, dependenciesMaxSpans = 2, 3
+	query := &dependencyQuery{StartTime: time.Unix(0, 0), EndTime: time.Unix(0, 200)}
+
+	// 窗口内的 span 数超过上限时直接拒绝，不扫描
+	f, reader := newTestReader(t)
+	f.on("COUNT(*) AS spans", []string{"spans"}, []interface{}{int64(4)})
+	deps := reader.store.DependencyReader().(*MySQLDependencyReader)
+	if _, err := deps.GetRichDependencies(context.Background(), query); !isInvalidQuery(err) {
+		t.Errorf("expected invalid query error, got %v", err)
+	}
+	if n := len(f.matching("trace_id > ?")); n != 0 {
+		t.Errorf("rejected window scanned %d pages", n)
+	}
+
+	// 扫描中达到上限（例如统计后又写入了 span）时标记 truncated
+	f, reader = newTestReader(t)
+	f.on("COUNT(*) AS spans", []string{"spans"}, []interface{}{int64(3)})
+	f.onFunc("trace_id > ?", depScanColumns, serveDepFixture)
+	f.onFunc("trace_id = ?", depScanColumns, func(args []interface{}) [][]interface{} {
+		var out [][]interface{}
+		for _, row := range depFixture {
+			if row[0] == args[2] {
+				out = append(out, row)
+			}
+		}
+		return out
+	})
+	deps = reader.store.DependencyReader().(*MySQLDependencyReader)
+	result, err := deps.GetRichDependencies(context.Background(), query)
+	if err != nil {
+		t.Fatalf("GetRichDependencies: %v", err)
+	}
+	if !result.Truncated {
+		t.Error("partial scan not marked truncated")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// 错误状态（is_error）
+// ====================
+//
+// 写入时根据以下 tag 计算 is_error 属性，搜索时不必在 tags 文本中匹配：
+//   - error=true（OpenTracing 约定，OTLP 转换时也会设置）
+//   - otel.status_code=ERROR（tlog 中 span.SetStatus(codes.Error, ...)）
+//   - http.status_code / http.response.status_code >= HTTP_ERROR_STATUS_MIN
+//
+// FindTraces 通过保留 tag key（默认 is_error）过滤：is_error=true 只返回出错的 trace。
+// 该列在本次升级之前写入的数据中为 0。
+
+var (
+	// 按 HTTP 状态码判定错误的下限（默认只把 5xx 视为错误）
+	httpErrorStatusMin = getIntEnv("HTTP_ERROR_STATUS_MIN", 500)
+	// FindTraces 中按 is_error 过滤的保留 tag key
+	errorFilterKey = getStringEnv("ERROR_FILTER_KEY", "is_error")
+)
+
+// httpStatusTags 可能携带 HTTP 状态码的 tag（旧/新语义约定）
+var httpStatusTags = []string{"http.status_code", "http.response.status_code"}
+
+// spanIsError 判断 span 是否出错
+func spanIsError(tags []model.KeyValue) bool {
+	kvs := model.KeyValues(tags)
+	if tag, ok := kvs.FindByKey("error"); ok && strings.EqualFold(tag.AsString(), "true") {
+		return true
+	}
+	if tag, ok := kvs.FindByKey("otel.status_code"); ok && strings.EqualFold(tag.AsString(), "ERROR") {
+		return true
+	}
+	for _, key := range httpStatusTags {
+		tag, ok := kvs.FindByKey(key)
+		if !ok {
+			continue
+		}
+		if code, err := strconv.Atoi(tag.AsString()); err == nil && code >= httpErrorStatusMin {
+			return true
+		}
+	}
+	return false
+}
+
+// errorFilterOf 从 Tags 中取出 is_error 过滤条件，ok 为 false 表示未指定
+func errorFilterOf(tags map[string]string) (isError bool, ok bool, err error) {
+	value, ok := tags[errorFilterKey]
+	if !ok {
+		return false, false, nil
+	}
+	isError, err = strconv.ParseBool(value)
+	if err != nil {
+		return false, false, fmt.Errorf("invalid %s filter %q: must be true or false", errorFilterKey, value)
+	}
+	return isError, true, nil
+}
+
+// ====================
+// 错误率汇总
+// ====================
+
+// errorSummaryQuery 错误率汇总条件
+type errorSummaryQuery struct {
+	ServiceName  string // 为空时汇总所有服务
+	StartTimeMin time.Time
+	StartTimeMax time.Time
+	Limit        int
+}
+
+// errorSummary 一个服务/操作的错误统计
+type errorSummary struct {
+	Service   string  `json:"service"`
+	Operation string  `json:"operation"`
+	Total     int64   `json:"total"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"errorRate"`
+}
+
+// buildErrorSummarySQL 构建错误率汇总 SQL，按错误数倒序
+func buildErrorSummarySQL(q *errorSummaryQuery) (traceSearchSQL, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = catalogQueryLimit
+	}
+	if limit > catalogQueryLimit {
+		return traceSearchSQL{}, fmt.Errorf("limit %d exceeds maximum %d", limit, catalogQueryLimit)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`SELECT service_name, operation_name, COUNT(*) AS total, SUM(is_error) AS errors
+		FROM jaeger_spans WHERE start_time >= ? AND start_time <= ?`)
+	args := []interface{}{q.StartTimeMin.UnixNano(), q.StartTimeMax.UnixNano()}
+
+	if q.ServiceName != "" {
+		sb.WriteString(" AND service_name = ?")
+		args = append(args, q.ServiceName)
+	}
+
+	fmt.Fprintf(&sb, " GROUP BY service_name, operation_name ORDER BY errors DESC, total DESC LIMIT %d OPTION max_matches=%d",
+		limit, limit)
+
+	return traceSearchSQL{query: sb.String(), args: args}, nil
+}
+
+// ErrorSummary 按服务和操作汇总时间范围内的错误率
+func (r *MySQLSpanReader) ErrorSummary(ctx context.Context, q *errorSummaryQuery) ([]errorSummary, error) {
+	sq, err := buildErrorSummarySQL(q)
+	if err != nil {
+		return nil, invalidQuery(err)
+	}
+	rows, err := r.db.QueryContext(ctx, sq.query, sq.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []errorSummary
+	for rows.Next() {
+		var s errorSummary
+		if err := rows.Scan(&s.Service, &s.Operation, &s.Total, &s.Errors); err != nil {
+			r.logger.Warn().Err(err).Msg("Failed to scan error summary")
+			continue
+		}
+		if s.Total > 0 {
+			s.ErrorRate = float64(s.Errors) / float64(s.Total)
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
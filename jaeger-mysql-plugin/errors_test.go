@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func TestSpanIsError(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []model.KeyValue
+		want bool
+	}{
+		{"none", nil, false},
+		{"error bool", []model.KeyValue{model.Bool("error", true)}, true},
+		{"error false", []model.KeyValue{model.Bool("error", false)}, false},
+		{"error string", []model.KeyValue{model.String("error", "true")}, true},
+		{"otel status", []model.KeyValue{model.String("otel.status_code", "ERROR")}, true},
+		{"otel ok", []model.KeyValue{model.String("otel.status_code", "OK")}, false},
+		{"http 503", []model.KeyValue{model.Int64("http.status_code", 503)}, true},
+		{"http 404", []model.KeyValue{model.Int64("http.status_code", 404)}, false},
+		{"semconv http 500", []model.KeyValue{model.String("http.response.status_code", "500")}, true},
+	}
+	for _, tt := range tests {
+		if got := spanIsError(tt.tags); got != tt.want {
+			t.Errorf("%s: spanIsError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildTraceSearchSQLErrorFilter(t *testing.T) {
+	q, err := buildTraceSearchSQL(&spanstore.TraceQueryParameters{
+		ServiceName:  "order",
+		StartTimeMin: time.Unix(0, 0),
+		StartTimeMax: time.Unix(100, 0),
+		Tags:         map[string]string{errorFilterKey: "true", "http.method": "GET"},
+		NumTraces:    20,
+	})
+	if err != nil {
+		t.Fatalf("buildTraceSearchSQL: %v", err)
+	}
+	if !strings.Contains(q.query, "is_error = ?") {
+		t.Errorf("query missing is_error filter:\n%s", q.query)
+	}
+	for _, arg := range q.args {
+		if s, ok := arg.(string); ok && strings.Contains(s, errorFilterKey) {
+			t.Errorf("reserved key leaked into tag filter: %q", s)
+		}
+	}
+
+	_, err = buildTraceSearchSQL(&spanstore.TraceQueryParameters{
+		Tags: map[string]string{errorFilterKey: "maybe"},
+	})
+	if err == nil {
+		t.Error("expected error for invalid is_error value")
+	}
+}
+
+func TestSpanMatcherErrorFilter(t *testing.T) {
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:  "order",
+		StartTimeMin: time.Unix(0, 0),
+		StartTimeMax: time.Unix(100, 0),
+		Tags:         map[string]string{errorFilterKey: "true"},
+	}
+	span := &model.Span{
+		StartTime: time.Unix(10, 0),
+		Process:   &model.Process{ServiceName: "order"},
+	}
+	m, err := newSpanMatcher(query)
+	if err != nil {
+		t.Fatalf("newSpanMatcher: %v", err)
+	}
+	if m.matches(span) {
+		t.Error("span without error should not match")
+	}
+	span.Tags = []model.KeyValue{model.String("otel.status_code", "ERROR")}
+	if !m.matches(span) {
+		t.Error("failed span should match")
+	}
+}
+
+func TestErrorSummary(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("SUM(is_error)", []string{"service_name", "operation_name", "total", "errors"},
+		[]interface{}{"order", "GET /orders", int64(200), int64(50)},
+		[]interface{}{"order", "GET /health", int64(10), int64(0)},
+	)
+
+	got, err := reader.ErrorSummary(context.Background(), &errorSummaryQuery{
+		ServiceName:  "order",
+		StartTimeMin: time.Unix(0, 0),
+		StartTimeMax: time.Unix(100, 0),
+	})
+	if err != nil {
+		t.Fatalf("ErrorSummary: %v", err)
+	}
+	if len(got) != 2 || got[0].ErrorRate != 0.25 || got[1].ErrorRate != 0 {
+		t.Errorf("got %+v", got)
+	}
+
+	q := f.matching("SUM(is_error)")[0]
+	if !strings.Contains(q.query, "service_name = ?") || !strings.Contains(q.query, "GROUP BY service_name, operation_name") {
+		t.Errorf("unexpected query:\n%s", q.query)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/rs/zerolog"
+)
+
+// ====================
+// 测试用的内存 SQL 驱动
+// ====================
+
+// fakeQuery 记录一次执行的 SQL 和参数
+type fakeQuery struct {
+	query string
+	args  []interface{}
+}
+
+// fakeResult 预设的查询结果：SQL 包含 match 时返回 columns/rows
+type fakeResult struct {
+	match   string
+	columns []string
+	rows    [][]interface{}
+	fn      func(args []interface{}) [][]interface{} // 非 nil 时按参数动态生成 rows
+	query   func(ctx context.Context, args []interface{}) ([][]interface{}, error)
+	args    []interface{}
+	err     error
+}
+
+// fakeDB 记录所有 SQL，并按预设结果返回数据
+type fakeDB struct {
+	mu      sync.Mutex
+	queries []fakeQuery
+	results []fakeResult
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// newFakeDB 创建一个独立的 fakeDB 以及对应的 *sql.DB
+func newFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	t.Helper()
+	f := &fakeDB{}
+	name := t.Name()
+
+	fakeDBsMu.Lock()
+	fakeDBs[name] = f
+	fakeDBsMu.Unlock()
+
+	db, err := sql.Open("fakedb", name)
+	if err != nil {
+		t.Fatalf("open fakedb: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBsMu.Lock()
+		delete(fakeDBs, name)
+		fakeDBsMu.Unlock()
+	})
+	return f, db
+}
+
+// newTestReader 创建基于 fakeDB 的 MySQLSpanReader（不启动批量写入）
+func newTestReader(t *testing.T) (*fakeDB, *MySQLSpanReader) {
+	t.Helper()
+	f, db := newFakeDB(t)
+	store := newMySQLStore(db, zerolog.Nop())
+	return f, store.SpanReader().(*MySQLSpanReader)
+}
+
+// spanColumns 是 jaeger_spans 完整 span 查询的列
+var spanColumns = []string{
+	"trace_id", "span_id", "operation_name", "flags",
+	"start_time", "duration", "tags", "logs", "refs", "process", "service_name",
+}
+
+// spanRow 将 span 转换为 spanColumns 对应的一行结果
+func spanRow(span *model.Span) []interface{} {
+	return []interface{}{
+		span.TraceID.String(),
+		span.SpanID.String(),
+		span.OperationName,
+		int64(span.Flags),
+		span.StartTime.UnixNano(),
+		span.Duration.Nanoseconds(),
+		marshalTags(span.Tags),
+		marshalLogs(span.Logs),
+		marshalRefs(span.References),
+		marshalProcess(span.Process),
+		span.Process.ServiceName,
+	}
+}
+
+// on 为包含 match 的 SQL 预设返回结果
+func (f *fakeDB) on(match string, columns []string, rows ...[]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results = append(f.results, fakeResult{match: match, columns: columns, rows: rows})
+}
+
+// onFunc 为包含 match 的 SQL 预设按参数生成结果的函数
+func (f *fakeDB) onFunc(match string, columns []string, fn func(args []interface{}) [][]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results = append(f.results, fakeResult{match: match, columns: columns, fn: fn})
+}
+
+// onQuery 为包含 match 的 SQL 预设可感知 context 的查询函数，可返回错误或阻塞直到取消
+func (f *fakeDB) onQuery(match string, columns []string, fn func(ctx context.Context, args []interface{}) ([][]interface{}, error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results = append(f.results, fakeResult{match: match, columns: columns, query: fn})
+}
+
+// onError 为包含 match 的 SQL 预设错误
+func (f *fakeDB) onError(match string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results = append(f.results, fakeResult{match: match, err: err})
+}
+
+// recorded 返回已执行的 SQL 副本
+func (f *fakeDB) recorded() []fakeQuery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeQuery(nil), f.queries...)
+}
+
+// matching 返回包含 substr 的已执行 SQL
+func (f *fakeDB) matching(substr string) []fakeQuery {
+	var out []fakeQuery
+	for _, q := range f.recorded() {
+		if strings.Contains(q.query, substr) {
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+func (f *fakeDB) record(query string, args []driver.NamedValue) fakeResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := make([]interface{}, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	f.queries = append(f.queries, fakeQuery{query: query, args: values})
+	for _, r := range f.results {
+		if strings.Contains(query, r.match) {
+			if r.fn != nil {
+				r.rows = r.fn(values)
+			}
+			r.args = values
+			return r
+		}
+	}
+	return fakeResult{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	f, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("fakedb %q not registered", name)
+	}
+	return &fakeConn{db: f}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepare not supported")
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, fmt.Errorf("tx not supported") }
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r := c.db.record(query, args)
+	if r.query != nil {
+		// 在锁外执行，允许多个查询并发阻塞
+		r.rows, r.err = r.query(ctx, r.args)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &fakeRows{columns: r.columns, rows: r.rows}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	r := c.db.record(query, args)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// CheckNamedValue 接受任意参数类型，由测试自行断言
+func (c *fakeConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if v, ok := nv.Value.(driver.Valuer); ok {
+		val, err := v.Value()
+		nv.Value = val
+		return err
+	}
+	return nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]interface{}
+	pos     int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	for i, v := range r.rows[r.pos] {
+		dest[i] = v
+	}
+	r.pos++
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// 按 span ID / 任意标识查找 trace
+// ====================
+//
+// 只知道 span_id，或者只有 tlog 日志中记录的请求号、订单号时，
+// 依次尝试：span_id 精确匹配、trace_id 精确匹配、tags/logs/process 全文匹配。
+// 结果按 trace 分组，精确匹配排在最前，全文匹配按相关度（WEIGHT()）排序。
+
+const (
+	// defaultLookupLimit 默认最多返回的 span 数
+	defaultLookupLimit = 100
+	// maxLookupLimit 最多返回的 span 数上限
+	maxLookupLimit = 1000
+)
+
+// 匹配方式
+const (
+	lookupMatchSpanID  = "span_id"
+	lookupMatchTraceID = "trace_id"
+	lookupMatchText    = "text"
+)
+
+// lookupScoreExact 精确匹配的得分，高于任何全文匹配的相关度
+const lookupScoreExact = 1 << 30
+
+// lookupQuery 查找条件
+type lookupQuery struct {
+	Token        string
+	StartTimeMin time.Time
+	StartTimeMax time.Time
+	Limit        int
+}
+
+// lookupSpan 命中的 span
+type lookupSpan struct {
+	SpanID    string `json:"spanID"`
+	Service   string `json:"service"`
+	Operation string `json:"operation"`
+	StartTime int64  `json:"startTime"` // 纳秒
+	Duration  int64  `json:"duration"`  // 纳秒
+	Match     string `json:"match"`     // span_id / trace_id / text
+	Score     int64  `json:"score"`
+}
+
+// lookupTrace 命中的 trace 及其中命中的 span
+type lookupTrace struct {
+	TraceID string       `json:"traceID"`
+	Score   int64        `json:"score"` // trace 内 span 的最高得分
+	Spans   []lookupSpan `json:"spans"`
+}
+
+// lookupColumns 查找时读取的列
+const lookupColumns = "trace_id, span_id, service_name, operation_name, start_time, duration"
+
+// LookupTraces 按 span ID、trace ID 或任意标识查找 trace，按得分倒序返回
+func (r *MySQLSpanReader) LookupTraces(ctx context.Context, q *lookupQuery) ([]lookupTrace, error) {
+	if q.Token == "" {
+		return nil, invalidQuery(errors.New("lookup token is required"))
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLookupLimit
+	}
+	if limit > maxLookupLimit {
+		return nil, invalidQuery(fmt.Errorf("limit %d exceeds maximum %d", limit, maxLookupLimit))
+	}
+	window := []interface{}{q.StartTimeMin.UnixNano(), q.StartTimeMax.UnixNano()}
+
+	var hits []lookupHit
+
+	// 形如 span ID / trace ID 的输入先做精确匹配（按 Jaeger 的格式规范化）
+	// 只接受标准长度的十六进制串，避免订单号之类的普通数字也触发 ID 查找
+	isSpanID := len(q.Token) == 16
+	isTraceID := len(q.Token) == 16 || len(q.Token) == 32
+	if spanID, err := model.SpanIDFromString(q.Token); err == nil && isSpanID {
+		found, err := r.lookupExact(ctx, "span_id", spanID.String(), lookupMatchSpanID, window, limit)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, found...)
+	}
+	if traceID, err := model.TraceIDFromString(q.Token); err == nil && isTraceID {
+		found, err := r.lookupExact(ctx, "trace_id", traceID.String(), lookupMatchTraceID, window, limit)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, found...)
+	}
+
+	// 全文匹配 tags / logs / process 等所有文本字段
+	query := fmt.Sprintf(`SELECT %s, WEIGHT() AS score FROM jaeger_spans
+		WHERE MATCH(?) AND start_time >= ? AND start_time <= ?
+		ORDER BY score DESC, start_time DESC LIMIT %d OPTION max_matches=%d`,
+		lookupColumns, limit, limit)
+	args := append([]interface{}{matchTerm(q.Token)}, window...)
+	found, err := r.queryLookupHits(ctx, query, args, lookupMatchText, true)
+	if err != nil {
+		return nil, err
+	}
+	hits = append(hits, found...)
+
+	return groupLookupHits(hits, limit), nil
+}
+
+// lookupHit 一行查找结果
+type lookupHit struct {
+	traceID string
+	span    lookupSpan
+}
+
+// lookupExact 按属性列精确匹配
+func (r *MySQLSpanReader) lookupExact(ctx context.Context, column, value, match string, window []interface{}, limit int) ([]lookupHit, error) {
+	query := fmt.Sprintf(`SELECT %s FROM jaeger_spans
+		WHERE %s = ? AND start_time >= ? AND start_time <= ?
+		ORDER BY start_time ASC LIMIT %d OPTION max_matches=%d`,
+		lookupColumns, column, limit, limit)
+	args := append([]interface{}{value}, window...)
+	return r.queryLookupHits(ctx, query, args, match, false)
+}
+
+// queryLookupHits 执行查找语句，withScore 表示最后一列为相关度
+func (r *MySQLSpanReader) queryLookupHits(ctx context.Context, query string, args []interface{}, match string, withScore bool) ([]lookupHit, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []lookupHit
+	for rows.Next() {
+		hit := lookupHit{span: lookupSpan{Match: match, Score: lookupScoreExact}}
+		dest := []interface{}{
+			&hit.traceID, &hit.span.SpanID, &hit.span.Service, &hit.span.Operation,
+			&hit.span.StartTime, &hit.span.Duration,
+		}
+		if withScore {
+			dest = append(dest, &hit.span.Score)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			r.logger.Warn().Err(err).Msg("Failed to scan lookup result")
+			continue
+		}
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+// groupLookupHits 按 trace 分组（同一 span 只保留得分最高的一次），按得分倒序，最多 limit 个 span
+func groupLookupHits(hits []lookupHit, limit int) []lookupTrace {
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].span.Score > hits[j].span.Score
+	})
+
+	var traces []lookupTrace
+	index := make(map[string]int)
+	seen := make(map[[2]string]bool)
+	count := 0
+	for _, hit := range hits {
+		if count >= limit {
+			break
+		}
+		key := [2]string{hit.traceID, hit.span.SpanID}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		count++
+
+		i, ok := index[hit.traceID]
+		if !ok {
+			i = len(traces)
+			index[hit.traceID] = i
+			traces = append(traces, lookupTrace{TraceID: hit.traceID, Score: hit.span.Score})
+		}
+		traces[i].Spans = append(traces[i].Spans, hit.span)
+	}
+	return traces
+}
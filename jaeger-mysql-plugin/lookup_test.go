@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var lookupTestColumns = []string{"trace_id", "span_id", "service_name", "operation_name", "start_time", "duration"}
+
+func TestLookupTracesRanksExactMatchesFirst(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("span_id = ?", lookupTestColumns,
+		[]interface{}{"t1", "00000000000000ab", "order", "GET", int64(10), int64(1)},
+	)
+	f.on("WEIGHT()", append(lookupTestColumns, "score"),
+		[]interface{}{"t2", "0000000000000002", "pay", "charge", int64(20), int64(1), int64(1500)},
+		[]interface{}{"t1", "00000000000000ab", "order", "GET", int64(10), int64(1), int64(1000)},
+		[]interface{}{"t2", "0000000000000003", "pay", "refund", int64(30), int64(1), int64(1200)},
+	)
+
+	traces, err := reader.LookupTraces(context.Background(), &lookupQuery{
+		Token:        "00000000000000AB",
+		StartTimeMin: time.Unix(0, 0),
+		StartTimeMax: time.Unix(100, 0),
+	})
+	if err != nil {
+		t.Fatalf("LookupTraces: %v", err)
+	}
+
+	var ids []string
+	for _, tr := range traces {
+		ids = append(ids, tr.TraceID)
+	}
+	if !reflect.DeepEqual(ids, []string{"t1", "t2"}) {
+		t.Fatalf("traces = %v", ids)
+	}
+	if len(traces[0].Spans) != 1 || traces[0].Spans[0].Match != lookupMatchSpanID {
+		t.Errorf("t1 spans = %+v", traces[0].Spans)
+	}
+	if len(traces[1].Spans) != 2 || traces[1].Score != 1500 {
+		t.Errorf("t2 = %+v", traces[1])
+	}
+
+	exact := f.matching("span_id = ?")[0]
+	if exact.args[0] != "00000000000000ab" {
+		t.Errorf("span id arg = %v, want normalized", exact.args[0])
+	}
+}
+
+func TestLookupTracesTextOnly(t *testing.T) {
+	f, reader := newTestReader(t)
+
+	_, err := reader.LookupTraces(context.Background(), &lookupQuery{
+		Token:        "order-2024/001",
+		StartTimeMin: time.Unix(0, 0),
+		StartTimeMax: time.Unix(100, 0),
+		Limit:        10,
+	})
+	if err != nil {
+		t.Fatalf("LookupTraces: %v", err)
+	}
+	if got := len(f.matching("span_id = ?")) + len(f.matching("trace_id = ?")); got != 0 {
+		t.Errorf("non-hex token should not run exact lookups, got %d", got)
+	}
+	text := f.matching("WEIGHT()")
+	if len(text) != 1 {
+		t.Fatalf("expected 1 text query, got %d", len(text))
+	}
+	if text[0].args[0] != `"order\-2024\/001"` {
+		t.Errorf("match arg = %v", text[0].args[0])
+	}
+	if !strings.Contains(text[0].query, "LIMIT 10 OPTION max_matches=10") {
+		t.Errorf("query:\n%s", text[0].query)
+	}
+
+	if _, err := reader.LookupTraces(context.Background(), &lookupQuery{}); err == nil {
+		t.Error("expected error for empty token")
+	}
+}
+
+func TestLookupTracesShortHexIsText(t *testing.T) {
+	f, reader := newTestReader(t)
+	// 订单号等普通数字也是合法的十六进制，但长度不是 16/32，不做 ID 查找
+	for _, token := range []string{"1234567", "deadbeef", "123456789012345678"} {
+		if _, err := reader.LookupTraces(context.Background(), &lookupQuery{Token: token}); err != nil {
+			t.Fatalf("LookupTraces(%q): %v", token, err)
+		}
+	}
+	if got := len(f.matching("span_id = ?")) + len(f.matching("trace_id = ?")); got != 0 {
+		t.Errorf("short hex tokens ran %d exact lookups", got)
+	}
+
+	if _, err := reader.LookupTraces(context.Background(), &lookupQuery{Token: strings.Repeat("a", 32)}); err != nil {
+		t.Fatalf("LookupTraces: %v", err)
+	}
+	if len(f.matching("trace_id = ?")) != 1 || len(f.matching("span_id = ?")) != 0 {
+		t.Error("32-character token should only run a trace ID lookup")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -23,6 +24,7 @@ var (
 	mysqlDB   = flag.String("mysql-db", "jaeger", "MySQL database name")
 	mysqlUser = flag.String("mysql-user", "root", "MySQL username")
 	mysqlPass = flag.String("mysql-pass", "", "MySQL password")
+	httpAddr  = flag.String("http-addr", ":17272", "Extended HTTP API address (empty to disable)")
 )
 
 // ====================
@@ -146,6 +148,18 @@ func main() {
 
 	logger.Info().Str("address", *grpcAddr).Msg("Starting gRPC server")
 
+	// 启动扩展 HTTP API
+	apiCtx, stopAPI := context.WithCancel(context.Background())
+	defer stopAPI()
+	if *httpAddr != "" {
+		api := newAPIServer(store)
+		go func() {
+			if err := api.serve(apiCtx, *httpAddr); err != nil {
+				logger.Error().Err(err).Msg("HTTP API server failed")
+			}
+		}()
+	}
+
 	// 处理信号
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -154,7 +168,8 @@ func main() {
 		<-sigChan
 		logger.Info().Msg("Shutting down...")
 
-		// 优雅关闭 gRPC 服务器
+		// 优雅关闭 HTTP API 和 gRPC 服务器
+		stopAPI()
 		grpcServer.GracefulStop()
 
 		// 关闭存储（刷新批量写入缓冲区）
@@ -170,6 +185,20 @@ func main() {
 	}
 }
 
+// schemaMigrations 旧表升级语句，按顺序执行
+var schemaMigrations = []string{
+	// tag_kv: "key=value" 形式的 tag 全文索引（只索引不存储），旧数据由 backfillDerivedColumns 补齐
+	`ALTER TABLE jaeger_spans ADD COLUMN tag_kv text indexed`,
+	// tag_attrs: 带类型的 tag JSON，用于比较/取反/前缀过滤，旧数据由 backfillDerivedColumns 补齐
+	`ALTER TABLE jaeger_spans ADD COLUMN tag_attrs json`,
+	// span_kind: 从 span.kind tag 提取，旧数据由 backfillSpanKind 补齐
+	`ALTER TABLE jaeger_spans ADD COLUMN span_kind string`,
+	// is_error: 写入时由 error / otel.status_code / HTTP 状态码计算，旧数据由 backfillDerivedColumns 补齐
+	`ALTER TABLE jaeger_spans ADD COLUMN is_error int`,
+	// derived_version: 派生列的版本，旧数据为 0，由 backfillDerivedColumns 重新计算
+	`ALTER TABLE jaeger_spans ADD COLUMN derived_version int`,
+}
+
 func initDatabase(db *sql.DB, logger zerolog.Logger) error {
 	logger.Info().Msg("Initializing database tables...")
 
@@ -195,7 +224,12 @@ func initDatabase(db *sql.DB, logger zerolog.Logger) error {
 		logs text,
 		refs text,
 		process text,
-		service_name string attribute
+		service_name string attribute,
+		tag_kv text indexed,
+		tag_attrs json,
+		span_kind string attribute,
+		is_error int,
+		derived_version int
 	) ngram_len='1' ngram_chars='cjk' min_word_len='1'
 	`
 
@@ -206,6 +240,89 @@ func initDatabase(db *sql.DB, logger zerolog.Logger) error {
 		// ManticoreSearch 可能已有表或语法略有不同，我们尝试继续
 	}
 
+	// 服务/操作目录表，由批量写入维护，供 GetServices / GetOperations 查询
+	createOperationsSQL := `
+	CREATE TABLE IF NOT EXISTS jaeger_operations (
+		service_name string attribute,
+		operation_name string attribute,
+		span_kind string attribute,
+		last_seen bigint
+	)
+	`
+	if _, err := db.Exec(createOperationsSQL); err != nil {
+		logger.Warn().Err(err).Msg("Failed to create operations table (may already exist)")
+	}
+
+	// trace 摘要表，由批量写入维护，供 trace 级搜索使用
+	// services 为换行分隔的服务列表，全文索引用于"包含某服务"的过滤
+	createTracesSQL := `
+	CREATE TABLE IF NOT EXISTS jaeger_traces (
+		trace_id string attribute,
+		root_service string attribute,
+		root_operation string attribute,
+		start_time bigint,
+		end_time bigint,
+		duration bigint,
+		span_count int,
+		services text,
+		is_error int
+	)
+	`
+	if _, err := db.Exec(createTracesSQL); err != nil {
+		logger.Warn().Err(err).Msg("Failed to create traces table (may already exist)")
+	}
+
+	// 依赖关系聚合表，由后台任务按时间桶写入
+	createDependenciesSQL := `
+	CREATE TABLE IF NOT EXISTS jaeger_dependencies (
+		bucket_start bigint,
+		parent string attribute,
+		child string attribute,
+		call_count bigint
+	)
+	`
+	if _, err := db.Exec(createDependenciesSQL); err != nil {
+		logger.Warn().Err(err).Msg("Failed to create dependencies table (may already exist)")
+	}
+
+	// span 指标汇总表，由写入端按时间桶插入增量（SPAN_METRICS_ROLLUP=true）
+	// le 为耗时分桶下标，与 RED 指标查询中 INTERVAL() 的结果一致
+	createSpanMetricsSQL := `
+	CREATE TABLE IF NOT EXISTS jaeger_span_metrics (
+		bucket_start bigint,
+		service_name string attribute,
+		operation_name string attribute,
+		span_kind string attribute,
+		le int,
+		calls bigint,
+		errors bigint,
+		duration_sum bigint
+	)
+	`
+	if _, err := db.Exec(createSpanMetricsSQL); err != nil {
+		logger.Warn().Err(err).Msg("Failed to create span metrics table (may already exist)")
+	}
+
+	// 后台任务状态表（一次性初始化标记、增量任务进度）
+	createStateSQL := `
+	CREATE TABLE IF NOT EXISTS jaeger_state (
+		name string attribute,
+		value bigint
+	)
+	`
+	if _, err := db.Exec(createStateSQL); err != nil {
+		logger.Warn().Err(err).Msg("Failed to create state table (may already exist)")
+	}
+
+	// 为旧版本创建的表补充新增列（列已存在时 ManticoreSearch 返回错误，忽略即可）
+	for _, stmt := range schemaMigrations {
+		if _, err := db.Exec(stmt); err != nil {
+			logger.Debug().Err(err).Str("sql", stmt).Msg("Schema migration skipped")
+		} else {
+			logger.Info().Str("sql", stmt).Msg("Schema migration applied")
+		}
+	}
+
 	logger.Info().Msg("Database initialization complete")
 	return nil
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/rs/zerolog"
+)
+
+// ====================
+// 操作名规范化与基数限制
+// ====================
+//
+// 带 ID 的 URL、带字面量的 SQL 会产生大量不同的操作名，撑爆 GetOperations 列表和缓存。
+// 写入时按以下顺序改写 span.OperationName：
+//  1. 自定义规则（OPERATION_NORMALIZE_RULES，每行一条 "正则 => 替换"，替换中可用 $1 等引用）
+//  2. 内置规则（OPERATION_NORMALIZE_BUILTIN=true）：UUID → {uuid}，8 位以上含数字的十六进制串 → {id}，
+//     SQL 字符串字面量 → ?，独立数字 → {num}
+//  3. 每个服务最多 OPERATION_MAX_PER_SERVICE 个不同操作名，超出的归入 __other__
+//
+// 改写后原始操作名保存在 operation.original tag 中，仍可通过全文搜索找到。
+// 基数计数只在本进程内存中维护，重启后重新计数。
+
+var (
+	// 是否启用内置规范化规则
+	operationNormalizeBuiltin = getBoolEnv("OPERATION_NORMALIZE_BUILTIN", false)
+	// 自定义改写规则，每行一条 "正则 => 替换"
+	operationNormalizeRules = getStringEnv("OPERATION_NORMALIZE_RULES", "")
+	// 每个服务最多保留的不同操作名数，<= 0 表示不限制
+	operationMaxPerService = getIntEnv("OPERATION_MAX_PER_SERVICE", 0)
+)
+
+const (
+	// otherOperation 超出基数限制的操作名
+	otherOperation = "__other__"
+	// originalOperationTag 保存改写前操作名的 tag
+	originalOperationTag = "operation.original"
+	// normalizeRuleSeparator 规则中正则与替换的分隔符
+	normalizeRuleSeparator = "=>"
+)
+
+// normalizeRule 一条改写规则
+type normalizeRule struct {
+	re          *regexp.Regexp
+	replacement string
+	accept      func(match string) bool // 非 nil 时只替换满足条件的匹配（RE2 不支持前瞻）
+}
+
+// builtinNormalizeRules 内置规则，顺序很重要：先替换 UUID 和长 ID，再替换剩余数字
+var builtinNormalizeRules = []normalizeRule{
+	{re: regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), replacement: "{uuid}"},
+	// 至少 8 位且包含数字的十六进制串（trace ID、哈希、对象 ID 等），纯字母的单词不替换
+	{re: regexp.MustCompile(`\b[0-9a-fA-F]{8,}\b`), replacement: "{id}", accept: containsDigit},
+	{re: regexp.MustCompile(`'(?:[^']|'')*'`), replacement: "?"},
+	{re: regexp.MustCompile(`\b\d+\b`), replacement: "{num}"},
+}
+
+// containsDigit 判断字符串是否包含数字
+func containsDigit(s string) bool {
+	return strings.ContainsAny(s, "0123456789")
+}
+
+// operationNormalizer 写入端的操作名改写器
+type operationNormalizer struct {
+	rules []normalizeRule
+	max   int
+
+	mu         sync.Mutex
+	perService map[string]map[string]struct{}
+}
+
+// newOperationNormalizer 按配置创建改写器，未配置任何规则时返回 nil
+func newOperationNormalizer(logger zerolog.Logger) *operationNormalizer {
+	rules, err := parseNormalizeRules(operationNormalizeRules)
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid OPERATION_NORMALIZE_RULES, custom rules disabled")
+		rules = nil
+	}
+	if operationNormalizeBuiltin {
+		rules = append(rules, builtinNormalizeRules...)
+	}
+	if len(rules) == 0 && operationMaxPerService <= 0 {
+		return nil
+	}
+	return &operationNormalizer{
+		rules:      rules,
+		max:        operationMaxPerService,
+		perService: make(map[string]map[string]struct{}),
+	}
+}
+
+// parseNormalizeRules 解析 "正则 => 替换" 规则，每行一条，忽略空行和 # 开头的注释
+func parseNormalizeRules(spec string) ([]normalizeRule, error) {
+	var rules []normalizeRule
+	for i, line := range strings.Split(spec, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, replacement, ok := strings.Cut(line, normalizeRuleSeparator)
+		if !ok {
+			return nil, fmt.Errorf("rule %d: missing %q in %q", i+1, normalizeRuleSeparator, line)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		rules = append(rules, normalizeRule{re: re, replacement: strings.TrimSpace(replacement)})
+	}
+	return rules, nil
+}
+
+// normalize 返回改写后的操作名
+func (n *operationNormalizer) normalize(operation string) string {
+	for _, rule := range n.rules {
+		if rule.accept == nil {
+			operation = rule.re.ReplaceAllString(operation, rule.replacement)
+			continue
+		}
+		operation = rule.re.ReplaceAllStringFunc(operation, func(match string) string {
+			if rule.accept(match) {
+				return rule.replacement
+			}
+			return match
+		})
+	}
+	return operation
+}
+
+// limit 检查服务的操作名基数，超出上限的新操作名返回 otherOperation
+func (n *operationNormalizer) limit(service, operation string) string {
+	if n.max <= 0 {
+		return operation
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	seen, ok := n.perService[service]
+	if !ok {
+		seen = make(map[string]struct{})
+		n.perService[service] = seen
+	}
+	if _, ok := seen[operation]; ok {
+		return operation
+	}
+	if len(seen) >= n.max {
+		return otherOperation
+	}
+	seen[operation] = struct{}{}
+	return operation
+}
+
+// apply 改写 span 的操作名，改写时保留原始名称到 tag
+func (n *operationNormalizer) apply(span *model.Span) {
+	if n == nil {
+		return
+	}
+	original := span.OperationName
+	service := ""
+	if span.Process != nil {
+		service = span.Process.ServiceName
+	}
+
+	operation := n.limit(service, n.normalize(original))
+	if operation == original {
+		return
+	}
+	span.OperationName = operation
+	// 复制后再追加，避免写入调用方持有的底层数组
+	tags := make([]model.KeyValue, len(span.Tags), len(span.Tags)+1)
+	copy(tags, span.Tags)
+	span.Tags = append(tags, model.String(originalOperationTag, original))
+}
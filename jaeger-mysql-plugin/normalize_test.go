@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestBuiltinNormalizeRules(t *testing.T) {
+	n := &operationNormalizer{rules: builtinNormalizeRules}
+	tests := map[string]string{
+		"GET /orders/123": "GET /orders/{num}",
+		"GET /users/3fa85f64-5717-4562-b3fc-2c963f66afa6/cart": "GET /users/{uuid}/cart",
+		"GET /objects/5f2b8c9e1a7d4e3f":                        "GET /objects/{id}",
+		"GET /v1/feedback":                                     "GET /v1/feedback",
+		"GET /deadbeefcafe":                                    "GET /deadbeefcafe",
+		"SELECT * FROM users WHERE id = 42 AND name = 'bob'":   "SELECT * FROM users WHERE id = {num} AND name = ?",
+	}
+	for in, want := range tests {
+		if got := n.normalize(in); got != want {
+			t.Errorf("normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseNormalizeRules(t *testing.T) {
+	rules, err := parseNormalizeRules(`
+		# 去掉查询参数
+		\?.*$ =>
+		^(GET|POST) /api/v\d+/ => $1 /api/
+	`)
+	if err != nil {
+		t.Fatalf("parseNormalizeRules: %v", err)
+	}
+	n := &operationNormalizer{rules: rules}
+	if got := n.normalize("GET /api/v2/orders?page=3"); got != "GET /api/orders" {
+		t.Errorf("normalize = %q", got)
+	}
+
+	for _, bad := range []string{"no separator", "([ => x"} {
+		if _, err := parseNormalizeRules(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestOperationNormalizerLimit(t *testing.T) {
+	n := &operationNormalizer{max: 2, perService: make(map[string]map[string]struct{})}
+	span := func(service, op string) *model.Span {
+		return &model.Span{OperationName: op, Process: &model.Process{ServiceName: service}}
+	}
+
+	for _, op := range []string{"a", "b", "a"} {
+		s := span("svc", op)
+		n.apply(s)
+		if s.OperationName != op {
+			t.Errorf("%q should be kept, got %q", op, s.OperationName)
+		}
+	}
+
+	s := span("svc", "c")
+	n.apply(s)
+	if s.OperationName != otherOperation {
+		t.Errorf("overflow operation = %q, want %q", s.OperationName, otherOperation)
+	}
+	if tag, ok := model.KeyValues(s.Tags).FindByKey(originalOperationTag); !ok || tag.AsString() != "c" {
+		t.Errorf("original operation tag missing: %v", s.Tags)
+	}
+
+	// 其他服务单独计数
+	s = span("other", "c")
+	n.apply(s)
+	if s.OperationName != "c" {
+		t.Errorf("other service operation = %q", s.OperationName)
+	}
+}
+
+func TestOperationNormalizerDoesNotShareTags(t *testing.T) {
+	n := &operationNormalizer{rules: builtinNormalizeRules}
+	// 调用方的 tags 切片还有剩余容量，追加时不能写入其底层数组
+	shared := make([]model.KeyValue, 1, 4)
+	shared[0] = model.String("http.method", "GET")
+	s := &model.Span{OperationName: "GET /orders/123", Tags: shared, Process: &model.Process{ServiceName: "svc"}}
+
+	n.apply(s)
+	if len(s.Tags) != 2 {
+		t.Fatalf("tags = %v, want original operation appended", s.Tags)
+	}
+	if extended := shared[:2]; extended[1].Key == originalOperationTag {
+		t.Error("apply wrote into the caller's tags array")
+	}
+}
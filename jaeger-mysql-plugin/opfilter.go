@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// ====================
+// 操作名模式匹配
+// ====================
+//
+// OperationName 除精确匹配外支持两种模式，均需显式前缀：
+//
+//	glob:GET /orders/*   以 glob: 开头的通配符：* 匹配任意字符串，? 匹配单个字符
+//	~^GET /orders/\d+$   以 ~ 开头的正则表达式（RE2 语法，与 ManticoreSearch 一致）
+//
+// 没有前缀的操作名一律精确匹配：真实操作名常含 * 和 ?（如规范化后的
+// "SELECT * FROM t WHERE id = ?"），不能当作通配符。
+//
+// 两种模式都编译为 REGEX(operation_name, ?)。正则在每一行上求值，
+// 为避免全表扫描式的昂贵查询，限制模式长度并要求至少包含若干字面字符。
+// 不合法或不满足限制的模式作为查询错误返回（HTTP API 返回 400），不会静默退化为
+// 精确匹配而返回空结果。
+
+var (
+	// 操作名模式的最大长度
+	operationPatternMaxLen = getIntEnv("OPERATION_PATTERN_MAX_LEN", 256)
+	// 操作名模式至少包含的字面字符数（拒绝 "*"、"~.*" 之类匹配一切的模式）
+	operationPatternMinLiteral = getIntEnv("OPERATION_PATTERN_MIN_LITERAL", 3)
+)
+
+// 模式前缀
+const (
+	operationRegexPrefix = "~"
+	operationGlobPrefix  = "glob:"
+)
+
+// operationFilter 编译后的操作名过滤条件
+type operationFilter struct {
+	exact   string         // 精确匹配时的操作名
+	pattern string         // 模式匹配时的正则
+	re      *regexp.Regexp // 模式匹配时用于内存求值
+}
+
+// parseOperationFilter 解析 OperationName：没有模式前缀时为精确匹配，
+// 带前缀的模式不合法时返回 invalidQuery 错误
+func parseOperationFilter(name string) (*operationFilter, error) {
+	var pattern string
+	switch {
+	case strings.HasPrefix(name, operationRegexPrefix):
+		pattern = strings.TrimPrefix(name, operationRegexPrefix)
+	case strings.HasPrefix(name, operationGlobPrefix):
+		pattern = wildcardToRegex(strings.TrimPrefix(name, operationGlobPrefix))
+	default:
+		return &operationFilter{exact: name}, nil
+	}
+	if len(name) > operationPatternMaxLen {
+		return nil, invalidQuery(fmt.Errorf("invalid operation pattern: longer than %d characters", operationPatternMaxLen))
+	}
+
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, invalidQuery(fmt.Errorf("invalid operation pattern %q: %w", name, err))
+	}
+	if n := literalCount(parsed); n < operationPatternMinLiteral {
+		return nil, invalidQuery(fmt.Errorf("invalid operation pattern %q: needs at least %d literal characters, has %d",
+			name, operationPatternMinLiteral, n))
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, invalidQuery(fmt.Errorf("invalid operation pattern %q: %w", name, err))
+	}
+	return &operationFilter{pattern: pattern, re: re}, nil
+}
+
+// wildcardToRegex 将通配符模式转换为锚定的正则
+func wildcardToRegex(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('^')
+	for _, part := range strings.SplitAfter(s, "") {
+		switch part {
+		case "*":
+			sb.WriteString(".*")
+		case "?":
+			sb.WriteByte('.')
+		default:
+			sb.WriteString(regexp.QuoteMeta(part))
+		}
+	}
+	sb.WriteByte('$')
+	return sb.String()
+}
+
+// literalCount 统计正则中必须出现的字面字符数（只计算不在重复或分支中的部分）
+func literalCount(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune)
+	case syntax.OpConcat, syntax.OpCapture:
+		n := 0
+		for _, sub := range re.Sub {
+			n += literalCount(sub)
+		}
+		return n
+	case syntax.OpPlus:
+		return literalCount(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return literalCount(re.Sub[0])
+		}
+	case syntax.OpAlternate:
+		// 分支取最少的一支
+		fewest := -1
+		for _, sub := range re.Sub {
+			if n := literalCount(sub); fewest < 0 || n < fewest {
+				fewest = n
+			}
+		}
+		if fewest > 0 {
+			return fewest
+		}
+	}
+	return 0
+}
+
+// sql 返回 WHERE 条件及参数
+func (f *operationFilter) sql() (string, interface{}) {
+	if f.re == nil {
+		return "operation_name = ?", f.exact
+	}
+	return "REGEX(operation_name, ?)", f.pattern
+}
+
+// matches 在内存中判断操作名是否满足条件
+func (f *operationFilter) matches(operation string) bool {
+	if f.re == nil {
+		return operation == f.exact
+	}
+	return f.re.MatchString(operation)
+}
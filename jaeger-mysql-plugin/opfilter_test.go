@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func TestParseOperationFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string // 期望的正则，空表示精确匹配
+		match   []string
+		noMatch []string
+	}{
+		{"GET /orders", "", []string{"GET /orders"}, []string{"GET /orders/1"}},
+		{"glob:GET /orders/*", `^GET /orders/.*$`, []string{"GET /orders/123", "GET /orders/"}, []string{"POST /orders/1", "GET /orders"}},
+		{"glob:GET /order?/1", `^GET /order./1$`, []string{"GET /orders/1"}, []string{"GET /order/1"}},
+		{"glob:SELECT *.users", `^SELECT .*\.users$`, []string{"SELECT app.users"}, []string{"SELECT appXusers"}},
+		{`~^GET /orders/\d+$`, `^GET /orders/\d+$`, []string{"GET /orders/42"}, []string{"GET /orders/abc"}},
+		// 没有前缀时 * 和 ? 是操作名的一部分
+		{"SELECT * FROM t WHERE id = ?", "", []string{"SELECT * FROM t WHERE id = ?"}, []string{"SELECT a FROM t WHERE id = 1"}},
+	}
+	for _, tt := range tests {
+		f, err := parseOperationFilter(tt.name)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.name, err)
+		}
+		if f.pattern != tt.pattern {
+			t.Errorf("%q: pattern = %q, want %q", tt.name, f.pattern, tt.pattern)
+		}
+		for _, op := range tt.match {
+			if !f.matches(op) {
+				t.Errorf("%q should match %q", tt.name, op)
+			}
+		}
+		for _, op := range tt.noMatch {
+			if f.matches(op) {
+				t.Errorf("%q should not match %q", tt.name, op)
+			}
+		}
+	}
+}
+
+func TestParseOperationFilterRejectsExpensivePatterns(t *testing.T) {
+	for _, name := range []string{
+		"glob:*",
+		"glob:??*",
+		"~.*",
+		"~(a|.*)+",
+		"~[",
+		"glob:GET /" + strings.Repeat("x", operationPatternMaxLen) + "*",
+	} {
+		// 不合法的模式返回查询错误，而不是退化为精确匹配
+		if _, err := parseOperationFilter(name); !isInvalidQuery(err) {
+			t.Errorf("%q: expected invalid query error, got %v", name, err)
+		}
+	}
+
+	// 搜索返回错误，HTTP API 据此返回 400
+	_, err := buildTraceSearchSQL(&spanstore.TraceQueryParameters{OperationName: "glob:*", NumTraces: 20})
+	if !isInvalidQuery(err) {
+		t.Errorf("search with bad pattern: expected invalid query error, got %v", err)
+	}
+	if _, err := newSpanMatcher(&spanstore.TraceQueryParameters{OperationName: "~["}); !isInvalidQuery(err) {
+		t.Errorf("pending matcher with bad pattern: expected invalid query error, got %v", err)
+	}
+}
+
+func TestBuildTraceSearchSQLOperationPattern(t *testing.T) {
+	q, err := buildTraceSearchSQL(&spanstore.TraceQueryParameters{
+		ServiceName:   "order",
+		OperationName: "glob:GET /orders/*",
+		StartTimeMin:  time.Unix(0, 0),
+		StartTimeMax:  time.Unix(100, 0),
+		NumTraces:     20,
+	})
+	if err != nil {
+		t.Fatalf("buildTraceSearchSQL: %v", err)
+	}
+	if !strings.Contains(q.query, "REGEX(operation_name, ?)") || strings.Contains(q.query, "operation_name = ?") {
+		t.Errorf("query:\n%s", q.query)
+	}
+	if q.args[3] != `^GET /orders/.*$` {
+		t.Errorf("pattern arg = %v", q.args[3])
+	}
+
+	q, err = buildTraceSearchSQL(&spanstore.TraceQueryParameters{OperationName: "SELECT * FROM t WHERE id = ?"})
+	if err != nil {
+		t.Fatalf("buildTraceSearchSQL: %v", err)
+	}
+	if !strings.Contains(q.query, "operation_name = ?") || strings.Contains(q.query, "REGEX(") {
+		t.Errorf("unprefixed operation should use exact match:\n%s", q.query)
+	}
+}
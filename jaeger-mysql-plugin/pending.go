@@ -0,0 +1,184 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+// ====================
+// 未刷新 span 索引（read-your-writes）
+// ====================
+//
+// span 先进入 spanBuffer 再批量写入，最长 BATCH_TIMEOUT 内查询不到。
+// WriteSpan 入队时同时按 trace ID 记录到内存索引，批量写入完成后移除，
+// GetTrace / FindTraces 会把索引中的 span 合并到查询结果中。
+
+// readYourWritesEnabled 是否在读取时合并尚未写入数据库的 span
+var readYourWritesEnabled = getBoolEnv("READ_YOUR_WRITES", true)
+
+// pendingSpans 按 trace ID 索引尚未写入数据库的 span
+type pendingSpans struct {
+	mu      sync.RWMutex
+	byTrace map[model.TraceID][]*model.Span
+}
+
+func newPendingSpans() *pendingSpans {
+	return &pendingSpans{byTrace: make(map[model.TraceID][]*model.Span)}
+}
+
+// add 记录一个待写入的 span
+func (p *pendingSpans) add(span *model.Span) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.byTrace[span.TraceID] = append(p.byTrace[span.TraceID], span)
+	p.mu.Unlock()
+}
+
+// remove 移除已写入（或写入失败）的 span，按指针匹配
+func (p *pendingSpans) remove(spans []*model.Span) {
+	if p == nil || len(spans) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, span := range spans {
+		list := p.byTrace[span.TraceID]
+		for i, s := range list {
+			if s == span {
+				list = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(p.byTrace, span.TraceID)
+		} else {
+			p.byTrace[span.TraceID] = list
+		}
+	}
+}
+
+// get 返回某个 trace 的待写入 span（副本）
+func (p *pendingSpans) get(traceID model.TraceID) []*model.Span {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	list := p.byTrace[traceID]
+	if len(list) == 0 {
+		return nil
+	}
+	return append([]*model.Span(nil), list...)
+}
+
+// find 返回有 span 满足查询条件的 trace，值为满足条件的 span 中最大的 start_time
+func (p *pendingSpans) find(m *spanMatcher) map[string]int64 {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	out := make(map[string]int64)
+	for traceID, spans := range p.byTrace {
+		for _, span := range spans {
+			if !m.matches(span) {
+				continue
+			}
+			key := traceID.String()
+			if ts := span.StartTime.UnixNano(); ts > out[key] {
+				out[key] = ts
+			}
+		}
+	}
+	return out
+}
+
+// spanMatcher 在内存中按 buildTraceSearchSQL 相同的语义判断 span 是否满足查询
+type spanMatcher struct {
+	query     *spanstore.TraceQueryParameters
+	conjuncts []*tagNode
+	operation *operationFilter
+}
+
+// newSpanMatcher 解析查询条件，语法错误与 buildTraceSearchSQL 一致
+func newSpanMatcher(query *spanstore.TraceQueryParameters) (*spanMatcher, error) {
+	conjuncts, err := parseTagConjuncts(query.Tags)
+	if err != nil {
+		return nil, err
+	}
+	m := &spanMatcher{query: query, conjuncts: conjuncts}
+	if query.OperationName != "" {
+		if m.operation, err = parseOperationFilter(query.OperationName); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// matches 判断 span 是否满足查询
+func (m *spanMatcher) matches(span *model.Span) bool {
+	query := m.query
+	if span.Process == nil {
+		return false
+	}
+	if service := searchServiceName(query); service != "" && span.Process.ServiceName != service {
+		return false
+	}
+	if m.operation != nil && !m.operation.matches(span.OperationName) {
+		return false
+	}
+	if span.StartTime.Before(query.StartTimeMin) || span.StartTime.After(query.StartTimeMax) {
+		return false
+	}
+	if query.DurationMin > 0 && span.Duration < query.DurationMin {
+		return false
+	}
+	if query.DurationMax > 0 && span.Duration > query.DurationMax {
+		return false
+	}
+	if isError, ok, _ := errorFilterOf(query.Tags); ok && spanIsError(span.Tags) != isError {
+		return false
+	}
+	for _, node := range m.conjuncts {
+		if !evalTagNode(node, span.Tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeSpans 合并数据库中的 span 和待写入的 span，按 span ID 去重并按 start_time 排序
+// 不修改传入的切片
+func mergeSpans(stored, pending []*model.Span) []*model.Span {
+	if len(pending) == 0 {
+		return stored
+	}
+	seen := make(map[model.SpanID]struct{}, len(stored))
+	merged := make([]*model.Span, 0, len(stored)+len(pending))
+	for _, span := range stored {
+		seen[span.SpanID] = struct{}{}
+		merged = append(merged, span)
+	}
+	added := false
+	for _, span := range pending {
+		if _, ok := seen[span.SpanID]; ok {
+			continue
+		}
+		seen[span.SpanID] = struct{}{}
+		merged = append(merged, span)
+		added = true
+	}
+	if !added {
+		return stored
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].StartTime.Before(merged[j].StartTime)
+	})
+	return merged
+}
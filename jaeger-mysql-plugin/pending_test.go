@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func pendingSpan(traceID model.TraceID, spanID uint64, start time.Time, tags ...model.KeyValue) *model.Span {
+	return &model.Span{
+		TraceID:       traceID,
+		SpanID:        model.NewSpanID(spanID),
+		OperationName: "GET /orders",
+		StartTime:     start,
+		Duration:      time.Millisecond,
+		Tags:          tags,
+		Process:       &model.Process{ServiceName: "order-service"},
+	}
+}
+
+func TestGetTraceMergesPendingSpans(t *testing.T) {
+	f, reader := newTestReader(t)
+	ctx := context.Background()
+	traceID := model.NewTraceID(0, 11)
+	now := time.Now()
+
+	stored := pendingSpan(traceID, 1, now)
+	f.on("WHERE trace_id = ?", spanColumns, spanRow(stored))
+
+	// 数据库中已有的 span 重复出现在待写入索引中时应去重
+	writer := reader.store.SpanWriter()
+	for _, span := range []*model.Span{pendingSpan(traceID, 2, now.Add(time.Millisecond)), stored} {
+		if err := writer.WriteSpan(ctx, span); err != nil {
+			t.Fatalf("WriteSpan: %v", err)
+		}
+	}
+
+	trace, err := reader.GetTrace(ctx, traceID)
+	if err != nil {
+		t.Fatalf("GetTrace: %v", err)
+	}
+	if len(trace.Spans) != 2 {
+		t.Fatalf("spans = %d, want 2", len(trace.Spans))
+	}
+	if trace.Spans[0].SpanID != model.NewSpanID(1) || trace.Spans[1].SpanID != model.NewSpanID(2) {
+		t.Errorf("spans not ordered by start time: %v, %v", trace.Spans[0].SpanID, trace.Spans[1].SpanID)
+	}
+}
+
+func TestGetTraceFromPendingOnly(t *testing.T) {
+	_, reader := newTestReader(t)
+	ctx := context.Background()
+	traceID := model.NewTraceID(0, 12)
+
+	if err := reader.store.SpanWriter().WriteSpan(ctx, pendingSpan(traceID, 1, time.Now())); err != nil {
+		t.Fatalf("WriteSpan: %v", err)
+	}
+	trace, err := reader.GetTrace(ctx, traceID)
+	if err != nil {
+		t.Fatalf("GetTrace: %v", err)
+	}
+	if len(trace.Spans) != 1 {
+		t.Errorf("spans = %d, want 1", len(trace.Spans))
+	}
+
+	// 写入完成后从索引移除
+	reader.store.pending.remove(trace.Spans)
+	if _, err := reader.GetTrace(ctx, model.NewTraceID(0, 13)); err != spanstore.ErrTraceNotFound {
+		t.Errorf("GetTrace err = %v, want ErrTraceNotFound", err)
+	}
+	if got := reader.store.pending.get(traceID); len(got) != 0 {
+		t.Errorf("pending spans = %d, want 0", len(got))
+	}
+}
+
+func TestFindTracesIncludesPendingTraces(t *testing.T) {
+	_, reader := newTestReader(t)
+	ctx := context.Background()
+	now := time.Now()
+	matching := model.NewTraceID(0, 21)
+	other := model.NewTraceID(0, 22)
+
+	writer := reader.store.SpanWriter()
+	writer.WriteSpan(ctx, pendingSpan(matching, 1, now, model.Int64("http.status_code", 503)))
+	writer.WriteSpan(ctx, pendingSpan(other, 2, now, model.Int64("http.status_code", 200)))
+
+	traces, err := reader.FindTraces(ctx, &spanstore.TraceQueryParameters{
+		ServiceName:  "order-service",
+		Tags:         map[string]string{"http.status_code>": "500"},
+		StartTimeMin: now.Add(-time.Minute),
+		StartTimeMax: now.Add(time.Minute),
+		NumTraces:    10,
+	})
+	if err != nil {
+		t.Fatalf("FindTraces: %v", err)
+	}
+	if len(traces) != 1 || traces[0].Spans[0].TraceID != matching {
+		t.Fatalf("FindTraces = %v, want only trace %v", traces, matching)
+	}
+}
+
+func TestEvalTagNode(t *testing.T) {
+	tags := []model.KeyValue{
+		model.String("http.url", "/orders/42"),
+		model.Int64("http.status_code", 503),
+		model.Bool("error", true),
+	}
+	tests := map[string]bool{
+		"error=true":                       true,
+		"error=false":                      false,
+		"http.status_code>=500":            true,
+		"http.status_code<500":             false,
+		"http.url=/orders/*":               true,
+		"NOT peer.service=redis":           true,
+		"peer.service=redis OR error=true": true,
+		`http.status_code>"500"`:           false,
+		// 缺失的 key 满足 !=，不满足 = 和比较
+		"peer.service!=redis":     true,
+		"peer.service!=redis*":    true,
+		"peer.service=redis*":     false,
+		"retries>0":               false,
+		"NOT peer.service!=redis": false,
+	}
+	for expr, want := range tests {
+		node, err := parseTagQuery(expr)
+		if err != nil {
+			t.Fatalf("parseTagQuery(%q): %v", expr, err)
+		}
+		if got := evalTagNode(node, tags); got != want {
+			t.Errorf("evalTagNode(%q) = %v, want %v", expr, got, want)
+		}
+	}
+}
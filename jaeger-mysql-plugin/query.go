@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+// ====================
+// Trace 搜索查询构建
+// ====================
+
+var (
+	// 通配服务名：FindTraces 使用该服务名时跨所有服务搜索（Jaeger UI 要求必须选择服务）
+	allServicesName = getStringEnv("ALL_SERVICES_NAME", "*")
+	// 是否在 GetServices 结果中加入通配服务名，使其可以在 UI 下拉框中选择
+	allServicesInList = getBoolEnv("ALL_SERVICES_IN_LIST", false)
+)
+
+// traceSearchSQL 是 FindTraces / FindTraceIDs 共用的 SQL 及参数
+type traceSearchSQL struct {
+	query string
+	args  []interface{}
+}
+
+// buildTraceSearchSQL 根据 TraceQueryParameters 构建 trace ID 搜索语句
+// FindTraces 和 FindTraceIDs 都通过它生成 SQL，保证所有过滤条件的语义一致
+func buildTraceSearchSQL(query *spanstore.TraceQueryParameters) (traceSearchSQL, error) {
+	return buildTraceSearchSQLWith(query, traceSearchOptions{})
+}
+
+// traceSearchOptions 标准搜索之外的附加条件
+type traceSearchOptions struct {
+	traceIDs []string     // 非空时只在这些 trace 中搜索
+	cursor   *traceCursor // 非空时从游标之后开始（分页）
+	limit    int          // 覆盖 NumTraces
+}
+
+// buildTraceSearchSQLWith 同 buildTraceSearchSQL，并应用附加条件
+func buildTraceSearchSQLWith(query *spanstore.TraceQueryParameters, opts traceSearchOptions) (traceSearchSQL, error) {
+	traceIDs := opts.traceIDs
+	var sb strings.Builder
+	var args []interface{}
+	sb.WriteString(`
+		SELECT trace_id, MAX(start_time) as max_start_time
+		FROM jaeger_spans
+		WHERE `)
+	// 未指定服务（或使用通配服务名）时跨所有服务搜索
+	if service := searchServiceName(query); service != "" {
+		sb.WriteString("service_name = ? AND ")
+		args = append(args, service)
+	}
+	sb.WriteString("start_time >= ? AND start_time <= ?")
+	args = append(args, query.StartTimeMin.UnixNano(), query.StartTimeMax.UnixNano())
+
+	// 操作名支持精确匹配、通配符和正则（见 parseOperationFilter）
+	if query.OperationName != "" {
+		filter, err := parseOperationFilter(query.OperationName)
+		if err != nil {
+			return traceSearchSQL{}, err
+		}
+		expr, arg := filter.sql()
+		sb.WriteString(" AND " + expr)
+		args = append(args, arg)
+	}
+
+	if len(traceIDs) > 0 {
+		sb.WriteString(" AND trace_id IN (" + placeholders(len(traceIDs)) + ")")
+		for _, id := range traceIDs {
+			args = append(args, id)
+		}
+	}
+
+	// 支持 Tags 过滤：等值条件走全文搜索，比较/取反/前缀条件走 tag_attrs 属性过滤
+	// ManticoreSearch 每个查询只允许一个 MATCH()，所有全文条件合并为一个表达式
+	if len(query.Tags) > 0 {
+		filter, err := parseTagFilters(query.Tags)
+		if err != nil {
+			return traceSearchSQL{}, err
+		}
+		if !filter.match.empty() {
+			sb.WriteString(" AND MATCH(?)")
+			args = append(args, filter.match.String())
+		}
+		for _, expr := range filter.exprs {
+			sb.WriteString(" AND " + expr)
+		}
+		args = append(args, filter.args...)
+	}
+
+	// 错误状态过滤（保留 tag key，走 is_error 属性）
+	if isError, ok, err := errorFilterOf(query.Tags); err != nil {
+		return traceSearchSQL{}, err
+	} else if ok {
+		sb.WriteString(" AND is_error = ?")
+		args = append(args, boolToInt(isError))
+	}
+
+	// 支持 Duration 过滤
+	if query.DurationMin > 0 {
+		sb.WriteString(" AND duration >= ?")
+		args = append(args, query.DurationMin.Nanoseconds())
+	}
+	if query.DurationMax > 0 {
+		sb.WriteString(" AND duration <= ?")
+		args = append(args, query.DurationMax.Nanoseconds())
+	}
+
+	// trace_id 作为第二排序键，保证 start_time 相同时顺序稳定（分页依赖）
+	sb.WriteString(" GROUP BY trace_id")
+	if opts.cursor != nil {
+		sb.WriteString(" HAVING max_start_time <= ?")
+		args = append(args, opts.cursor.startTime)
+	}
+	sb.WriteString(" ORDER BY max_start_time DESC, trace_id ASC LIMIT ?")
+	limit := query.NumTraces
+	if opts.limit > 0 {
+		limit = opts.limit
+	}
+	args = append(args, limit)
+
+	return traceSearchSQL{query: sb.String(), args: args}, nil
+}
+
+// findTraceIDStrings 执行 trace 搜索，按最近 start_time 倒序返回 trace ID
+// 尚未写入数据库但满足条件的 trace 也会合并进结果
+func (r *MySQLSpanReader) findTraceIDStrings(ctx context.Context, query *spanstore.TraceQueryParameters) ([]string, error) {
+	found, err := r.findTraceHits(ctx, query, traceSearchOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return r.pendingTraceIDs(query, found)
+}
+
+// findTraceHits 在数据库中执行 trace 搜索（不含待写入的 span）
+func (r *MySQLSpanReader) findTraceHits(ctx context.Context, query *spanstore.TraceQueryParameters, opts traceSearchOptions) ([]traceHit, error) {
+	q, err := r.traceSearchSQL(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	if q.query == "" {
+		// trace 级耗时过滤没有候选 trace
+		return nil, nil
+	}
+
+	rows, err := r.db.QueryContext(ctx, q.query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []traceHit
+	for rows.Next() {
+		var hit traceHit
+		if err := rows.Scan(&hit.traceID, &hit.startTime); err != nil {
+			r.logger.Warn().Err(err).Msg("Failed to scan trace ID")
+			continue
+		}
+		found = append(found, hit)
+	}
+	return found, rows.Err()
+}
+
+// pendingTraceIDs 合并待写入 span 中满足条件的 trace，返回 trace ID 列表
+func (r *MySQLSpanReader) pendingTraceIDs(query *spanstore.TraceQueryParameters, found []traceHit) ([]string, error) {
+	if r.store.pending != nil {
+		m, err := newSpanMatcher(query)
+		if err != nil {
+			return nil, err
+		}
+		found = mergeTraceHits(found, r.store.pending.find(m), query.NumTraces)
+	}
+
+	traceIDs := make([]string, len(found))
+	for i, hit := range found {
+		traceIDs[i] = hit.traceID
+	}
+	return traceIDs, nil
+}
+
+// traceSearchSQL 构建 span 搜索语句
+// TRACE_DURATION_FILTER=trace 时，DurationMin/DurationMax 按 trace 总耗时过滤：
+// 先从 jaeger_traces 取出满足耗时条件的候选 trace，再在这些 trace 中按其余条件搜索。
+// 没有候选 trace 时返回空语句
+func (r *MySQLSpanReader) traceSearchSQL(ctx context.Context, query *spanstore.TraceQueryParameters, opts traceSearchOptions) (traceSearchSQL, error) {
+	if !useTraceDuration(query) || r.store.summaries == nil {
+		q, err := buildTraceSearchSQLWith(query, opts)
+		return q, invalidQuery(err)
+	}
+
+	summaries, err := r.FindTraceSummaries(ctx, &traceSummaryQuery{
+		ServiceName:  searchServiceName(query),
+		StartTimeMin: query.StartTimeMin,
+		StartTimeMax: query.StartTimeMax,
+		DurationMin:  query.DurationMin,
+		DurationMax:  query.DurationMax,
+		Limit:        traceSummaryCandidates,
+	})
+	if err != nil {
+		return traceSearchSQL{}, err
+	}
+	if len(summaries) == 0 {
+		return traceSearchSQL{}, nil
+	}
+	if len(summaries) == traceSummaryCandidates {
+		r.logger.Warn().Int("limit", traceSummaryCandidates).
+			Msg("Trace duration candidates hit limit, results may be incomplete")
+	}
+
+	opts.traceIDs = make([]string, len(summaries))
+	for i, t := range summaries {
+		opts.traceIDs[i] = t.TraceID
+	}
+	spanQuery := *query
+	spanQuery.DurationMin, spanQuery.DurationMax = 0, 0
+	q, err := buildTraceSearchSQLWith(&spanQuery, opts)
+	return q, invalidQuery(err)
+}
+
+// queryError 查询条件不合法（HTTP API 返回 400），区别于存储错误（500）
+type queryError struct{ err error }
+
+func (e *queryError) Error() string { return e.err.Error() }
+func (e *queryError) Unwrap() error { return e.err }
+
+// invalidQuery 将 err 标记为查询条件错误，err 为 nil 时返回 nil
+func invalidQuery(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &queryError{err: err}
+}
+
+// isInvalidQuery 判断是否为查询条件错误
+func isInvalidQuery(err error) bool {
+	var qe *queryError
+	return errors.As(err, &qe)
+}
+
+// searchServiceName 返回搜索使用的服务名，通配服务名返回空字符串（不按服务过滤）
+func searchServiceName(query *spanstore.TraceQueryParameters) string {
+	if query.ServiceName == allServicesName {
+		return ""
+	}
+	return query.ServiceName
+}
+
+// useTraceDuration 判断耗时条件是否按 trace 总耗时过滤
+func useTraceDuration(query *spanstore.TraceQueryParameters) bool {
+	return traceDurationFilter == "trace" && (query.DurationMin > 0 || query.DurationMax > 0)
+}
+
+// traceHit 搜索命中的 trace 及其最近的 start_time
+type traceHit struct {
+	traceID   string
+	startTime int64
+}
+
+// mergeTraceHits 合并数据库和待写入 span 的搜索结果，去重后按 start_time 倒序取前 limit 个
+func mergeTraceHits(stored []traceHit, pending map[string]int64, limit int) []traceHit {
+	if len(pending) == 0 {
+		return stored
+	}
+	merged := make([]traceHit, 0, len(stored)+len(pending))
+	for _, hit := range stored {
+		if ts, ok := pending[hit.traceID]; ok {
+			if ts > hit.startTime {
+				hit.startTime = ts
+			}
+			delete(pending, hit.traceID)
+		}
+		merged = append(merged, hit)
+	}
+	for traceID, ts := range pending {
+		merged = append(merged, traceHit{traceID: traceID, startTime: ts})
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].startTime > merged[j].startTime
+	})
+	if limit > 0 && len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged
+}
+
+// ====================
+// ManticoreSearch 全文查询构建
+// ====================
+
+// matchSpecialChars 是 ManticoreSearch 全文查询语法中的操作符字符，
+// 出现在用户输入中时必须用反斜杠转义，否则会破坏查询或改变语义
+const matchSpecialChars = `\()|-!@~"&/^$=<>*?%`
+
+// escapeMatch 转义全文查询中的操作符字符
+func escapeMatch(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s) + 8)
+	for _, r := range s {
+		if strings.ContainsRune(matchSpecialChars, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// isSingleToken 判断 s 是否会被分词为单个关键字（仅包含 ASCII 字母、数字和下划线）
+func isSingleToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// matchTerm 将任意文本转换为安全的全文查询项：
+// 单个关键字直接使用，多关键字（含分隔符、空格、中文等）转换为短语查询
+func matchTerm(s string) string {
+	if isSingleToken(s) {
+		return s
+	}
+	return `"` + escapeMatch(s) + `"`
+}
+
+// matchPhrase 将多个片段组成一个短语查询，片段内的操作符字符会被转义
+func matchPhrase(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, p := range parts {
+		escaped[i] = escapeMatch(p)
+	}
+	return `"` + strings.Join(escaped, " ") + `"`
+}
+
+// matchQuery 构建限定在指定字段内的 MATCH() 表达式
+type matchQuery struct {
+	field string
+	terms []string
+}
+
+// newMatchQuery 创建限定在 field 字段的全文查询
+func newMatchQuery(field string) *matchQuery {
+	return &matchQuery{field: field}
+}
+
+// addRaw 添加一个已构建好的查询片段（多个片段之间为 AND）
+func (m *matchQuery) addRaw(term string) *matchQuery {
+	m.terms = append(m.terms, term)
+	return m
+}
+
+// empty 是否没有任何查询条件
+func (m *matchQuery) empty() bool {
+	return len(m.terms) == 0
+}
+
+// String 生成最终的 MATCH() 参数，例如：@tag_kv "http.method GET" ("error true" | "level error")
+func (m *matchQuery) String() string {
+	if m.empty() {
+		return ""
+	}
+	return "@" + m.field + " " + strings.Join(m.terms, " ")
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func testTraceQuery() *spanstore.TraceQueryParameters {
+	return &spanstore.TraceQueryParameters{
+		ServiceName:   "order-service",
+		OperationName: "GET /orders",
+		Tags:          map[string]string{"http.method": "GET", "error": "true"},
+		StartTimeMin:  time.Unix(100, 0),
+		StartTimeMax:  time.Unix(200, 0),
+		DurationMin:   10 * time.Millisecond,
+		DurationMax:   2 * time.Second,
+		NumTraces:     20,
+	}
+}
+
+func TestBuildTraceSearchSQLAppliesAllFilters(t *testing.T) {
+	q, err := buildTraceSearchSQL(testTraceQuery())
+	if err != nil {
+		t.Fatalf("buildTraceSearchSQL: %v", err)
+	}
+
+	for _, want := range []string{
+		"service_name = ?",
+		"start_time >= ?",
+		"start_time <= ?",
+		"operation_name = ?",
+		"MATCH(?)",
+		"duration >= ?",
+		"duration <= ?",
+		"LIMIT ?",
+	} {
+		if !strings.Contains(q.query, want) {
+			t.Errorf("query missing %q:\n%s", want, q.query)
+		}
+	}
+
+	wantArgs := []interface{}{
+		"order-service",
+		time.Unix(100, 0).UnixNano(),
+		time.Unix(200, 0).UnixNano(),
+		"GET /orders",
+		`@tag_kv "error true" "http.method GET"`,
+		(10 * time.Millisecond).Nanoseconds(),
+		(2 * time.Second).Nanoseconds(),
+		20,
+	}
+	if !reflect.DeepEqual(q.args, wantArgs) {
+		t.Errorf("args = %v, want %v", q.args, wantArgs)
+	}
+}
+
+func TestBuildTraceSearchSQLOptionalFilters(t *testing.T) {
+	q, err := buildTraceSearchSQL(&spanstore.TraceQueryParameters{
+		ServiceName:  "svc",
+		StartTimeMin: time.Unix(1, 0),
+		StartTimeMax: time.Unix(2, 0),
+		NumTraces:    5,
+	})
+	if err != nil {
+		t.Fatalf("buildTraceSearchSQL: %v", err)
+	}
+
+	for _, unwanted := range []string{"operation_name", "MATCH", "duration"} {
+		if strings.Contains(q.query, unwanted) {
+			t.Errorf("query should not contain %q:\n%s", unwanted, q.query)
+		}
+	}
+	if len(q.args) != 4 {
+		t.Errorf("args = %v, want 4 values", q.args)
+	}
+}
+
+func TestFindTracesAndFindTraceIDsShareQuery(t *testing.T) {
+	traceID := model.NewTraceID(0, 42)
+	columns := []string{"trace_id", "max_start_time"}
+
+	f, reader := newTestReader(t)
+	f.on("max_start_time", columns, []interface{}{traceID.String(), int64(150)})
+	f.on("trace_id IN", spanColumns, spanRow(&model.Span{
+		TraceID:       traceID,
+		SpanID:        model.NewSpanID(1),
+		OperationName: "GET /orders",
+		StartTime:     time.Unix(150, 0),
+		Process:       &model.Process{ServiceName: "order-service"},
+	}))
+
+	ids, err := reader.FindTraceIDs(context.Background(), testTraceQuery())
+	if err != nil {
+		t.Fatalf("FindTraceIDs: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != traceID {
+		t.Fatalf("FindTraceIDs = %v, want [%v]", ids, traceID)
+	}
+
+	traces, err := reader.FindTraces(context.Background(), testTraceQuery())
+	if err != nil {
+		t.Fatalf("FindTraces: %v", err)
+	}
+	if len(traces) != 1 || traces[0].Spans[0].TraceID != traceID {
+		t.Fatalf("FindTraces = %v, want trace %v", traces, traceID)
+	}
+
+	searches := f.matching("max_start_time")
+	if len(searches) != 2 {
+		t.Fatalf("expected 2 search queries, got %d", len(searches))
+	}
+	if searches[0].query != searches[1].query {
+		t.Errorf("queries differ:\nFindTraceIDs: %s\nFindTraces:   %s", searches[0].query, searches[1].query)
+	}
+	if !reflect.DeepEqual(searches[0].args, searches[1].args) {
+		t.Errorf("args differ:\nFindTraceIDs: %v\nFindTraces:   %v", searches[0].args, searches[1].args)
+	}
+}
+
+func TestEscapeMatch(t *testing.T) {
+	tests := map[string]string{
+		"plain":      "plain",
+		"a-b":        `a\-b`,
+		`"quoted"`:   `\"quoted\"`,
+		"/path":      `\/path`,
+		"@field":     `\@field`,
+		"a|b":        `a\|b`,
+		`back\slash`: `back\\slash`,
+		"中文":         "中文",
+	}
+	for in, want := range tests {
+		if got := escapeMatch(in); got != want {
+			t.Errorf("escapeMatch(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMatchTerm(t *testing.T) {
+	tests := map[string]string{
+		"GET":         "GET",
+		"user_id":     "user_id",
+		"a-b":         `"a\-b"`,
+		"/api/orders": `"\/api\/orders"`,
+		"hello world": `"hello world"`,
+		"订单":          `"订单"`,
+	}
+	for in, want := range tests {
+		if got := matchTerm(in); got != want {
+			t.Errorf("matchTerm(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestBuildTraceSearchSQLTagQuery(t *testing.T) {
+	query := testTraceQuery()
+	query.Tags = map[string]string{
+		"query":         "http.status_code>=500 OR error=true",
+		"http.method":   "GET",
+		"peer.service!": "redis",
+		"component":     "",
+	}
+
+	q, err := buildTraceSearchSQL(query)
+	if err != nil {
+		t.Fatalf("buildTraceSearchSQL: %v", err)
+	}
+
+	for _, want := range []string{
+		"MATCH(?)",
+		"AND ((tag_attrs[?] >= ?) OR (tag_attrs[?] = ?))",
+		"AND (tag_attrs[?] != ?)",
+	} {
+		if !strings.Contains(q.query, want) {
+			t.Errorf("query missing %q:\n%s", want, q.query)
+		}
+	}
+
+	wantArgs := []interface{}{
+		`@tag_kv component "http.method GET"`,
+		"peer.service", "redis",
+		"http.status_code", int64(500), "error", "true",
+	}
+	got := q.args[4 : 4+len(wantArgs)]
+	if !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("tag args = %#v, want %#v", got, wantArgs)
+	}
+}
+
+func TestBuildTraceSearchSQLInvalidTagQuery(t *testing.T) {
+	query := testTraceQuery()
+	query.Tags = map[string]string{"query": "error=true OR"}
+
+	if _, err := buildTraceSearchSQL(query); err == nil {
+		t.Fatal("expected error for invalid tag query")
+	}
+}
+
+func TestBuildTraceSearchSQLAllServices(t *testing.T) {
+	for _, service := range []string{"", allServicesName} {
+		q, err := buildTraceSearchSQL(&spanstore.TraceQueryParameters{
+			ServiceName:  service,
+			StartTimeMin: time.Unix(100, 0),
+			StartTimeMax: time.Unix(200, 0),
+			Tags:         map[string]string{"order.id": "A1001"},
+			NumTraces:    20,
+		})
+		if err != nil {
+			t.Fatalf("buildTraceSearchSQL(%q): %v", service, err)
+		}
+		if strings.Contains(q.query, "service_name") {
+			t.Errorf("service %q: query should not filter service:\n%s", service, q.query)
+		}
+		wantArgs := []interface{}{
+			time.Unix(100, 0).UnixNano(),
+			time.Unix(200, 0).UnixNano(),
+			`@tag_kv "order.id A1001"`,
+			20,
+		}
+		if !reflect.DeepEqual(q.args, wantArgs) {
+			t.Errorf("service %q: args = %v, want %v", service, q.args, wantArgs)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+// ====================
+// 分页搜索
+// ====================
+//
+// FindTraces 只返回前 NumTraces 个结果。扩展搜索按 (max_start_time DESC, trace_id ASC)
+// 排序，并返回指向本页最后一个 trace 的游标，下一页用相同的查询条件加上游标请求。
+//
+// ManticoreSearch 的 HAVING 只适合简单条件，因此游标条件分两步实现：
+// SQL 中用 HAVING max_start_time <= 游标时间，start_time 相同、已返回过的 trace
+// 排在最前面，游标记录它们的个数（skip），多取 skip 行后在内存中过滤。
+// 分页只基于已写入数据库的数据，不合并待写入的 span。
+
+const (
+	// defaultSearchPageSize 未指定 limit 时的每页大小
+	defaultSearchPageSize = 20
+	// maxSearchPageSize 每页最大 trace 数
+	maxSearchPageSize = 1000
+)
+
+// traceCursor 分页游标：上一页最后一个 trace 的位置
+type traceCursor struct {
+	startTime int64  // 上一页最后一个 trace 的 max_start_time
+	traceID   string // 上一页最后一个 trace 的 trace_id
+	skip      int    // 已返回的 start_time 等于 startTime 的 trace 数
+}
+
+// encode 编码为 URL 安全的不透明字符串
+func (c *traceCursor) encode() string {
+	raw := fmt.Sprintf("%d:%d:%s", c.startTime, c.skip, c.traceID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeTraceCursor 解析游标，空字符串返回 nil
+func decodeTraceCursor(s string) (*traceCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	errInvalid := fmt.Errorf("invalid cursor %q", s)
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalid
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return nil, errInvalid
+	}
+	startTime, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errInvalid
+	}
+	skip, err := strconv.Atoi(parts[1])
+	if err != nil || skip < 1 {
+		return nil, errInvalid
+	}
+	return &traceCursor{startTime: startTime, traceID: parts[2], skip: skip}, nil
+}
+
+// after 判断 hit 是否排在游标之后
+func (c *traceCursor) after(hit traceHit) bool {
+	if hit.startTime != c.startTime {
+		return hit.startTime < c.startTime
+	}
+	return hit.traceID > c.traceID
+}
+
+// traceSearchHit 搜索结果中的一个 trace
+type traceSearchHit struct {
+	TraceID   string `json:"traceID"`
+	StartTime int64  `json:"startTime"` // 纳秒，trace 中满足条件的 span 的最大 start_time
+}
+
+// traceSearchPage 一页搜索结果，NextCursor 为空表示没有更多结果
+type traceSearchPage struct {
+	Traces     []traceSearchHit `json:"traces"`
+	NextCursor string           `json:"nextCursor,omitempty"`
+}
+
+// SearchTraces 分页搜索 trace，每页 query.NumTraces 个
+func (r *MySQLSpanReader) SearchTraces(ctx context.Context, query *spanstore.TraceQueryParameters, cursor string) (*traceSearchPage, error) {
+	c, err := decodeTraceCursor(cursor)
+	if err != nil {
+		return nil, invalidQuery(err)
+	}
+
+	limit := query.NumTraces
+	if limit <= 0 {
+		limit = defaultSearchPageSize
+	}
+	if limit > maxSearchPageSize {
+		return nil, invalidQuery(fmt.Errorf("limit %d exceeds maximum %d", limit, maxSearchPageSize))
+	}
+
+	// 多取一行判断是否还有下一页；有游标时再多取已返回过的并列行
+	opts := traceSearchOptions{cursor: c, limit: limit + 1}
+	if c != nil {
+		opts.limit += c.skip
+	}
+	hits, err := r.findTraceHits(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if c != nil {
+		filtered := hits[:0]
+		for _, hit := range hits {
+			if c.after(hit) {
+				filtered = append(filtered, hit)
+			}
+		}
+		hits = filtered
+	}
+
+	page := &traceSearchPage{Traces: make([]traceSearchHit, 0, limit)}
+	hasMore := len(hits) > limit
+	if hasMore {
+		hits = hits[:limit]
+	}
+	for _, hit := range hits {
+		page.Traces = append(page.Traces, traceSearchHit{TraceID: hit.traceID, StartTime: hit.startTime})
+	}
+	if hasMore {
+		page.NextCursor = nextTraceCursor(c, hits).encode()
+	}
+	return page, nil
+}
+
+// nextTraceCursor 根据本页结果计算下一页游标，hits 不能为空
+func nextTraceCursor(prev *traceCursor, hits []traceHit) *traceCursor {
+	last := hits[len(hits)-1]
+	next := &traceCursor{startTime: last.startTime, traceID: last.traceID}
+	for _, hit := range hits {
+		if hit.startTime == last.startTime {
+			next.skip++
+		}
+	}
+	if prev != nil && prev.startTime == last.startTime {
+		next.skip += prev.skip
+	}
+	return next
+}
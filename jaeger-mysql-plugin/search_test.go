@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+var traceHitColumns = []string{"trace_id", "max_start_time"}
+
+func searchQuery(limit int) *spanstore.TraceQueryParameters {
+	return &spanstore.TraceQueryParameters{
+		ServiceName:  "order",
+		StartTimeMin: time.Unix(0, 0),
+		StartTimeMax: time.Unix(1000, 0),
+		NumTraces:    limit,
+	}
+}
+
+func pageIDs(page *traceSearchPage) []string {
+	ids := make([]string, len(page.Traces))
+	for i, hit := range page.Traces {
+		ids[i] = hit.TraceID
+	}
+	return ids
+}
+
+func TestTraceCursorRoundTrip(t *testing.T) {
+	c := &traceCursor{startTime: 1234567890, traceID: "abc123", skip: 3}
+	got, err := decodeTraceCursor(c.encode())
+	if err != nil {
+		t.Fatalf("decodeTraceCursor: %v", err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("got %+v, want %+v", got, c)
+	}
+
+	for _, bad := range []string{"!!", "bm9wZQ", (&traceCursor{startTime: 1, traceID: "a"}).encode()} {
+		if _, err := decodeTraceCursor(bad); err == nil {
+			t.Errorf("decodeTraceCursor(%q): expected error", bad)
+		}
+	}
+}
+
+func TestSearchTracesFirstPage(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("FROM jaeger_spans", traceHitColumns,
+		[]interface{}{"a", int64(300)},
+		[]interface{}{"b", int64(200)},
+		[]interface{}{"c", int64(200)},
+	)
+
+	page, err := reader.SearchTraces(context.Background(), searchQuery(2), "")
+	if err != nil {
+		t.Fatalf("SearchTraces: %v", err)
+	}
+	if got := pageIDs(page); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("page = %v", got)
+	}
+	next, err := decodeTraceCursor(page.NextCursor)
+	if err != nil || next == nil {
+		t.Fatalf("next cursor %q: %v", page.NextCursor, err)
+	}
+	if *next != (traceCursor{startTime: 200, traceID: "b", skip: 1}) {
+		t.Errorf("next = %+v", next)
+	}
+
+	q := f.matching("FROM jaeger_spans")[0]
+	if strings.Contains(q.query, "HAVING") {
+		t.Errorf("first page should not use HAVING:\n%s", q.query)
+	}
+	if got := q.args[len(q.args)-1]; got != 3 {
+		t.Errorf("limit = %v, want 3", got)
+	}
+}
+
+func TestSearchTracesNextPageSkipsTies(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("HAVING", traceHitColumns,
+		[]interface{}{"b", int64(200)},
+		[]interface{}{"c", int64(200)},
+		[]interface{}{"d", int64(200)},
+		[]interface{}{"e", int64(100)},
+	)
+
+	cursor := (&traceCursor{startTime: 200, traceID: "b", skip: 1}).encode()
+	page, err := reader.SearchTraces(context.Background(), searchQuery(2), cursor)
+	if err != nil {
+		t.Fatalf("SearchTraces: %v", err)
+	}
+	if got := pageIDs(page); !reflect.DeepEqual(got, []string{"c", "d"}) {
+		t.Errorf("page = %v", got)
+	}
+	next, _ := decodeTraceCursor(page.NextCursor)
+	if next == nil || *next != (traceCursor{startTime: 200, traceID: "d", skip: 3}) {
+		t.Errorf("next = %+v", next)
+	}
+
+	q := f.matching("HAVING")[0]
+	if !strings.Contains(q.query, "HAVING max_start_time <= ?") {
+		t.Errorf("query:\n%s", q.query)
+	}
+	if got := q.args[len(q.args)-1]; got != 4 {
+		t.Errorf("limit = %v, want 4", got)
+	}
+}
+
+func TestSearchTracesLastPage(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("HAVING", traceHitColumns,
+		[]interface{}{"b", int64(200)},
+		[]interface{}{"c", int64(200)},
+		[]interface{}{"d", int64(200)},
+		[]interface{}{"e", int64(100)},
+	)
+
+	cursor := (&traceCursor{startTime: 200, traceID: "d", skip: 3}).encode()
+	page, err := reader.SearchTraces(context.Background(), searchQuery(2), cursor)
+	if err != nil {
+		t.Fatalf("SearchTraces: %v", err)
+	}
+	if got := pageIDs(page); !reflect.DeepEqual(got, []string{"e"}) {
+		t.Errorf("page = %v", got)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("expected no next cursor, got %q", page.NextCursor)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// 写入时聚合的 span 指标
+// ====================
+//
+// 查询时扫描 jaeger_spans 计算 RED 指标（见 spm.go）代价较高。批量写入时顺便按
+// (服务, 操作, span kind, 状态) 累加调用次数和耗时直方图：
+//   - /metrics 以 Prometheus 文本格式输出累计值，指标名和标签与 OpenTelemetry
+//     spanmetrics connector 一致（calls_total、duration_milliseconds），
+//     Prometheus 抓取后可直接用于 Jaeger Monitor 页面、看板和告警
+//   - SPAN_METRICS_ROLLUP=true 时，每 SPAN_METRICS_ROLLUP_INTERVAL 将增量写入
+//     jaeger_span_metrics（按 span 开始时间分桶），METRICS_USE_ROLLUPS=true 时
+//     RED 指标查询改为读取该表
+//
+// 计数只包含本进程写入的 span，重启后从 0 开始（Prometheus counter 语义）。
+// 多个插件实例各自插入汇总行，读取时求和即可。
+
+var (
+	// 是否在写入时聚合 span 指标
+	spanMetricsEnabled = getBoolEnv("SPAN_METRICS_ENABLED", true)
+	// 内存中最多的时间序列数（含 __other__ 序列，硬上限），超出后新操作归入 __other__
+	spanMetricsMaxSeries = getIntEnv("SPAN_METRICS_MAX_SERIES", 10000)
+	// 是否将聚合结果写入 jaeger_span_metrics
+	spanMetricsRollup = getBoolEnv("SPAN_METRICS_ROLLUP", false)
+	// 汇总时间桶大小，也是写入间隔
+	spanMetricsRollupInterval = getDurationEnv("SPAN_METRICS_ROLLUP_INTERVAL", time.Minute)
+)
+
+// spanSeriesKey 一条时间序列
+type spanSeriesKey struct {
+	service   string
+	operation string
+	kind      string
+	isError   bool
+}
+
+// spanOverflowSeries 为全局 __other__ 序列预留的名额（按状态区分，共两条）
+// 序列数达到 maxSeries-spanOverflowSeries 后不再创建普通序列，保证总数不超过 maxSeries
+const spanOverflowSeries = 2
+
+// spanSeries 一条时间序列的累计值
+type spanSeries struct {
+	calls   uint64
+	buckets []uint64 // 按 spanLatencyBuckets 分桶的计数（非累计），最后一个为 +Inf
+	sum     time.Duration
+}
+
+// spanRollupKey 一行汇总数据
+type spanRollupKey struct {
+	bucket    int64 // 桶开始时间（纳秒）
+	service   string
+	operation string
+	kind      string
+	le        int // spanLatencyBuckets 的下标，len(spanLatencyBuckets) 表示 +Inf
+}
+
+// spanRollup 一行汇总数据的增量
+type spanRollup struct {
+	calls, errors int64
+	durationSum   int64 // 纳秒
+}
+
+// spanMetrics 写入端的指标聚合
+type spanMetrics struct {
+	maxSeries int
+
+	mu      sync.Mutex
+	series  map[spanSeriesKey]*spanSeries
+	rollups map[spanRollupKey]*spanRollup // nil 表示不写入汇总表
+}
+
+// newSpanMetrics 按配置创建指标聚合，未启用时返回 nil
+func newSpanMetrics() *spanMetrics {
+	if !spanMetricsEnabled {
+		return nil
+	}
+	maxSeries := spanMetricsMaxSeries
+	if maxSeries > 0 && maxSeries < spanOverflowSeries {
+		maxSeries = spanOverflowSeries
+	}
+	m := &spanMetrics{
+		maxSeries: maxSeries,
+		series:    make(map[spanSeriesKey]*spanSeries),
+	}
+	if spanMetricsRollup {
+		m.rollups = make(map[spanRollupKey]*spanRollup)
+	}
+	return m
+}
+
+// latencyBucketIndex 返回耗时所在的 spanLatencyBuckets 下标（上界包含在桶内）
+func latencyBucketIndex(d time.Duration) int {
+	return sort.Search(len(spanLatencyBuckets), func(i int) bool { return d <= spanLatencyBuckets[i] })
+}
+
+// record 累加一批已写入的 span
+func (m *spanMetrics) record(spans []*model.Span) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, span := range spans {
+		key := spanSeriesKey{
+			service:   span.Process.ServiceName,
+			operation: span.OperationName,
+			kind:      spanKindOf(span.Tags),
+			isError:   spanIsError(span.Tags),
+		}
+		le := latencyBucketIndex(span.Duration)
+
+		series, ok := m.series[key]
+		if !ok && m.maxSeries > 0 && len(m.series) >= m.maxSeries-spanOverflowSeries {
+			key = m.overflowKey(key)
+			series, ok = m.series[key]
+		}
+		if !ok {
+			series = &spanSeries{buckets: make([]uint64, len(spanLatencyBuckets)+1)}
+			m.series[key] = series
+		}
+		series.calls++
+		series.buckets[le]++
+		series.sum += span.Duration
+
+		if m.rollups == nil {
+			continue
+		}
+		rk := spanRollupKey{
+			bucket:    span.StartTime.Truncate(spanMetricsRollupInterval).UnixNano(),
+			service:   key.service,
+			operation: key.operation,
+			kind:      key.kind,
+			le:        le,
+		}
+		r, ok := m.rollups[rk]
+		if !ok {
+			r = &spanRollup{}
+			m.rollups[rk] = r
+		}
+		r.calls++
+		if key.isError {
+			r.errors++
+		}
+		r.durationSum += span.Duration.Nanoseconds()
+	}
+}
+
+// overflowKey 返回序列数达到上限后 span 归入的序列：同一 (服务, span kind, 状态)
+// 已有 __other__ 序列时沿用，否则归入服务和操作均为 __other__ 的全局序列
+// 调用方需持有 m.mu
+func (m *spanMetrics) overflowKey(key spanSeriesKey) spanSeriesKey {
+	key.operation = otherOperation
+	if _, ok := m.series[key]; ok {
+		return key
+	}
+	return spanSeriesKey{service: otherOperation, operation: otherOperation, isError: key.isError}
+}
+
+// takeRollups 取出并清空待写入的汇总增量
+func (m *spanMetrics) takeRollups() map[spanRollupKey]*spanRollup {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rollups := m.rollups
+	m.rollups = make(map[spanRollupKey]*spanRollup)
+	return rollups
+}
+
+// restoreRollups 写入失败时放回增量，下次重试
+func (m *spanMetrics) restoreRollups(rollups map[spanRollupKey]*spanRollup) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, r := range rollups {
+		cur, ok := m.rollups[key]
+		if !ok {
+			m.rollups[key] = r
+			continue
+		}
+		cur.calls += r.calls
+		cur.errors += r.errors
+		cur.durationSum += r.durationSum
+	}
+}
+
+// ====================
+// Prometheus 输出
+// ====================
+
+// otelSpanKind 将 span_kind 转换为 spanmetrics connector 的标签值
+func otelSpanKind(kind string) string {
+	if kind == "" {
+		return "SPAN_KIND_UNSPECIFIED"
+	}
+	return "SPAN_KIND_" + strings.ToUpper(kind)
+}
+
+// otelStatusCode 返回 spanmetrics connector 的状态标签值
+func otelStatusCode(isError bool) string {
+	if isError {
+		return "STATUS_CODE_ERROR"
+	}
+	return "STATUS_CODE_UNSET"
+}
+
+// promLabelEscaper 转义 Prometheus 标签值
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels 格式化序列的标签（不含大括号）
+func (k spanSeriesKey) promLabels() string {
+	return fmt.Sprintf(`service_name="%s",span_name="%s",span_kind="%s",status_code="%s"`,
+		promLabelEscaper.Replace(k.service), promLabelEscaper.Replace(k.operation),
+		otelSpanKind(k.kind), otelStatusCode(k.isError))
+}
+
+// formatMillis 将时长格式化为毫秒数
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
+
+// writePrometheus 以 Prometheus 文本格式输出所有序列
+func (m *spanMetrics) writePrometheus(w io.Writer) error {
+	type entry struct {
+		labels string
+		series spanSeries
+	}
+
+	m.mu.Lock()
+	entries := make([]entry, 0, len(m.series))
+	for key, s := range m.series {
+		entries = append(entries, entry{
+			labels: key.promLabels(),
+			series: spanSeries{calls: s.calls, sum: s.sum, buckets: append([]uint64(nil), s.buckets...)},
+		})
+	}
+	m.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].labels < entries[j].labels })
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# HELP calls_total Number of spans written, by service, operation, kind and status.")
+	fmt.Fprintln(bw, "# TYPE calls_total counter")
+	for _, e := range entries {
+		fmt.Fprintf(bw, "calls_total{%s} %d\n", e.labels, e.series.calls)
+	}
+
+	fmt.Fprintln(bw, "# HELP duration_milliseconds Duration of spans written, in milliseconds.")
+	fmt.Fprintln(bw, "# TYPE duration_milliseconds histogram")
+	for _, e := range entries {
+		var cumulative uint64
+		for i, bound := range spanLatencyBuckets {
+			cumulative += e.series.buckets[i]
+			fmt.Fprintf(bw, "duration_milliseconds_bucket{%s,le=\"%s\"} %d\n", e.labels, formatMillis(bound), cumulative)
+		}
+		fmt.Fprintf(bw, "duration_milliseconds_bucket{%s,le=\"+Inf\"} %d\n", e.labels, e.series.calls)
+		fmt.Fprintf(bw, "duration_milliseconds_sum{%s} %s\n", e.labels, formatMillis(e.series.sum))
+		fmt.Fprintf(bw, "duration_milliseconds_count{%s} %d\n", e.labels, e.series.calls)
+	}
+	return bw.Flush()
+}
+
+// ====================
+// 汇总写入
+// ====================
+
+// spanMetricsRollupLoop 定期将汇总增量写入 jaeger_span_metrics
+func (s *MySQLStore) spanMetricsRollupLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(spanMetricsRollupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.flushSpanMetricsRollups(context.Background()); err != nil {
+				s.logger.Warn().Err(err).Msg("Failed to write span metrics rollups")
+			}
+		case <-s.stopCh:
+			// 最后一次写入由 Close 在批量写入循环退出后执行
+			return
+		}
+	}
+}
+
+// flushSpanMetricsRollups 写入汇总增量，失败时放回下次重试
+func (s *MySQLStore) flushSpanMetricsRollups(ctx context.Context) error {
+	rollups := s.spanMetrics.takeRollups()
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO jaeger_span_metrics (bucket_start, service_name, operation_name, span_kind, le, calls, errors, duration_sum) VALUES ")
+	args := make([]interface{}, 0, len(rollups)*8)
+	i := 0
+	for key, r := range rollups {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, key.bucket, key.service, key.operation, key.kind, key.le, r.calls, r.errors, r.durationSum)
+		i++
+	}
+	if _, err := s.db.ExecContext(ctx, sb.String(), args...); err != nil {
+		s.spanMetrics.restoreRollups(rollups)
+		return fmt.Errorf("insert span metrics rollups failed: %w", err)
+	}
+	return nil
+}
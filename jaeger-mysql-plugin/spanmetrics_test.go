@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// metricsSpan 构造用于指标统计的 span
+func metricsSpan(service, operation, kind string, d time.Duration, isError bool) *model.Span {
+	tags := []model.KeyValue{model.String("span.kind", kind)}
+	if isError {
+		tags = append(tags, model.Bool("error", true))
+	}
+	return &model.Span{
+		OperationName: operation,
+		StartTime:     time.Unix(90, 0),
+		Duration:      d,
+		Tags:          tags,
+		Process:       &model.Process{ServiceName: service},
+	}
+}
+
+func TestSpanMetricsPrometheus(t *testing.T) {
+	m := &spanMetrics{series: make(map[spanSeriesKey]*spanSeries)}
+	m.record([]*model.Span{
+		metricsSpan("order", "GET /orders", "server", 3*time.Millisecond, false),
+		metricsSpan("order", "GET /orders", "server", 20*time.Second, false),
+		metricsSpan("order", "GET /orders", "server", time.Millisecond, true),
+	})
+
+	var sb strings.Builder
+	if err := m.writePrometheus(&sb); err != nil {
+		t.Fatalf("writePrometheus: %v", err)
+	}
+	out := sb.String()
+	labels := `service_name="order",span_name="GET /orders",span_kind="SPAN_KIND_SERVER",status_code="STATUS_CODE_UNSET"`
+	for _, want := range []string{
+		"# TYPE calls_total counter",
+		"calls_total{" + labels + "} 2",
+		`calls_total{service_name="order",span_name="GET /orders",span_kind="SPAN_KIND_SERVER",status_code="STATUS_CODE_ERROR"} 1`,
+		"duration_milliseconds_bucket{" + labels + `,le="2"} 0`,
+		"duration_milliseconds_bucket{" + labels + `,le="4"} 1`,
+		"duration_milliseconds_bucket{" + labels + `,le="15000"} 1`,
+		"duration_milliseconds_bucket{" + labels + `,le="+Inf"} 2`,
+		"duration_milliseconds_sum{" + labels + "} 20003",
+		"duration_milliseconds_count{" + labels + "} 2",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSpanMetricsSeriesLimit(t *testing.T) {
+	m := &spanMetrics{maxSeries: 4, series: make(map[spanSeriesKey]*spanSeries)}
+	m.record([]*model.Span{
+		metricsSpan("order", "a", "server", time.Millisecond, false),
+		metricsSpan("order", otherOperation, "server", time.Millisecond, false),
+		// 以下超出上限
+		metricsSpan("order", "b", "server", time.Millisecond, false),
+		metricsSpan("order", "c", "client", time.Millisecond, false),
+		metricsSpan("payment", "d", "server", time.Millisecond, true),
+		metricsSpan("stock", "e", "consumer", time.Millisecond, true),
+		metricsSpan("stock", "f", "producer", time.Millisecond, false),
+	})
+	if len(m.series) > m.maxSeries {
+		t.Fatalf("%d series exceed the limit of %d: %v", len(m.series), m.maxSeries, m.series)
+	}
+
+	// 同一 (服务, kind, 状态) 已有 __other__ 序列时沿用
+	if other := m.series[spanSeriesKey{service: "order", operation: otherOperation, kind: "server"}]; other == nil || other.calls != 2 {
+		t.Errorf("order __other__ series = %+v, want 2 calls", other)
+	}
+	// 其余归入全局 __other__ 序列，按状态区分
+	for isError, want := range map[bool]uint64{false: 2, true: 2} {
+		got := m.series[spanSeriesKey{service: otherOperation, operation: otherOperation, isError: isError}]
+		if got == nil || got.calls != want {
+			t.Errorf("global __other__ series (error=%v) = %+v, want %d calls", isError, got, want)
+		}
+	}
+}
+
+func TestFlushSpanMetricsRollups(t *testing.T) {
+	f, reader := newTestReader(t)
+	store := reader.store
+	store.spanMetrics = &spanMetrics{
+		series:  make(map[spanSeriesKey]*spanSeries),
+		rollups: make(map[spanRollupKey]*spanRollup),
+	}
+	store.spanMetrics.record([]*model.Span{
+		metricsSpan("order", "GET /orders", "server", 3*time.Millisecond, false),
+		metricsSpan("order", "GET /orders", "server", 3*time.Millisecond, true),
+	})
+
+	if err := store.flushSpanMetricsRollups(context.Background()); err != nil {
+		t.Fatalf("flushSpanMetricsRollups: %v", err)
+	}
+	inserts := f.matching("INSERT INTO jaeger_span_metrics")
+	if len(inserts) != 1 {
+		t.Fatalf("expected 1 insert, got %d", len(inserts))
+	}
+	want := []interface{}{time.Unix(60, 0).UnixNano(), "order", "GET /orders", "server", 1, int64(2), int64(1), (6 * time.Millisecond).Nanoseconds()}
+	for i, v := range want {
+		if inserts[0].args[i] != v {
+			t.Errorf("arg %d = %v (%T), want %v (%T)", i, inserts[0].args[i], inserts[0].args[i], v, v)
+		}
+	}
+
+	// 没有新增量时不写入；写入失败时保留增量
+	if err := store.flushSpanMetricsRollups(context.Background()); err != nil {
+		t.Fatalf("flushSpanMetricsRollups: %v", err)
+	}
+	f.onError("INSERT INTO jaeger_span_metrics", context.DeadlineExceeded)
+	store.spanMetrics.record([]*model.Span{metricsSpan("order", "GET /orders", "server", time.Millisecond, false)})
+	if err := store.flushSpanMetricsRollups(context.Background()); err == nil {
+		t.Fatal("expected insert error")
+	}
+	if len(store.spanMetrics.rollups) != 1 {
+		t.Errorf("rollups not restored after failure: %v", store.spanMetrics.rollups)
+	}
+}
+
+func TestREDSourceForRollups(t *testing.T) {
+	defer func(v bool) { metricsUseRollups = v }(metricsUseRollups)
+	metricsUseRollups = true
+	if src := redSourceFor(5 * time.Minute); src.table != "jaeger_span_metrics" {
+		t.Errorf("5m granularity should read rollups, got %s", src.table)
+	}
+	if src := redSourceFor(5 * time.Second); src.table != "jaeger_spans" {
+		t.Errorf("5s granularity should read spans, got %s", src.table)
+	}
+}
+
+func TestPrometheusEndpoint(t *testing.T) {
+	_, reader := newTestReader(t)
+	reader.store.spanMetrics.record([]*model.Span{metricsSpan("order", "GET /orders", "server", time.Millisecond, false)})
+
+	rec := httptest.NewRecorder()
+	newAPIServer(reader.store).handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "calls_total{") {
+		t.Errorf("status %d, body:\n%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPrometheusEndpointCacheStats(t *testing.T) {
+	_, reader := newTestReader(t)
+	reader.store.servicesCache.Get("services")
+	reader.store.servicesCache.Put("services", []string{"order"})
+	reader.store.servicesCache.Get("services")
+	reader.store.spanMetrics = nil
+
+	rec := httptest.NewRecorder()
+	newAPIServer(reader.store).handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`jaeger_storage_cache_hits_total{cache="services"} 1`,
+		`jaeger_storage_cache_misses_total{cache="services"} 1`,
+		`jaeger_storage_cache_entries{cache="services"} 1`,
+		"# TYPE jaeger_storage_cache_evictions_total counter",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q, status %d, body:\n%s", want, rec.Code, body)
+		}
+	}
+	if strings.Contains(body, "calls_total{") {
+		t.Error("span metrics should be omitted when disabled")
+	}
+}
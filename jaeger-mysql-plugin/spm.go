@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/jaegertracing/jaeger/proto-gen/api_v2/metrics"
+	"github.com/jaegertracing/jaeger/storage/metricsstore"
+	"github.com/rs/zerolog"
+)
+
+// ====================
+// Service Performance Monitoring（RED 指标）
+// ====================
+//
+// Jaeger 的 Monitor 页面需要 metrics 后端（通常是 Prometheus + spanmetrics）。
+// 这里直接用 ManticoreSearch 的聚合查询从 jaeger_spans 计算请求率、错误率和耗时分位数，
+// 实现 metricsstore.Reader，并在扩展 HTTP API 上提供与 Jaeger Query 相同的
+// /api/metrics/{latencies,calls,errors,minstep} 接口（参数和响应格式一致），
+// 将 Jaeger Query 的 /api/metrics/ 路由到插件即可使用 Monitor 页面。
+//
+// 计算方式与 Prometheus 查询的语义对齐：
+//   - 按 min(step, ratePer) 的时间粒度分组统计调用数、错误数和耗时直方图
+//   - 每个数据点取 (t-ratePer, t] 窗口内的桶求和：调用率 = 调用数/秒，错误率 = 错误数/调用数
+//   - 耗时分位数按 spanLatencyBuckets 分桶后线性插值（同 histogram_quantile），单位毫秒
+//   - 窗口内没有调用的数据点不输出
+
+var (
+	// GetMinStepDuration 返回的最小步长
+	metricsMinStep = getDurationEnv("METRICS_MIN_STEP", time.Second)
+	// 单次指标查询最多读取的分组行数，达到后查询报错（需增大 step）
+	metricsQueryLimit = getIntEnv("METRICS_QUERY_LIMIT", 100000)
+	// 单次指标查询最多的时间桶数
+	metricsMaxBuckets = getIntEnv("METRICS_MAX_BUCKETS", 10000)
+	// 是否从 jaeger_span_metrics 汇总表读取（见 spanmetrics.go），汇总表覆盖查询范围后再开启
+	metricsUseRollups = getBoolEnv("METRICS_USE_ROLLUPS", false)
+)
+
+// spanLatencyBuckets 耗时直方图的上界，与 spanmetrics connector 的默认分桶一致
+var spanLatencyBuckets = []time.Duration{
+	2 * time.Millisecond, 4 * time.Millisecond, 6 * time.Millisecond, 8 * time.Millisecond,
+	10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond,
+	400 * time.Millisecond, 800 * time.Millisecond, time.Second, 1400 * time.Millisecond,
+	2 * time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second,
+}
+
+// 指标标签名，与 Jaeger 的 Prometheus 实现一致
+const (
+	serviceLabel   = "service_name"
+	operationLabel = "operation"
+)
+
+// MySQLMetricsReader 实现 metricsstore.Reader
+type MySQLMetricsReader struct {
+	store  *MySQLStore
+	logger zerolog.Logger
+}
+
+var _ metricsstore.Reader = (*MySQLMetricsReader)(nil)
+
+// MetricsReader 返回基于 jaeger_spans 聚合的 metricsstore.Reader
+func (s *MySQLStore) MetricsReader() metricsstore.Reader {
+	return &MySQLMetricsReader{
+		store:  s,
+		logger: s.logger,
+	}
+}
+
+// GetLatencies 返回耗时分位数（毫秒）
+func (r *MySQLMetricsReader) GetLatencies(ctx context.Context, params *metricsstore.LatenciesQueryParameters) (*metrics.MetricFamily, error) {
+	if params.Quantile < 0 || params.Quantile > 1 {
+		return nil, invalidQuery(fmt.Errorf("invalid quantile %v: must be between 0 and 1", params.Quantile))
+	}
+	return r.query(ctx, &params.BaseQueryParameters, redQuery{
+		name:      "service_latencies",
+		help:      fmt.Sprintf("%.2fth quantile latency, grouped by service", params.Quantile),
+		histogram: true,
+		value: func(w *redWindow, _ time.Duration) (float64, bool) {
+			return w.quantile(params.Quantile)
+		},
+	})
+}
+
+// GetCallRates 返回每秒调用数
+func (r *MySQLMetricsReader) GetCallRates(ctx context.Context, params *metricsstore.CallRateQueryParameters) (*metrics.MetricFamily, error) {
+	return r.query(ctx, &params.BaseQueryParameters, redQuery{
+		name: "service_call_rate",
+		help: "calls/sec, grouped by service",
+		value: func(w *redWindow, ratePer time.Duration) (float64, bool) {
+			return float64(w.calls) / ratePer.Seconds(), w.calls > 0
+		},
+	})
+}
+
+// GetErrorRates 返回错误调用占比
+func (r *MySQLMetricsReader) GetErrorRates(ctx context.Context, params *metricsstore.ErrorRateQueryParameters) (*metrics.MetricFamily, error) {
+	return r.query(ctx, &params.BaseQueryParameters, redQuery{
+		name: "service_error_rate",
+		help: "error rate, computed as a fraction of errors/sec over calls/sec, grouped by service",
+		value: func(w *redWindow, _ time.Duration) (float64, bool) {
+			if w.calls == 0 {
+				return 0, false
+			}
+			return float64(w.errors) / float64(w.calls), true
+		},
+	})
+}
+
+// GetMinStepDuration 返回支持的最小步长
+func (r *MySQLMetricsReader) GetMinStepDuration(context.Context, *metricsstore.MinStepDurationQueryParameters) (time.Duration, error) {
+	return metricsMinStep, nil
+}
+
+// redQuery 一种指标的计算方式
+type redQuery struct {
+	name      string
+	help      string
+	histogram bool                                                      // 是否需要耗时直方图
+	value     func(w *redWindow, ratePer time.Duration) (float64, bool) // 计算一个数据点，false 表示无数据
+}
+
+// redSeriesKey 一条时间序列
+type redSeriesKey struct {
+	service, operation string
+}
+
+// redBucket 一个时间桶的统计
+type redBucket struct {
+	calls, errors int64
+	latency       []int64 // 按 spanLatencyBuckets 分桶的计数，最后一个为 +Inf
+}
+
+// redWindow 一个数据点窗口内的统计
+type redWindow redBucket
+
+func (w *redWindow) add(b *redBucket) {
+	w.calls += b.calls
+	w.errors += b.errors
+	if b.latency == nil {
+		return
+	}
+	if w.latency == nil {
+		w.latency = make([]int64, len(spanLatencyBuckets)+1)
+	}
+	for i, n := range b.latency {
+		w.latency[i] += n
+	}
+}
+
+// quantile 按直方图线性插值计算分位数（毫秒），与 Prometheus histogram_quantile 一致
+func (w *redWindow) quantile(q float64) (float64, bool) {
+	var total int64
+	for _, n := range w.latency {
+		total += n
+	}
+	if total == 0 {
+		return 0, false
+	}
+	rank := q * float64(total)
+	var (
+		seen  int64
+		lower float64
+	)
+	for i, n := range w.latency {
+		if i == len(spanLatencyBuckets) {
+			// +Inf 桶：返回最大的有限上界
+			break
+		}
+		upper := float64(spanLatencyBuckets[i]) / float64(time.Millisecond)
+		if n > 0 && float64(seen+n) >= rank {
+			return lower + (upper-lower)*(rank-float64(seen))/float64(n), true
+		}
+		seen += n
+		lower = upper
+	}
+	return lower, true
+}
+
+// metricsGranularity 返回统计的时间粒度
+func metricsGranularity(step, ratePer time.Duration) time.Duration {
+	g := step
+	if ratePer < g {
+		g = ratePer
+	}
+	if g < metricsMinStep {
+		g = metricsMinStep
+	}
+	return g
+}
+
+// storedSpanKinds 将 OTel 的 span kind（SPAN_KIND_SERVER 等）转换为 span_kind 列的取值
+func storedSpanKinds(kinds []string) ([]string, error) {
+	stored := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		v, ok := metrics.SpanKind_value[kind]
+		if !ok {
+			return nil, fmt.Errorf("unsupported span kind %q", kind)
+		}
+		if metrics.SpanKind(v) == metrics.SpanKind_SPAN_KIND_UNSPECIFIED {
+			stored = append(stored, "")
+			continue
+		}
+		stored = append(stored, strings.ToLower(strings.TrimPrefix(kind, "SPAN_KIND_")))
+	}
+	return stored, nil
+}
+
+// redSource 指标查询的数据来源
+type redSource struct {
+	table      string
+	timeColumn string
+	leExpr     string // 耗时分桶下标
+	callsExpr  string
+	errorsExpr string
+}
+
+// spanLatencyInterval 按 spanLatencyBuckets 计算耗时分桶下标的表达式
+func spanLatencyInterval() string {
+	bounds := make([]string, len(spanLatencyBuckets))
+	for i, b := range spanLatencyBuckets {
+		// 桶上界包含在桶内（le），INTERVAL 的分界点包含在下一个桶，因此 +1
+		bounds[i] = fmt.Sprint(b.Nanoseconds() + 1)
+	}
+	return "INTERVAL(duration, " + strings.Join(bounds, ", ") + ")"
+}
+
+var (
+	// spanSource 直接聚合 jaeger_spans
+	spanSource = redSource{
+		table:      "jaeger_spans",
+		timeColumn: "start_time",
+		leExpr:     spanLatencyInterval(),
+		callsExpr:  "COUNT(*)",
+		errorsExpr: "SUM(is_error)",
+	}
+	// rollupSource 聚合写入端生成的 jaeger_span_metrics
+	rollupSource = redSource{
+		table:      "jaeger_span_metrics",
+		timeColumn: "bucket_start",
+		leExpr:     "le",
+		callsExpr:  "SUM(calls)",
+		errorsExpr: "SUM(errors)",
+	}
+)
+
+// redSourceFor 返回查询使用的数据来源：粒度是汇总桶的整数倍时才能读取汇总表
+func redSourceFor(granularity time.Duration) redSource {
+	if metricsUseRollups && granularity%spanMetricsRollupInterval == 0 {
+		return rollupSource
+	}
+	return spanSource
+}
+
+// buildREDSQL 生成按服务（可选操作）、时间桶（可选耗时桶）分组的聚合查询
+func buildREDSQL(src redSource, p *metricsstore.BaseQueryParameters, from, to time.Time, granularity time.Duration, histogram bool) (string, []interface{}, error) {
+	kinds, err := storedSpanKinds(p.SpanKinds)
+	if err != nil {
+		return "", nil, err
+	}
+
+	columns := []string{"service_name"}
+	groupBy := []string{"service_name"}
+	if p.GroupByOperation {
+		columns = append(columns, "operation_name")
+		groupBy = append(groupBy, "operation_name")
+	}
+	columns = append(columns, fmt.Sprintf("IDIV(%s, %d) AS bucket", src.timeColumn, granularity.Nanoseconds()))
+	groupBy = append(groupBy, "bucket")
+	if histogram {
+		if src.leExpr == "le" {
+			columns = append(columns, "le")
+		} else {
+			columns = append(columns, src.leExpr+" AS le")
+		}
+		groupBy = append(groupBy, "le")
+	}
+	columns = append(columns, src.callsExpr+" AS calls", src.errorsExpr+" AS errors")
+
+	var sb strings.Builder
+	sb.WriteString("SELECT " + strings.Join(columns, ", ") + " FROM " + src.table)
+	sb.WriteString(" WHERE " + src.timeColumn + " >= ? AND " + src.timeColumn + " < ?")
+	args := []interface{}{from.UnixNano(), to.UnixNano()}
+	sb.WriteString(" AND service_name IN (" + placeholders(len(p.ServiceNames)) + ")")
+	for _, s := range p.ServiceNames {
+		args = append(args, s)
+	}
+	if len(kinds) > 0 {
+		sb.WriteString(" AND span_kind IN (" + placeholders(len(kinds)) + ")")
+		for _, k := range kinds {
+			args = append(args, k)
+		}
+	}
+	sb.WriteString(" GROUP BY " + strings.Join(groupBy, ", "))
+	sb.WriteString(fmt.Sprintf(" LIMIT %d OPTION max_matches=%d", metricsQueryLimit, metricsQueryLimit))
+	return sb.String(), args, nil
+}
+
+// query 读取分桶统计并计算每个数据点
+func (r *MySQLMetricsReader) query(ctx context.Context, p *metricsstore.BaseQueryParameters, q redQuery) (*metrics.MetricFamily, error) {
+	if len(p.ServiceNames) == 0 {
+		return nil, invalidQuery(errors.New("please provide at least one service name"))
+	}
+	if p.EndTime == nil || p.Lookback == nil || p.Step == nil || p.RatePer == nil {
+		return nil, invalidQuery(errors.New("endTime, lookback, step and ratePer are required"))
+	}
+	end, lookback, step, ratePer := *p.EndTime, *p.Lookback, *p.Step, *p.RatePer
+	if lookback <= 0 || step <= 0 || ratePer <= 0 {
+		return nil, invalidQuery(errors.New("lookback, step and ratePer must be positive"))
+	}
+	if step < metricsMinStep {
+		step = metricsMinStep
+	}
+	g := metricsGranularity(step, ratePer)
+	if n := (lookback + ratePer) / g; n > time.Duration(metricsMaxBuckets) {
+		return nil, invalidQuery(fmt.Errorf("query too large: %d buckets, at most %d", n, metricsMaxBuckets))
+	}
+
+	start := end.Add(-lookback)
+	query, args, err := buildREDSQL(redSourceFor(g), p, start.Add(-ratePer), end, g, q.histogram)
+	if err != nil {
+		return nil, invalidQuery(err)
+	}
+	buckets, err := r.loadREDBuckets(ctx, query, args, p.GroupByOperation, q.histogram)
+	if err != nil {
+		return nil, err
+	}
+
+	name, help := q.name, q.help
+	if p.GroupByOperation {
+		name = strings.Replace(name, "service", "service_operation", 1)
+		help += " & operation"
+	}
+	family := &metrics.MetricFamily{Name: name, Type: metrics.MetricType_GAUGE, Help: help}
+
+	keys := make([]redSeriesKey, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].service != keys[j].service {
+			return keys[i].service < keys[j].service
+		}
+		return keys[i].operation < keys[j].operation
+	})
+
+	gNanos := g.Nanoseconds()
+	for _, key := range keys {
+		series := buckets[key]
+		metric := &metrics.Metric{Labels: []*metrics.Label{{Name: serviceLabel, Value: key.service}}}
+		if p.GroupByOperation {
+			metric.Labels = append(metric.Labels, &metrics.Label{Name: operationLabel, Value: key.operation})
+		}
+		for t := start; !t.After(end); t = t.Add(step) {
+			// 窗口 (t-ratePer, t]：桶开始时间在 [t-ratePer, t) 内
+			var w redWindow
+			for b := floorDiv(t.Add(-ratePer).UnixNano()+gNanos-1, gNanos); b*gNanos < t.UnixNano(); b++ {
+				if bucket, ok := series[b]; ok {
+					w.add(bucket)
+				}
+			}
+			if v, ok := q.value(&w, ratePer); ok {
+				metric.MetricPoints = append(metric.MetricPoints, gaugePoint(t, v))
+			}
+		}
+		if len(metric.MetricPoints) > 0 {
+			family.Metrics = append(family.Metrics, metric)
+		}
+	}
+	return family, nil
+}
+
+// loadREDBuckets 读取聚合结果，按时间序列和桶编号组织，行数达到 metricsQueryLimit 时返回错误
+func (r *MySQLMetricsReader) loadREDBuckets(ctx context.Context, query string, args []interface{}, byOperation, histogram bool) (map[redSeriesKey]map[int64]*redBucket, error) {
+	rows, err := r.store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[redSeriesKey]map[int64]*redBucket)
+	n := 0
+	for rows.Next() {
+		var (
+			key                   redSeriesKey
+			bucket, le            int64
+			calls, errorsInBucket int64
+		)
+		dest := []interface{}{&key.service}
+		if byOperation {
+			dest = append(dest, &key.operation)
+		}
+		dest = append(dest, &bucket)
+		if histogram {
+			dest = append(dest, &le)
+		}
+		dest = append(dest, &calls, &errorsInBucket)
+		n++
+		if err := rows.Scan(dest...); err != nil {
+			continue
+		}
+
+		series, ok := out[key]
+		if !ok {
+			series = make(map[int64]*redBucket)
+			out[key] = series
+		}
+		b, ok := series[bucket]
+		if !ok {
+			b = &redBucket{}
+			series[bucket] = b
+		}
+		b.calls += calls
+		b.errors += errorsInBucket
+		if histogram && le >= 0 && le <= int64(len(spanLatencyBuckets)) {
+			if b.latency == nil {
+				b.latency = make([]int64, len(spanLatencyBuckets)+1)
+			}
+			b.latency[le] += calls
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// 达到行数上限时结果不完整，返回错误而不是偏小的数值
+	if n >= metricsQueryLimit {
+		return nil, invalidQuery(fmt.Errorf("query too large: more than %d rows, increase step or reduce lookback", metricsQueryLimit))
+	}
+	return out, nil
+}
+
+// floorDiv 向下取整的整数除法
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// gaugePoint 构造一个 double gauge 数据点
+func gaugePoint(t time.Time, v float64) *metrics.MetricPoint {
+	return &metrics.MetricPoint{
+		Timestamp: &types.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())},
+		Value: &metrics.MetricPoint_GaugeValue{
+			GaugeValue: &metrics.GaugeValue{Value: &metrics.GaugeValue_DoubleValue{DoubleValue: v}},
+		},
+	}
+}
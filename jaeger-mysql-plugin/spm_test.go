@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/proto-gen/api_v2/metrics"
+	"github.com/jaegertracing/jaeger/storage/metricsstore"
+)
+
+// redParams 构造指标查询参数：结束于 600s，回溯 2 分钟，步长和 ratePer 均为 1 分钟
+func redParams(groupByOperation bool) metricsstore.BaseQueryParameters {
+	end := time.Unix(600, 0)
+	lookback, step, ratePer := 2*time.Minute, time.Minute, time.Minute
+	return metricsstore.BaseQueryParameters{
+		ServiceNames:     []string{"order"},
+		GroupByOperation: groupByOperation,
+		EndTime:          &end,
+		Lookback:         &lookback,
+		Step:             &step,
+		RatePer:          &ratePer,
+		SpanKinds:        []string{"SPAN_KIND_SERVER"},
+	}
+}
+
+// pointValues 返回时间序列的 (秒, 值) 列表
+func pointValues(m *metrics.Metric) map[int64]float64 {
+	out := make(map[int64]float64)
+	for _, p := range m.MetricPoints {
+		out[p.Timestamp.Seconds] = p.GetGaugeValue().GetDoubleValue()
+	}
+	return out
+}
+
+func TestREDWindowQuantile(t *testing.T) {
+	w := redWindow{latency: make([]int64, len(spanLatencyBuckets)+1)}
+	if _, ok := w.quantile(0.5); ok {
+		t.Error("empty window should have no quantile")
+	}
+	w.latency[0] = 50 // (0, 2ms]
+	w.latency[1] = 50 // (2ms, 4ms]
+	if got, _ := w.quantile(0.5); got != 2 {
+		t.Errorf("p50 = %v, want 2", got)
+	}
+	if got, _ := w.quantile(0.75); got != 3 {
+		t.Errorf("p75 = %v, want 3", got)
+	}
+	w.latency[len(spanLatencyBuckets)] = 1000 // +Inf
+	if got, _ := w.quantile(0.99); got != 15000 {
+		t.Errorf("p99 = %v, want 15000 (largest finite bound)", got)
+	}
+}
+
+func TestBuildREDSQL(t *testing.T) {
+	p := redParams(true)
+	query, args, err := buildREDSQL(spanSource, &p, time.Unix(0, 0), time.Unix(60, 0), time.Minute, true)
+	if err != nil {
+		t.Fatalf("buildREDSQL: %v", err)
+	}
+	for _, want := range []string{
+		"IDIV(start_time, 60000000000) AS bucket",
+		"INTERVAL(duration, 2000001, ",
+		"service_name IN (?)",
+		"span_kind IN (?)",
+		"GROUP BY service_name, operation_name, bucket, le",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query missing %q:\n%s", want, query)
+		}
+	}
+	if len(args) != 4 || args[2] != "order" || args[3] != "server" {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	p.SpanKinds = []string{"SPAN_KIND_BOGUS"}
+	if _, _, err := buildREDSQL(spanSource, &p, time.Unix(0, 0), time.Unix(60, 0), time.Minute, false); err == nil {
+		t.Error("expected error for unknown span kind")
+	}
+}
+
+func TestGetCallAndErrorRates(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("COUNT(*) AS calls", []string{"service_name", "bucket", "calls", "errors"},
+		[]interface{}{"order", int64(8), int64(120), int64(6)},
+		[]interface{}{"order", int64(9), int64(60), int64(0)},
+	)
+	mr := reader.store.MetricsReader()
+
+	base := redParams(false)
+	calls, err := mr.GetCallRates(context.Background(), &metricsstore.CallRateQueryParameters{BaseQueryParameters: base})
+	if err != nil {
+		t.Fatalf("GetCallRates: %v", err)
+	}
+	if calls.Name != "service_call_rate" || len(calls.Metrics) != 1 {
+		t.Fatalf("unexpected family %+v", calls)
+	}
+	if got := pointValues(calls.Metrics[0]); len(got) != 2 || got[540] != 2 || got[600] != 1 {
+		t.Errorf("call rate points = %v, want {540:2 600:1}", got)
+	}
+	if l := calls.Metrics[0].Labels; len(l) != 1 || l[0].Name != serviceLabel || l[0].Value != "order" {
+		t.Errorf("unexpected labels %v", l)
+	}
+
+	errRates, err := mr.GetErrorRates(context.Background(), &metricsstore.ErrorRateQueryParameters{BaseQueryParameters: base})
+	if err != nil {
+		t.Fatalf("GetErrorRates: %v", err)
+	}
+	if got := pointValues(errRates.Metrics[0]); math.Abs(got[540]-0.05) > 1e-9 || got[600] != 0 {
+		t.Errorf("error rate points = %v", got)
+	}
+
+	q := f.matching("COUNT(*) AS calls")[0]
+	// 窗口需要向前多取 ratePer
+	if q.args[0] != time.Unix(420, 0).UnixNano() || q.args[1] != time.Unix(600, 0).UnixNano() {
+		t.Errorf("unexpected time range args %v", q.args[:2])
+	}
+}
+
+func TestGetLatenciesGroupByOperation(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("COUNT(*) AS calls", []string{"service_name", "operation_name", "bucket", "le", "calls", "errors"},
+		[]interface{}{"order", "GET /orders", int64(9), int64(0), int64(50), int64(0)},
+		[]interface{}{"order", "GET /orders", int64(9), int64(1), int64(50), int64(0)},
+	)
+
+	family, err := reader.store.MetricsReader().GetLatencies(context.Background(), &metricsstore.LatenciesQueryParameters{
+		BaseQueryParameters: redParams(true),
+		Quantile:            0.5,
+	})
+	if err != nil {
+		t.Fatalf("GetLatencies: %v", err)
+	}
+	if family.Name != "service_operation_latencies" || len(family.Metrics) != 1 {
+		t.Fatalf("unexpected family %+v", family)
+	}
+	m := family.Metrics[0]
+	if len(m.Labels) != 2 || m.Labels[1].Name != operationLabel || m.Labels[1].Value != "GET /orders" {
+		t.Errorf("unexpected labels %v", m.Labels)
+	}
+	if got := pointValues(m); len(got) != 1 || got[600] != 2 {
+		t.Errorf("latency points = %v, want {600:2}", got)
+	}
+}
+
+func TestGetCallRatesRowLimit(t *testing.T) {
+	defer func(v int) { metricsQueryLimit = v }(metricsQueryLimit)
+	metricsQueryLimit = 2
+
+	f, reader := newTestReader(t)
+	f.on("COUNT(*) AS calls", []string{"service_name", "bucket", "calls", "errors"},
+		[]interface{}{"order", int64(8), int64(120), int64(6)},
+		[]interface{}{"order", int64(9), int64(60), int64(0)},
+	)
+	// 达到行数上限时报错，不返回偏小的结果
+	_, err := reader.store.MetricsReader().GetCallRates(context.Background(),
+		&metricsstore.CallRateQueryParameters{BaseQueryParameters: redParams(false)})
+	if !isInvalidQuery(err) || !strings.Contains(err.Error(), "query too large") {
+		t.Errorf("expected query too large error, got %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	newAPIServer(reader.store).handler().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/api/metrics/calls?service=order&endTs=600000&lookback=120000&step=60000&ratePer=60000", nil))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "query too large") {
+		t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestLatenciesMethodNotAllowed(t *testing.T) {
+	_, reader := newTestReader(t)
+	rec := httptest.NewRecorder()
+	newAPIServer(reader.store).handler().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodPost, "/api/metrics/latencies?service=order", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ====================
+// 后台任务状态（jaeger_state）
+// ====================
+//
+// 一次性任务和增量任务的进度单独记录在 jaeger_state 中，不从业务表推断
+// （例如不能用 jaeger_operations 是否为空判断目录是否已初始化）。
+// 每个状态一行，id 由名称哈希得到，REPLACE 覆盖。
+
+// 状态名称
+const (
+	// 目录已从 jaeger_spans 初始化，value 为完成时间（纳秒）
+	stateOperationsCatalogSeeded = "operations_catalog_seeded"
+	// 最早聚合的依赖桶开始时间（纳秒），之前的时间段读取时现算
+	stateDependenciesFirstBucket = "dependencies_first_bucket"
+	// 最近聚合的依赖桶开始时间（纳秒），空桶同样推进
+	stateDependenciesLastBucket = "dependencies_last_bucket"
+)
+
+// loadState 读取状态值，不存在时 ok 为 false
+func loadState(ctx context.Context, db *sql.DB, name string) (value int64, ok bool, err error) {
+	err = db.QueryRowContext(ctx, "SELECT value FROM jaeger_state WHERE id = ?", stableID(name)).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("load state %s failed: %w", name, err)
+	}
+	return value, true, nil
+}
+
+// saveState 写入状态值
+func saveState(ctx context.Context, db *sql.DB, name string, value int64) error {
+	if _, err := db.ExecContext(ctx, "REPLACE INTO jaeger_state (id, name, value) VALUES (?, ?, ?)",
+		stableID(name), name, value); err != nil {
+		return fmt.Errorf("save state %s failed: %w", name, err)
+	}
+	return nil
+}
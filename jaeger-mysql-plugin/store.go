@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"strings"
@@ -26,14 +28,22 @@ var json = jsoniter.ConfigCompatibleWithStandardLibrary
 // ====================
 
 var (
-	// 服务列表缓存过期时间
-	servicesCacheTTL = getDurationEnv("CACHE_TTL", 30*time.Second)
-	// 操作列表缓存过期时间
-	operationsCacheTTL = getDurationEnv("CACHE_TTL", 30*time.Second)
+	// 服务列表缓存过期时间（未设置时使用 CACHE_TTL）
+	servicesCacheTTL = getDurationEnv("SERVICES_CACHE_TTL", getDurationEnv("CACHE_TTL", 30*time.Second))
+	// 操作列表缓存过期时间（未设置时使用 CACHE_TTL）
+	operationsCacheTTL = getDurationEnv("OPERATIONS_CACHE_TTL", getDurationEnv("CACHE_TTL", 30*time.Second))
+	// 操作列表缓存最多保存的 (service, span_kind) 条目数
+	operationsCacheSize = getIntEnv("OPERATIONS_CACHE_SIZE", 1000)
 	// 批量写入缓冲区大小
 	batchWriteSize = getIntEnv("BATCH_SIZE", 50)
 	// 批量写入超时时间
 	batchWriteTimeout = getDurationEnv("BATCH_TIMEOUT", 500*time.Millisecond)
+	// 批量读取 trace 时每个 IN 查询包含的 trace ID 数量
+	traceFetchChunkSize = getIntEnv("TRACE_FETCH_CHUNK_SIZE", 20)
+	// 批量读取 trace 时的最大并发查询数
+	traceFetchConcurrency = getIntEnv("TRACE_FETCH_CONCURRENCY", 4)
+	// 单个分块查询最多返回的 span 数（同时作为 max_matches），单个 trace 超过时截断并在 Warnings 中说明
+	traceFetchMaxSpans = getIntEnv("TRACE_FETCH_MAX_SPANS", 10000)
 )
 
 // getIntEnv 获取整数环境变量
@@ -46,6 +56,24 @@ func getIntEnv(key string, defaultVal int) int {
 	return defaultVal
 }
 
+// getStringEnv 获取字符串环境变量
+func getStringEnv(key string, defaultVal string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return defaultVal
+}
+
+// getBoolEnv 获取布尔环境变量
+func getBoolEnv(key string, defaultVal bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return defaultVal
+}
+
 // getDurationEnv 获取时间环境变量
 func getDurationEnv(key string, defaultVal time.Duration) time.Duration {
 	if v := os.Getenv(key); v != "" {
@@ -69,25 +97,62 @@ type argsSlice struct {
 var argsPool = sync.Pool{
 	New: func() interface{} {
 		return &argsSlice{
-			data: make([]interface{}, 0, 550), // 50 spans * 11 fields
+			data: make([]interface{}, 0, 50*spanInsertFieldCount), // 50 spans
 		}
 	},
 }
 
 // ====================
+// jaeger_spans 写入列定义
 // ====================
-// 缓存结构
-// ====================
 
-type cacheEntry[T any] struct {
-	data      T
-	expiresAt time.Time
+// spanInsertColumns 是写入 jaeger_spans 的列，与 appendSpanValues 的顺序一致
+const spanInsertColumns = `trace_id, span_id, operation_name, flags,
+		start_time, duration, tags, logs, refs, process, service_name, tag_kv, tag_attrs,
+		span_kind, is_error, derived_version`
+
+// spanInsertFieldCount 每个 span 写入的字段数
+const spanInsertFieldCount = 16
+
+// spanDerivedVersion 写入时从 tags 计算的列（tag_kv、tag_attrs、span_kind、is_error）的版本，
+// 小于该版本的行由 backfillDerivedColumns 重新计算。新增派生列时递增
+const spanDerivedVersion = 1
+
+// spanInsertPlaceholders 单个 span 的 VALUES 占位符
+var spanInsertPlaceholders = "(" + strings.TrimSuffix(strings.Repeat("?, ", spanInsertFieldCount), ", ") + ")"
+
+// appendSpanValues 按 spanInsertColumns 的顺序追加 span 的字段值
+// 类型专用序列化（无反射，高性能）
+func appendSpanValues(args []interface{}, span *model.Span) []interface{} {
+	return append(args,
+		span.TraceID.String(),
+		span.SpanID.String(),
+		span.OperationName,
+		span.Flags,
+		span.StartTime.UnixNano(),
+		span.Duration.Nanoseconds(),
+		marshalTags(span.Tags),
+		marshalLogs(span.Logs),
+		marshalRefs(span.References),
+		marshalProcess(span.Process),
+		span.Process.ServiceName,
+		marshalTagKV(span.Tags),
+		marshalTagAttrs(span.Tags),
+		spanKindOf(span.Tags),
+		boolToInt(spanIsError(span.Tags)),
+		spanDerivedVersion,
+	)
 }
 
-func (c *cacheEntry[T]) isValid() bool {
-	return time.Now().Before(c.expiresAt)
+// operationsCacheKey 操作列表缓存 key
+type operationsCacheKey struct {
+	service  string
+	spanKind string
 }
 
+// servicesCacheKey 服务列表缓存只有一个条目
+const servicesCacheKey = "services"
+
 // ====================
 // MySQLStore 主结构
 // ====================
@@ -96,10 +161,26 @@ type MySQLStore struct {
 	db     *sql.DB
 	logger zerolog.Logger
 
-	// 缓存
-	servicesCache   *cacheEntry[[]string]
-	operationsCache map[string]*cacheEntry[[]spanstore.Operation]
-	cacheMu         sync.RWMutex
+	// 缓存（写入端发现新服务/操作时定向失效）
+	servicesCache   *lruCache[string, []string]
+	operationsCache *lruCache[operationsCacheKey, []spanstore.Operation]
+	traceCache      *lruCache[model.TraceID, *model.Trace] // nil 表示禁用
+	traceGens       traceGenerations                       // 按 trace 分片的写入代数
+
+	// 尚未写入数据库的 span（nil 表示禁用 read-your-writes）
+	pending *pendingSpans
+
+	// 服务/操作目录写入去重
+	catalog *operationCatalog
+
+	// trace 摘要（nil 表示禁用）
+	summaries *traceSummaries
+
+	// 操作名规范化（nil 表示未配置）
+	normalizer *operationNormalizer
+
+	// 写入时聚合的 span 指标（nil 表示禁用）
+	spanMetrics *spanMetrics
 
 	// 批量写入
 	spanBuffer chan *model.Span
@@ -110,21 +191,56 @@ type MySQLStore struct {
 }
 
 func NewMySQLStore(db *sql.DB, logger zerolog.Logger) *MySQLStore {
-	store := &MySQLStore{
-		db:              db,
-		logger:          logger,
-		operationsCache: make(map[string]*cacheEntry[[]spanstore.Operation]),
-		spanBuffer:      make(chan *model.Span, batchWriteSize*2),
-		stopCh:          make(chan struct{}),
-	}
+	store := newMySQLStore(db, logger)
 
 	// 启动批量写入 goroutine
 	store.wg.Add(1)
 	go store.batchWriteLoop()
 
+	// 后台预聚合依赖关系
+	if dependenciesJobEnabled {
+		store.wg.Add(1)
+		go store.dependencyJob()
+	}
+
+	// 为旧数据补齐 span_kind，从已有 span 初始化服务/操作目录，
+	// 并重新计算 tag_kv、tag_attrs、is_error（依次执行）
+	if spanKindBackfillEnabled || operationsCatalogSeedEnabled || derivedBackfillEnabled {
+		store.wg.Add(1)
+		go store.startupTasks()
+	}
+
+	// 定期写入 span 指标汇总
+	if store.spanMetrics != nil && spanMetricsRollup {
+		store.wg.Add(1)
+		go store.spanMetricsRollupLoop()
+	}
+
 	return store
 }
 
+// newMySQLStore 初始化存储结构，不启动后台 goroutine
+func newMySQLStore(db *sql.DB, logger zerolog.Logger) *MySQLStore {
+	var pending *pendingSpans
+	if readYourWritesEnabled {
+		pending = newPendingSpans()
+	}
+	return &MySQLStore{
+		db:              db,
+		logger:          logger,
+		servicesCache:   newLRUCache[string, []string](1, servicesCacheTTL),
+		operationsCache: newLRUCache[operationsCacheKey, []spanstore.Operation](operationsCacheSize, operationsCacheTTL),
+		traceCache:      newTraceCache(),
+		catalog:         newOperationCatalog(),
+		summaries:       newTraceSummaries(),
+		normalizer:      newOperationNormalizer(logger),
+		spanMetrics:     newSpanMetrics(),
+		pending:         pending,
+		spanBuffer:      make(chan *model.Span, batchWriteSize*2),
+		stopCh:          make(chan struct{}),
+	}
+}
+
 // Close 关闭存储，刷新缓冲区
 func (s *MySQLStore) Close() error {
 	s.stopMu.Lock()
@@ -137,6 +253,19 @@ func (s *MySQLStore) Close() error {
 
 	close(s.stopCh)
 	s.wg.Wait()
+
+	// 批量写入已全部完成，写入剩余的指标汇总
+	if s.spanMetrics != nil && spanMetricsRollup {
+		if err := s.flushSpanMetricsRollups(context.Background()); err != nil {
+			s.logger.Warn().Err(err).Msg("Failed to write span metrics rollups")
+		}
+	}
+
+	event := s.logger.Info()
+	for _, stats := range s.cacheStats() {
+		event = event.Interface(stats.name+"_cache", stats.cacheStats)
+	}
+	event.Msg("Cache stats")
 	return nil
 }
 
@@ -164,6 +293,8 @@ func (s *MySQLStore) batchWriteLoop() {
 		} else {
 			s.logger.Debug().Int("count", len(batch)).Msg("Batch write completed")
 		}
+		// 无论成功与否都从待写入索引中移除，避免内存泄漏
+		s.pending.remove(batch)
 		batch = batch[:0]
 	}
 
@@ -207,10 +338,7 @@ func (s *MySQLStore) writeBatch(ctx context.Context, spans []*model.Span) error
 	// 构建批量 INSERT 语句（预分配空间，减少扩容）
 	var sb strings.Builder
 	sb.Grow(len(spans) * 256) // 每个 span 约 200-256 字节
-	sb.WriteString(`INSERT INTO jaeger_spans (
-		trace_id, span_id, operation_name, flags,
-		start_time, duration, tags, logs, refs, process, service_name
-	) VALUES `)
+	sb.WriteString("INSERT INTO jaeger_spans (" + spanInsertColumns + ") VALUES ")
 
 	// 使用 sync.Pool 复用 args 切片，减少 GC 压力
 	as := argsPool.Get().(*argsSlice)
@@ -220,22 +348,8 @@ func (s *MySQLStore) writeBatch(ctx context.Context, spans []*model.Span) error
 		if i > 0 {
 			sb.WriteString(", ")
 		}
-		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
-
-		// 类型专用序列化（无反射，高性能）
-		as.data = append(as.data,
-			span.TraceID.String(),
-			span.SpanID.String(),
-			span.OperationName,
-			span.Flags,
-			span.StartTime.UnixNano(),
-			span.Duration.Nanoseconds(),
-			marshalTags(span.Tags),
-			marshalLogs(span.Logs),
-			marshalRefs(span.References),
-			marshalProcess(span.Process),
-			span.Process.ServiceName,
-		)
+		sb.WriteString(spanInsertPlaceholders)
+		as.data = appendSpanValues(as.data, span)
 	}
 
 	_, err := s.db.ExecContext(ctx, sb.String(), as.data...)
@@ -247,8 +361,22 @@ func (s *MySQLStore) writeBatch(ctx context.Context, spans []*model.Span) error
 		return fmt.Errorf("batch insert failed: %w", err)
 	}
 
-	// 写入后清除服务缓存（可能有新服务）
-	s.invalidateServicesCache()
+	// 清除相关 trace 的缓存，下次 GetTrace 能看到新 span
+	s.invalidateTraces(spans)
+
+	// 累加写入端指标
+	s.spanMetrics.record(spans)
+
+	// 更新服务/操作目录（失败不影响 span 写入，下一批会重试）
+	// 发现新服务/操作时会定向清除对应缓存
+	if err := s.recordOperations(ctx, spans); err != nil {
+		s.logger.Warn().Err(err).Msg("Failed to record operations")
+	}
+
+	// 更新 trace 摘要（失败不影响 span 写入）
+	if err := s.updateTraceSummaries(ctx, spans); err != nil {
+		s.logger.Warn().Err(err).Msg("Failed to update trace summaries")
+	}
 
 	return nil
 }
@@ -289,6 +417,62 @@ func marshalProcess(p *model.Process) string {
 	return encodeJSON(p)
 }
 
+// marshalTagKV 将 tags 转换为 "key=value" 形式的全文索引文本（每行一个 tag）
+// 搜索时对 key 和 value 做短语匹配，保证 "http.method GET" 只匹配
+// http.method 这个 tag 的值为 GET，而不是两个词分别出现在任意位置
+func marshalTagKV(tags []model.KeyValue) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for i, kv := range tags {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(kv.Key)
+		sb.WriteByte('=')
+		sb.WriteString(kv.AsString())
+	}
+	return sb.String()
+}
+
+// marshalTagAttrs 将 tags 转换为 JSON 对象，写入 tag_attrs 属性用于比较过滤
+// 数值保持数值类型（支持 >=、< 等比较），其余类型（含 bool）统一为字符串
+func marshalTagAttrs(tags []model.KeyValue) string {
+	if len(tags) == 0 {
+		return "{}"
+	}
+	attrs := make(map[string]interface{}, len(tags))
+	for _, kv := range tags {
+		switch kv.VType {
+		case model.ValueType_INT64:
+			attrs[kv.Key] = kv.Int64()
+		case model.ValueType_FLOAT64:
+			if f := kv.Float64(); !math.IsNaN(f) && !math.IsInf(f, 0) {
+				attrs[kv.Key] = f
+			} else {
+				attrs[kv.Key] = kv.AsString()
+			}
+		default:
+			attrs[kv.Key] = kv.AsString()
+		}
+	}
+	return encodeJSON(attrs)
+}
+
+// spanKindOf 从 span.kind tag 中提取 span kind，未设置或取值非法时返回空字符串
+func spanKindOf(tags []model.KeyValue) string {
+	tag, ok := model.KeyValues(tags).FindByKey("span.kind")
+	if !ok {
+		return ""
+	}
+	switch kind := tag.AsString(); kind {
+	case "client", "server", "producer", "consumer", "internal":
+		return kind
+	}
+	return ""
+}
+
 // encodeJSON 通用 JSON 编码
 // 使用标准库 json.Marshal，简洁且性能足够
 // 如需更高性能，可替换为 github.com/json-iterator/go 或 github.com/bytedance/sonic
@@ -302,9 +486,15 @@ func encodeJSON(v interface{}) string {
 
 // invalidateServicesCache 使服务缓存失效
 func (s *MySQLStore) invalidateServicesCache() {
-	s.cacheMu.Lock()
-	s.servicesCache = nil
-	s.cacheMu.Unlock()
+	s.servicesCache.Delete(servicesCacheKey)
+}
+
+// invalidateOperationsCache 使某个服务的操作缓存失效（不限 span kind 的查询和指定 kind 的查询）
+func (s *MySQLStore) invalidateOperationsCache(service, spanKind string) {
+	s.operationsCache.Delete(operationsCacheKey{service: service})
+	if spanKind != "" {
+		s.operationsCache.Delete(operationsCacheKey{service: service, spanKind: spanKind})
+	}
 }
 
 // 实现 StoragePluginServer 接口
@@ -326,7 +516,7 @@ func (s *MySQLStore) SpanWriter() spanstore.Writer {
 
 func (s *MySQLStore) DependencyReader() dependencystore.Reader {
 	return &MySQLDependencyReader{
-		db:     s.db,
+		store:  s,
 		logger: s.logger,
 	}
 }
@@ -347,6 +537,9 @@ func (w *MySQLSpanWriter) WriteSpan(ctx context.Context, span *model.Span) error
 		Str("span_id", span.SpanID.String()).
 		Msg("Queuing span for batch write")
 
+	// 规范化操作名（写入、待写入索引和目录都使用改写后的名称）
+	w.store.normalizer.apply(span)
+
 	// 检查是否已停止
 	if w.store.isStopped() {
 		w.logger.Warn().Msg("Store is stopping, writing directly")
@@ -354,11 +547,14 @@ func (w *MySQLSpanWriter) WriteSpan(ctx context.Context, span *model.Span) error
 	}
 
 	// 非阻塞发送到批量写入缓冲区
+	// 先加入待写入索引，保证入队后立即可读
+	w.store.pending.add(span)
 	select {
 	case w.store.spanBuffer <- span:
 		return nil
 	default:
 		// 缓冲区满，直接写入
+		w.store.pending.remove([]*model.Span{span})
 		w.logger.Warn().Msg("Span buffer full, writing directly")
 		return w.writeSpanDirect(ctx, span)
 	}
@@ -366,37 +562,29 @@ func (w *MySQLSpanWriter) WriteSpan(ctx context.Context, span *model.Span) error
 
 // writeSpanDirect 直接写入单个 span（fallback）
 func (w *MySQLSpanWriter) writeSpanDirect(ctx context.Context, span *model.Span) error {
-	tags := marshalTags(span.Tags)
-	logs := marshalLogs(span.Logs)
-	refs := marshalRefs(span.References)
-	process := marshalProcess(span.Process)
-
-	query := `
-		INSERT INTO jaeger_spans (
-			trace_id, span_id, operation_name, flags,
-			start_time, duration, tags, logs, refs, process, service_name
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
+	query := "INSERT INTO jaeger_spans (" + spanInsertColumns + ") VALUES " + spanInsertPlaceholders
 
-	_, err := w.db.ExecContext(ctx, query,
-		span.TraceID.String(),
-		span.SpanID.String(),
-		span.OperationName,
-		span.Flags,
-		span.StartTime.UnixNano(),
-		span.Duration.Nanoseconds(),
-		tags,
-		logs,
-		refs,
-		process,
-		span.Process.ServiceName,
-	)
+	args := appendSpanValues(make([]interface{}, 0, spanInsertFieldCount), span)
+	_, err := w.db.ExecContext(ctx, query, args...)
 
 	if err != nil {
 		w.logger.Error().Err(err).Msg("Failed to write span directly")
 		return err
 	}
 
+	spans := []*model.Span{span}
+	w.store.invalidateTraces(spans)
+	w.store.spanMetrics.record(spans)
+
+	// 与 writeBatch 一致：只经直接写入的服务/操作也要进入目录，否则 GetServices 看不到
+	if err := w.store.recordOperations(ctx, spans); err != nil {
+		w.logger.Warn().Err(err).Msg("Failed to record operations")
+	}
+
+	if err := w.store.updateTraceSummaries(ctx, spans); err != nil {
+		w.logger.Warn().Err(err).Msg("Failed to update trace summary")
+	}
+
 	return nil
 }
 
@@ -413,6 +601,34 @@ type MySQLSpanReader struct {
 func (r *MySQLSpanReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
 	r.logger.Debug().Str("trace_id", traceID.String()).Msg("Getting trace")
 
+	stored, err := r.getStoredTrace(ctx, traceID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 合并尚未写入数据库的 span
+	pending := r.store.pending.get(traceID)
+	if stored == nil {
+		if len(pending) == 0 {
+			return nil, spanstore.ErrTraceNotFound
+		}
+		return &model.Trace{Spans: mergeSpans(nil, pending)}, nil
+	}
+	if len(pending) == 0 {
+		return stored, nil
+	}
+	return &model.Trace{Spans: mergeSpans(stored.Spans, pending), Warnings: stored.Warnings}, nil
+}
+
+// getStoredTrace 读取数据库中的 trace（经过最近 trace 缓存），不存在时返回 nil
+func (r *MySQLSpanReader) getStoredTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	// 检查最近 trace 缓存（包括短暂缓存的"不存在"结果）
+	if trace, found, ok := r.store.cachedTrace(traceID); ok {
+		r.logger.Debug().Str("trace_id", traceID.String()).Bool("found", found).Msg("Trace from cache")
+		return trace, nil
+	}
+
+	gen := r.store.traceCacheGeneration(traceID)
 	query := `
 		SELECT trace_id, span_id, operation_name, flags,
 			   start_time, duration, tags, logs, refs, process, service_name
@@ -433,29 +649,34 @@ func (r *MySQLSpanReader) GetTrace(ctx context.Context, traceID model.TraceID) (
 	}
 
 	if len(spans) == 0 {
-		return nil, spanstore.ErrTraceNotFound
+		r.store.cacheTrace(traceID, nil, gen)
+		return nil, nil
 	}
 
-	return &model.Trace{Spans: spans}, nil
+	trace := &model.Trace{Spans: spans}
+	r.store.cacheTrace(traceID, trace, gen)
+	return trace, nil
 }
 
 func (r *MySQLSpanReader) GetServices(ctx context.Context) ([]string, error) {
 	r.logger.Debug().Msg("Getting services")
 
 	// 检查缓存
-	r.store.cacheMu.RLock()
-	if r.store.servicesCache != nil && r.store.servicesCache.isValid() {
-		services := r.store.servicesCache.data
-		r.store.cacheMu.RUnlock()
+	if services, ok := r.store.servicesCache.Get(servicesCacheKey); ok {
 		r.logger.Debug().Int("count", len(services)).Msg("Services from cache")
 		return services, nil
 	}
-	r.store.cacheMu.RUnlock()
-
-	// 查询数据库
-	query := `SELECT service_name FROM jaeger_spans GROUP BY service_name`
 
-	rows, err := r.db.QueryContext(ctx, query)
+	// 查询服务/操作目录
+	query := fmt.Sprintf(`
+		SELECT service_name
+		FROM jaeger_operations
+		WHERE last_seen >= ?
+		GROUP BY service_name
+		LIMIT %d OPTION max_matches=%d
+	`, catalogQueryLimit, catalogQueryLimit)
+
+	rows, err := r.db.QueryContext(ctx, query, operationsCutoff())
 	if err != nil {
 		return nil, err
 	}
@@ -474,42 +695,43 @@ func (r *MySQLSpanReader) GetServices(ctx context.Context) ([]string, error) {
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	if allServicesInList && allServicesName != "" {
+		services = append(services, allServicesName)
+	}
 
 	// 更新缓存
-	r.store.cacheMu.Lock()
-	r.store.servicesCache = &cacheEntry[[]string]{
-		data:      services,
-		expiresAt: time.Now().Add(servicesCacheTTL),
-	}
-	r.store.cacheMu.Unlock()
+	r.store.servicesCache.Put(servicesCacheKey, services)
 
 	r.logger.Debug().Int("count", len(services)).Msg("Services from database")
 	return services, nil
 }
 
 func (r *MySQLSpanReader) GetOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
-	r.logger.Debug().Str("service", query.ServiceName).Msg("Getting operations")
+	r.logger.Debug().
+		Str("service", query.ServiceName).
+		Str("span_kind", query.SpanKind).
+		Msg("Getting operations")
 
 	// 检查缓存
-	cacheKey := query.ServiceName
-	r.store.cacheMu.RLock()
-	if cache, ok := r.store.operationsCache[cacheKey]; ok && cache.isValid() {
-		ops := cache.data
-		r.store.cacheMu.RUnlock()
+	cacheKey := operationsCacheKey{service: query.ServiceName, spanKind: query.SpanKind}
+	if ops, ok := r.store.operationsCache.Get(cacheKey); ok {
 		r.logger.Debug().Int("count", len(ops)).Msg("Operations from cache")
 		return ops, nil
 	}
-	r.store.cacheMu.RUnlock()
 
-	// 查询数据库
+	// 查询服务/操作目录（每个组合一行，无需 GROUP BY）
 	sqlQuery := `
-		SELECT operation_name 
-		FROM jaeger_spans 
-		WHERE service_name = ?
-		GROUP BY operation_name
-	`
+		SELECT operation_name, span_kind
+		FROM jaeger_operations
+		WHERE service_name = ? AND last_seen >= ?`
+	args := []interface{}{query.ServiceName, operationsCutoff()}
+	if query.SpanKind != "" {
+		sqlQuery += " AND span_kind = ?"
+		args = append(args, query.SpanKind)
+	}
+	sqlQuery += fmt.Sprintf(" LIMIT %d OPTION max_matches=%d", catalogQueryLimit, catalogQueryLimit)
 
-	rows, err := r.db.QueryContext(ctx, sqlQuery, query.ServiceName)
+	rows, err := r.db.QueryContext(ctx, sqlQuery, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -517,12 +739,12 @@ func (r *MySQLSpanReader) GetOperations(ctx context.Context, query spanstore.Ope
 
 	var operations []spanstore.Operation
 	for rows.Next() {
-		var opName string
-		if err := rows.Scan(&opName); err != nil {
+		var opName, spanKind string
+		if err := rows.Scan(&opName, &spanKind); err != nil {
 			r.logger.Warn().Err(err).Msg("Failed to scan operation")
 			continue
 		}
-		operations = append(operations, spanstore.Operation{Name: opName})
+		operations = append(operations, spanstore.Operation{Name: opName, SpanKind: spanKind})
 	}
 
 	if err := rows.Err(); err != nil {
@@ -530,12 +752,7 @@ func (r *MySQLSpanReader) GetOperations(ctx context.Context, query spanstore.Ope
 	}
 
 	// 更新缓存
-	r.store.cacheMu.Lock()
-	r.store.operationsCache[cacheKey] = &cacheEntry[[]spanstore.Operation]{
-		data:      operations,
-		expiresAt: time.Now().Add(operationsCacheTTL),
-	}
-	r.store.cacheMu.Unlock()
+	r.store.operationsCache.Put(cacheKey, operations)
 
 	r.logger.Debug().Int("count", len(operations)).Msg("Operations from database")
 	return operations, nil
@@ -545,81 +762,150 @@ func (r *MySQLSpanReader) FindTraces(ctx context.Context, query *spanstore.Trace
 	r.logger.Debug().Str("service", query.ServiceName).Msg("Finding traces")
 
 	// Step 1: 获取符合条件的 trace IDs
-	sqlQuery := `
-		SELECT trace_id, MAX(start_time) as max_start_time
-		FROM jaeger_spans
-		WHERE service_name = ?
-			AND start_time >= ?
-			AND start_time <= ?
-	`
-	args := []interface{}{
-		query.ServiceName,
-		query.StartTimeMin.UnixNano(),
-		query.StartTimeMax.UnixNano(),
+	traceIDs, err := r.findTraceIDStrings(ctx, query)
+	if err != nil {
+		return nil, err
 	}
 
-	if query.OperationName != "" {
-		sqlQuery += " AND operation_name = ?"
-		args = append(args, query.OperationName)
+	if len(traceIDs) == 0 {
+		return []*model.Trace{}, nil
 	}
 
-	// 支持 Tags 过滤（全文搜索）
-	if len(query.Tags) > 0 {
-		for key, value := range query.Tags {
-			// 使用 MATCH 进行全文搜索
-			sqlQuery += " AND MATCH(?)"
-			args = append(args, fmt.Sprintf("%s %s", key, value))
-		}
-	}
+	// Step 2: 批量获取所有 spans（解决 N+1 问题）
+	return r.getTracesByIDs(ctx, traceIDs)
+}
 
-	// 支持 Duration 过滤
-	if query.DurationMin > 0 {
-		sqlQuery += " AND duration >= ?"
-		args = append(args, query.DurationMin.Nanoseconds())
-	}
-	if query.DurationMax > 0 {
-		sqlQuery += " AND duration <= ?"
-		args = append(args, query.DurationMax.Nanoseconds())
+// getTracesByIDs 批量获取多个 trace 的所有 spans
+// trace ID 按 traceFetchChunkSize 分块，各分块并发查询（最多 traceFetchConcurrency 个），
+// 避免单条 IN 查询过长或触发 max_matches 限制，最后按原始顺序合并结果
+func (r *MySQLSpanReader) getTracesByIDs(ctx context.Context, traceIDs []string) ([]*model.Trace, error) {
+	if len(traceIDs) == 0 {
+		return []*model.Trace{}, nil
 	}
 
-	sqlQuery += " GROUP BY trace_id ORDER BY max_start_time DESC LIMIT ?"
-	args = append(args, query.NumTraces)
+	chunks := chunkStrings(traceIDs, traceFetchChunkSize)
+	results := make([]map[string]*model.Trace, len(chunks))
+	errs := make([]error, len(chunks))
 
-	rows, err := r.db.QueryContext(ctx, sqlQuery, args...)
-	if err != nil {
-		return nil, err
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := traceFetchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
 	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []string) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
 
-	var traceIDs []string
-	for rows.Next() {
-		var traceIDStr string
-		var maxStartTime int64
-		if err := rows.Scan(&traceIDStr, &maxStartTime); err != nil {
-			r.logger.Warn().Err(err).Msg("Failed to scan trace ID")
-			continue
+			results[i], errs[i] = r.fetchTraceChunk(ctx, chunk)
+			if errs[i] != nil {
+				// 任一分块失败即取消其余查询
+				cancel()
+			}
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	// 优先返回真实错误，而不是因取消产生的 context.Canceled
+	var firstErr error
+	for _, err := range errs {
+		if err != nil && (firstErr == nil || errors.Is(firstErr, context.Canceled)) {
+			firstErr = err
 		}
-		traceIDs = append(traceIDs, traceIDStr)
 	}
-	rows.Close()
+	if firstErr != nil {
+		return nil, firstErr
+	}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+	// 合并所有分块结果
+	traceMap := make(map[string]*model.Trace, len(traceIDs))
+	for _, m := range results {
+		for id, trace := range m {
+			traceMap[id] = trace
+		}
 	}
 
-	if len(traceIDs) == 0 {
-		return []*model.Trace{}, nil
+	// 按原始顺序构建结果，并合并尚未写入数据库的 span
+	traces := make([]*model.Trace, 0, len(traceIDs))
+	for _, traceIDStr := range traceIDs {
+		trace := traceMap[traceIDStr]
+		if trace == nil {
+			trace = &model.Trace{}
+		}
+		if traceID, err := model.TraceIDFromString(traceIDStr); err == nil {
+			trace.Spans = mergeSpans(trace.Spans, r.store.pending.get(traceID))
+		}
+		if len(trace.Spans) > 0 {
+			traces = append(traces, trace)
+		}
 	}
 
-	// Step 2: 批量获取所有 spans（解决 N+1 问题）
-	return r.getTracesByIDs(ctx, traceIDs)
+	r.logger.Debug().
+		Int("requested", len(traceIDs)).
+		Int("chunks", len(chunks)).
+		Int("found", len(traces)).
+		Msg("Batch traces loaded")
+
+	return traces, nil
 }
 
-// getTracesByIDs 批量获取多个 trace 的所有 spans
-func (r *MySQLSpanReader) getTracesByIDs(ctx context.Context, traceIDs []string) ([]*model.Trace, error) {
-	if len(traceIDs) == 0 {
-		return []*model.Trace{}, nil
+// fetchTraceChunk 查询一个分块内所有 trace 的 spans，按 trace_id 分组
+//
+// 结果按 trace_id 排序，达到 traceFetchMaxSpans 时最后一个 trace 可能不完整，
+// 排在它之后的 trace 则完全没有读取：丢弃最后一个 trace 后对剩余的 ID 再次查询。
+// 单个 trace 就超过上限时只返回前 traceFetchMaxSpans 个 span，并在 Warnings 中说明。
+func (r *MySQLSpanReader) fetchTraceChunk(ctx context.Context, traceIDs []string) (map[string]*model.Trace, error) {
+	traces := make(map[string]*model.Trace, len(traceIDs))
+	remaining := traceIDs
+	for len(remaining) > 0 {
+		spans, count, err := r.queryTraceSpans(ctx, remaining)
+		if err != nil {
+			return nil, err
+		}
+		if count < traceFetchMaxSpans || len(spans) == 0 {
+			for _, group := range spans {
+				traces[group[0].TraceID.String()] = &model.Trace{Spans: group}
+			}
+			break
+		}
+
+		last := spans[len(spans)-1][0].TraceID.String()
+		if len(spans) == 1 {
+			// 单个 trace 超过上限
+			r.logger.Warn().Str("trace_id", last).Int("limit", traceFetchMaxSpans).
+				Msg("Trace exceeds span limit, truncated")
+			traces[last] = &model.Trace{
+				Spans: spans[0],
+				Warnings: []string{fmt.Sprintf("trace has more than %d spans, later spans are not shown (TRACE_FETCH_MAX_SPANS)",
+					traceFetchMaxSpans)},
+			}
+			remaining = traceIDsAfter(remaining, last, false)
+			continue
+		}
+		// 最后一个 trace 可能不完整，与之后的 trace 一起重新查询
+		for _, group := range spans[:len(spans)-1] {
+			traces[group[0].TraceID.String()] = &model.Trace{Spans: group}
+		}
+		remaining = traceIDsAfter(remaining, last, true)
 	}
+	return traces, nil
+}
 
+// queryTraceSpans 读取一组 trace 的 spans，按 trace_id 分组并保持查询结果的顺序，
+// count 为返回的行数
+func (r *MySQLSpanReader) queryTraceSpans(ctx context.Context, traceIDs []string) ([][]*model.Span, int, error) {
 	// 构建 IN 查询（ManticoreSearch 支持 IN）
 	placeholders := make([]string, len(traceIDs))
 	args := make([]interface{}, len(traceIDs))
@@ -634,88 +920,86 @@ func (r *MySQLSpanReader) getTracesByIDs(ctx context.Context, traceIDs []string)
 		FROM jaeger_spans
 		WHERE trace_id IN (%s)
 		ORDER BY trace_id ASC, start_time ASC
-	`, strings.Join(placeholders, ", "))
+		LIMIT %d OPTION max_matches=%d
+	`, strings.Join(placeholders, ", "), traceFetchMaxSpans, traceFetchMaxSpans)
 
 	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("batch query failed: %w", err)
+		return nil, 0, fmt.Errorf("batch query failed: %w", err)
 	}
 	defer rows.Close()
 
-	// 按 trace_id 分组
-	traceMap := make(map[string][]*model.Span)
+	var (
+		groups [][]*model.Span
+		index  = make(map[model.TraceID]int, len(traceIDs))
+		count  int
+	)
 	for rows.Next() {
+		count++
 		span, err := scanSpan(rows)
 		if err != nil {
 			r.logger.Warn().Err(err).Msg("Failed to scan span")
 			continue
 		}
-		traceIDStr := span.TraceID.String()
-		traceMap[traceIDStr] = append(traceMap[traceIDStr], span)
+		i, ok := index[span.TraceID]
+		if !ok {
+			i = len(groups)
+			index[span.TraceID] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], span)
 	}
-
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	return groups, count, nil
+}
 
-	// 按原始顺序构建结果
-	traces := make([]*model.Trace, 0, len(traceIDs))
-	for _, traceIDStr := range traceIDs {
-		if spans, ok := traceMap[traceIDStr]; ok && len(spans) > 0 {
-			traces = append(traces, &model.Trace{Spans: spans})
+// traceIDsAfter 返回按字符串顺序排在 last 之后（inclusive 时包含 last）的 trace ID
+func traceIDsAfter(traceIDs []string, last string, inclusive bool) []string {
+	var out []string
+	for _, id := range traceIDs {
+		if id > last || (inclusive && id == last) {
+			out = append(out, id)
 		}
 	}
-
-	r.logger.Debug().
-		Int("requested", len(traceIDs)).
-		Int("found", len(traces)).
-		Msg("Batch traces loaded")
-
-	return traces, nil
+	return out
 }
 
-func (r *MySQLSpanReader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
-	// 优化：直接返回 trace IDs，不加载完整 traces
-	sqlQuery := `
-		SELECT trace_id, MAX(start_time) as max_start_time
-		FROM jaeger_spans
-		WHERE service_name = ?
-			AND start_time >= ?
-			AND start_time <= ?
-	`
-	args := []interface{}{
-		query.ServiceName,
-		query.StartTimeMin.UnixNano(),
-		query.StartTimeMax.UnixNano(),
+// chunkStrings 将切片按 size 切分为多个子切片，size <= 0 时不切分
+func chunkStrings(items []string, size int) [][]string {
+	if len(items) == 0 {
+		return nil
 	}
-
-	if query.OperationName != "" {
-		sqlQuery += " AND operation_name = ?"
-		args = append(args, query.OperationName)
+	if size < 1 {
+		size = len(items)
 	}
+	chunks := make([][]string, 0, (len(items)+size-1)/size)
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		chunks = append(chunks, items[start:end])
+	}
+	return chunks
+}
 
-	sqlQuery += " GROUP BY trace_id ORDER BY max_start_time DESC LIMIT ?"
-	args = append(args, query.NumTraces)
-
-	rows, err := r.db.QueryContext(ctx, sqlQuery, args...)
+func (r *MySQLSpanReader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
+	// 优化：直接返回 trace IDs，不加载完整 traces
+	traceIDStrs, err := r.findTraceIDStrings(ctx, query)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var traceIDs []model.TraceID
-	for rows.Next() {
-		var traceIDStr string
-		var maxStartTime int64
-		if err := rows.Scan(&traceIDStr, &maxStartTime); err != nil {
-			continue
-		}
+	traceIDs := make([]model.TraceID, 0, len(traceIDStrs))
+	for _, traceIDStr := range traceIDStrs {
 		if traceID, err := model.TraceIDFromString(traceIDStr); err == nil {
 			traceIDs = append(traceIDs, traceID)
 		}
 	}
 
-	return traceIDs, rows.Err()
+	return traceIDs, nil
 }
 
 // ====================
@@ -723,7 +1007,7 @@ func (r *MySQLSpanReader) FindTraceIDs(ctx context.Context, query *spanstore.Tra
 // ====================
 
 type MySQLDependencyReader struct {
-	db     *sql.DB
+	store  *MySQLStore
 	logger zerolog.Logger
 }
 
@@ -735,80 +1019,21 @@ func (r *MySQLDependencyReader) GetDependencies(ctx context.Context, endTs time.
 
 	startTs := endTs.Add(-lookback)
 
-	// 查询所有 spans 的父子关系
-	query := `
-		SELECT trace_id, span_id, refs, service_name
-		FROM jaeger_spans
-		WHERE start_time >= ? AND start_time <= ?
-	`
-
-	rows, err := r.db.QueryContext(ctx, query, startTs.UnixNano(), endTs.UnixNano())
+	var (
+		counts dependencyCounts
+		err    error
+	)
+	if dependenciesJobEnabled {
+		// 读取后台任务预聚合的结果，窗口中未聚合的部分现算
+		counts, err = readDependencies(ctx, r.store, startTs, endTs)
+	} else {
+		counts, err = countDependencies(ctx, r.store, startTs, startTs, endTs.Add(time.Nanosecond))
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	// 构建 span -> service 映射
-	type spanInfo struct {
-		traceID     string
-		serviceName string
-		parentSpans []string
-	}
-	spanMap := make(map[string]*spanInfo) // key: traceID:spanID
-
-	for rows.Next() {
-		var traceID, spanID, refsJSON, serviceName string
-		if err := rows.Scan(&traceID, &spanID, &refsJSON, &serviceName); err != nil {
-			continue
-		}
-
-		key := traceID + ":" + spanID
-		info := &spanInfo{
-			traceID:     traceID,
-			serviceName: serviceName,
-		}
-
-		// 解析 refs 获取父 span
-		var refs []model.SpanRef
-		if err := json.Unmarshal([]byte(refsJSON), &refs); err == nil {
-			for _, ref := range refs {
-				if ref.RefType == model.SpanRefType_CHILD_OF {
-					parentKey := traceID + ":" + ref.SpanID.String()
-					info.parentSpans = append(info.parentSpans, parentKey)
-				}
-			}
-		}
-
-		spanMap[key] = info
-	}
-
-	// 统计依赖关系
-	depCount := make(map[string]uint64) // key: parent_service -> child_service
-
-	for _, info := range spanMap {
-		for _, parentKey := range info.parentSpans {
-			if parentInfo, ok := spanMap[parentKey]; ok {
-				if parentInfo.serviceName != info.serviceName {
-					depKey := parentInfo.serviceName + " -> " + info.serviceName
-					depCount[depKey]++
-				}
-			}
-		}
-	}
-
-	// 转换为 DependencyLink
-	var deps []model.DependencyLink
-	for key, count := range depCount {
-		parts := strings.Split(key, " -> ")
-		if len(parts) == 2 {
-			deps = append(deps, model.DependencyLink{
-				Parent:    parts[0],
-				Child:     parts[1],
-				CallCount: count,
-			})
-		}
-	}
 
+	deps := counts.links()
 	r.logger.Debug().Int("count", len(deps)).Msg("Dependencies calculated")
 	return deps, nil
 }
@@ -830,7 +1055,8 @@ func scanSpans(rows *sql.Rows) ([]*model.Span, error) {
 	return spans, rows.Err()
 }
 
-func scanSpan(rows *sql.Rows) (*model.Span, error) {
+// scanSpan 扫描 spanColumns 对应的一行，extra 接收其后追加的列
+func scanSpan(rows *sql.Rows, extra ...interface{}) (*model.Span, error) {
 	var (
 		traceIDStr  string
 		spanIDStr   string
@@ -845,11 +1071,12 @@ func scanSpan(rows *sql.Rows) (*model.Span, error) {
 		serviceName string
 	)
 
-	err := rows.Scan(
+	dest := append([]interface{}{
 		&traceIDStr, &spanIDStr, &opName, &flags,
 		&startTime, &duration, &tagsJSON, &logsJSON,
 		&refsJSON, &processJSON, &serviceName,
-	)
+	}, extra...)
+	err := rows.Scan(dest...)
 	if err != nil {
 		return nil, err
 	}
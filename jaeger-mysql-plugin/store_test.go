@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func TestChunkStrings(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+
+	got := chunkStrings(items, 2)
+	want := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("chunkStrings(5, 2) = %v, want %v", got, want)
+	}
+
+	if got := chunkStrings(items, 0); len(got) != 1 || len(got[0]) != 5 {
+		t.Fatalf("chunkStrings(5, 0) = %v, want single chunk", got)
+	}
+
+	if got := chunkStrings(nil, 3); len(got) != 0 {
+		t.Fatalf("chunkStrings(nil) = %v, want empty", got)
+	}
+	if got := chunkStrings(nil, 0); len(got) != 0 {
+		t.Fatalf("chunkStrings(nil, 0) = %v, want empty", got)
+	}
+}
+
+// setTraceFetchChunking 临时修改分块大小和并发数
+func setTraceFetchChunking(t *testing.T, size, concurrency int) {
+	oldSize, oldConcurrency := traceFetchChunkSize, traceFetchConcurrency
+	traceFetchChunkSize, traceFetchConcurrency = size, concurrency
+	t.Cleanup(func() { traceFetchChunkSize, traceFetchConcurrency = oldSize, oldConcurrency })
+}
+
+func TestGetTracesByIDsMergesChunks(t *testing.T) {
+	setTraceFetchChunking(t, 2, 2)
+	f, reader := newTestReader(t)
+	f.onQuery("WHERE trace_id IN", spanColumns, func(_ context.Context, args []interface{}) ([][]interface{}, error) {
+		// 按与请求相反的顺序返回，结果顺序应由调用方决定
+		var rows [][]interface{}
+		for i := len(args) - 1; i >= 0; i-- {
+			traceID, _ := model.TraceIDFromString(args[i].(string))
+			rows = append(rows, spanRow(pendingSpan(traceID, 1, time.Unix(10, 0))))
+		}
+		return rows, nil
+	})
+
+	var ids []string
+	for i := uint64(1); i <= 5; i++ {
+		ids = append(ids, model.NewTraceID(0, i).String())
+	}
+	traces, err := reader.getTracesByIDs(context.Background(), ids)
+	if err != nil {
+		t.Fatalf("getTracesByIDs: %v", err)
+	}
+	if len(traces) != len(ids) {
+		t.Fatalf("got %d traces, want %d", len(traces), len(ids))
+	}
+	for i, trace := range traces {
+		if got := trace.Spans[0].TraceID.String(); got != ids[i] {
+			t.Errorf("trace %d = %s, want %s", i, got, ids[i])
+		}
+	}
+	if n := len(f.matching("WHERE trace_id IN")); n != 3 {
+		t.Errorf("executed %d chunk queries, want 3", n)
+	}
+}
+
+func TestGetTracesByIDsCancelsOnError(t *testing.T) {
+	setTraceFetchChunking(t, 1, 3)
+	f, reader := newTestReader(t)
+	failing := model.NewTraceID(0, 2).String()
+	queryErr := errors.New("connection reset")
+	f.onQuery("WHERE trace_id IN", spanColumns, func(ctx context.Context, args []interface{}) ([][]interface{}, error) {
+		if args[0] == failing {
+			return nil, queryErr
+		}
+		// 其余分块阻塞直到被取消
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("chunk was not cancelled")
+		}
+	})
+
+	ids := []string{model.NewTraceID(0, 1).String(), failing, model.NewTraceID(0, 3).String()}
+	_, err := reader.getTracesByIDs(context.Background(), ids)
+	if !errors.Is(err, queryErr) {
+		t.Errorf("error = %v, want the failing chunk's error", err)
+	}
+}
+
+func TestGetTracesByIDsSpanLimit(t *testing.T) {
+	setTraceFetchChunking(t, 10, 1)
+	defer func(v int) { traceFetchMaxSpans = v }(traceFetchMaxSpans)
+	traceFetchMaxSpans = 3
+
+	// t1 有 2 个 span，t2 有 4 个（超过上限），t3 有 1 个
+	spanCounts := []int{2, 4, 1}
+	var ids []string
+	for i := range spanCounts {
+		ids = append(ids, model.NewTraceID(0, uint64(i+1)).String())
+	}
+	f, reader := newTestReader(t)
+	f.onFunc("WHERE trace_id IN", spanColumns, func(args []interface{}) [][]interface{} {
+		// 模拟按 trace_id 排序并截断到上限
+		var rows [][]interface{}
+		for i, id := range ids {
+			for _, arg := range args {
+				if arg != id {
+					continue
+				}
+				traceID, _ := model.TraceIDFromString(id)
+				for j := 0; j < spanCounts[i]; j++ {
+					rows = append(rows, spanRow(pendingSpan(traceID, uint64(j+1), time.Unix(int64(10+j), 0))))
+				}
+			}
+		}
+		if len(rows) > traceFetchMaxSpans {
+			rows = rows[:traceFetchMaxSpans]
+		}
+		return rows
+	})
+
+	traces, err := reader.getTracesByIDs(context.Background(), ids)
+	if err != nil {
+		t.Fatalf("getTracesByIDs: %v", err)
+	}
+	if len(traces) != 3 {
+		t.Fatalf("got %d traces, want 3", len(traces))
+	}
+	// 被上限截断的 t2 重新单独查询，之后的 t3 仍然返回
+	for i, want := range []int{2, 3, 1} {
+		if got := len(traces[i].Spans); got != want {
+			t.Errorf("trace %d has %d spans, want %d", i, got, want)
+		}
+	}
+	if len(traces[0].Warnings) != 0 || len(traces[1].Warnings) != 1 || len(traces[2].Warnings) != 0 {
+		t.Errorf("only the oversized trace should carry a warning: %v / %v / %v",
+			traces[0].Warnings, traces[1].Warnings, traces[2].Warnings)
+	}
+	if n := len(f.matching("WHERE trace_id IN")); n != 3 {
+		t.Errorf("executed %d queries, want 3", n)
+	}
+}
+
+func TestMarshalTagKV(t *testing.T) {
+	got := marshalTagKV([]model.KeyValue{
+		model.String("http.method", "GET"),
+		model.Int64("http.status_code", 500),
+		model.Bool("error", true),
+	})
+	want := "http.method=GET\nhttp.status_code=500\nerror=true"
+	if got != want {
+		t.Errorf("marshalTagKV = %q, want %q", got, want)
+	}
+
+	if got := marshalTagKV(nil); got != "" {
+		t.Errorf("marshalTagKV(nil) = %q, want empty", got)
+	}
+}
+
+func TestMarshalTagAttrs(t *testing.T) {
+	got := marshalTagAttrs([]model.KeyValue{
+		model.String("http.method", "GET"),
+		model.Int64("http.status_code", 500),
+		model.Float64("ratio", 0.25),
+		model.Bool("error", true),
+	})
+	want := `{"error":"true","http.method":"GET","http.status_code":500,"ratio":0.25}`
+	if got != want {
+		t.Errorf("marshalTagAttrs = %s, want %s", got, want)
+	}
+}
+
+func TestSpanKindOf(t *testing.T) {
+	tests := []struct {
+		tags []model.KeyValue
+		want string
+	}{
+		{[]model.KeyValue{model.String("span.kind", "server")}, "server"},
+		{[]model.KeyValue{model.String("span.kind", "bogus")}, ""},
+		{[]model.KeyValue{model.String("http.method", "GET")}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := spanKindOf(tt.tags); got != tt.want {
+			t.Errorf("spanKindOf(%v) = %q, want %q", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestGetOperationsSpanKind(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("FROM jaeger_operations", []string{"operation_name", "span_kind"},
+		[]interface{}{"GET /orders", "server"},
+	)
+
+	ops, err := reader.GetOperations(context.Background(), spanstore.OperationQueryParameters{
+		ServiceName: "order-service",
+		SpanKind:    "server",
+	})
+	if err != nil {
+		t.Fatalf("GetOperations: %v", err)
+	}
+	want := []spanstore.Operation{{Name: "GET /orders", SpanKind: "server"}}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("GetOperations = %v, want %v", ops, want)
+	}
+
+	q := f.matching("FROM jaeger_operations")
+	if len(q) != 1 || q[0].args[0] != "order-service" || q[0].args[2] != "server" {
+		t.Errorf("queries = %v, want filter on service and span kind", q)
+	}
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// Tag 查询语言
+// ====================
+//
+// Jaeger UI 的 Tags 输入框只支持 key=value 的 AND 组合，这里在其基础上支持：
+//
+//	http.status_code>=500
+//	error=true OR level=error
+//	NOT peer.service=redis
+//	http.url=/orders/*            （前缀匹配，仅对未加引号的值生效）
+//	(a=1 OR b=2) AND c="x y"
+//
+// 完整表达式写在保留 key（默认 query）中，例如在 UI 中输入：
+//
+//	query="http.status_code>=500 OR error=true"
+//
+// 普通 key=value 仍然可用；UI 按 logfmt 拆分 "http.status_code>=500" 得到的
+// key "http.status_code>" 也会被还原为比较条件。
+//
+// 编译规则：顶层 AND 中只包含等值条件（及其 OR 组合）的部分转换为 tag_kv 字段上的
+// MATCH 短语查询（走全文索引）；比较、取反和前缀条件转换为 tag_attrs JSON 属性过滤。
+//
+// 与 Jaeger 的 tag 过滤一致，整个表达式在单个 span 上求值，trace 中任一 span 满足即返回。
+// 因此 NOT peer.service=redis 表示"存在一个 peer.service 不是 redis 的 span"，
+// 而不是"trace 中没有调用 redis"，几乎对所有 trace 都成立；取反条件宜与其他条件
+// 组合使用，如 span.kind=client NOT peer.service=redis。
+//
+// 没有该 key 的 span 满足 key!=value（tag_attrs 上的 != 对缺失的 key 成立），
+// 不满足 = 和比较运算；evalTagNode 对尚未写入的 span 按相同规则求值。
+
+// tagQueryKey 用于承载完整 tag 查询表达式的保留 key
+var tagQueryKey = getStringEnv("TAG_QUERY_KEY", "query")
+
+// tagNodeKind 表达式节点类型
+type tagNodeKind int
+
+const (
+	tagCond tagNodeKind = iota
+	tagAnd
+	tagOr
+	tagNot
+)
+
+// tagNode 是 tag 查询表达式的语法树节点
+type tagNode struct {
+	kind     tagNodeKind
+	children []*tagNode
+
+	// 仅 tagCond 使用
+	key    string
+	op     string // = != > >= < <=
+	value  string
+	quoted bool // 值是否带引号（带引号时不做数字和前缀解析）
+	prefix bool // 值以 * 结尾的前缀匹配
+}
+
+// tagQueryError 描述 tag 查询的语法错误
+type tagQueryError struct {
+	input string
+	pos   int
+	msg   string
+}
+
+func (e *tagQueryError) Error() string {
+	return fmt.Sprintf("invalid tag query %q at position %d: %s", e.input, e.pos, e.msg)
+}
+
+// ====================
+// 词法分析
+// ====================
+
+type tagTokenKind int
+
+const (
+	tokEOF tagTokenKind = iota
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+	tokCond
+)
+
+type tagToken struct {
+	kind tagTokenKind
+	pos  int
+	cond *tagNode
+}
+
+type tagLexer struct {
+	input string
+	pos   int
+}
+
+// isSpaceByte 判断 ASCII 空白字符（按字节扫描，避免把 UTF-8 多字节字符误判为空白）
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isTagKeyByte 判断字节能否出现在 tag key 中
+func isTagKeyByte(c byte) bool {
+	return !isSpaceByte(c) && strings.IndexByte(`()=!<>"`, c) < 0
+}
+
+func (l *tagLexer) errorf(pos int, format string, args ...interface{}) error {
+	return &tagQueryError{input: l.input, pos: pos, msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *tagLexer) skipSpace() {
+	for l.pos < len(l.input) && isSpaceByte(l.input[l.pos]) {
+		l.pos++
+	}
+}
+
+func (l *tagLexer) next() (tagToken, error) {
+	l.skipSpace()
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return tagToken{kind: tokEOF, pos: start}, nil
+	}
+
+	switch l.input[l.pos] {
+	case '(':
+		l.pos++
+		return tagToken{kind: tokLParen, pos: start}, nil
+	case ')':
+		l.pos++
+		return tagToken{kind: tokRParen, pos: start}, nil
+	}
+
+	// 读取 key（或关键字）
+	end := l.pos
+	for end < len(l.input) && isTagKeyByte(l.input[end]) {
+		end++
+	}
+	word := l.input[l.pos:end]
+	if word == "" {
+		return tagToken{}, l.errorf(start, "unexpected character %q", l.input[l.pos])
+	}
+	l.pos = end
+
+	op := l.readOperator()
+	if op == "" {
+		switch strings.ToUpper(word) {
+		case "AND":
+			return tagToken{kind: tokAnd, pos: start}, nil
+		case "OR":
+			return tagToken{kind: tokOr, pos: start}, nil
+		case "NOT":
+			return tagToken{kind: tokNot, pos: start}, nil
+		}
+		return tagToken{}, l.errorf(end, "expected comparison operator (=, !=, >, >=, <, <=) after %q", word)
+	}
+
+	cond := &tagNode{kind: tagCond, key: word, op: op}
+	if err := l.readValue(cond); err != nil {
+		return tagToken{}, err
+	}
+	return tagToken{kind: tokCond, pos: start, cond: cond}, nil
+}
+
+// readOperator 读取比较运算符，不存在时返回空字符串
+func (l *tagLexer) readOperator() string {
+	for _, op := range []string{"!=", ">=", "<=", "=", ">", "<"} {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+// readValue 读取条件的值：带引号的字符串（支持 \" 和 \\ 转义）或不含空白和括号的单词
+func (l *tagLexer) readValue(cond *tagNode) error {
+	start := l.pos
+	if l.pos < len(l.input) && l.input[l.pos] == '"' {
+		var sb strings.Builder
+		l.pos++
+		for l.pos < len(l.input) {
+			c := l.input[l.pos]
+			switch {
+			case c == '\\' && l.pos+1 < len(l.input):
+				sb.WriteByte(l.input[l.pos+1])
+				l.pos += 2
+			case c == '"':
+				l.pos++
+				cond.value = sb.String()
+				cond.quoted = true
+				return nil
+			default:
+				sb.WriteByte(c)
+				l.pos++
+			}
+		}
+		return l.errorf(start, "unterminated quoted value")
+	}
+
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		if isSpaceByte(c) || c == '(' || c == ')' {
+			break
+		}
+		l.pos++
+	}
+	cond.value = l.input[start:l.pos]
+	if cond.value == "" {
+		return l.errorf(start, "missing value for %q", cond.key)
+	}
+	if strings.HasSuffix(cond.value, "*") {
+		cond.value = strings.TrimSuffix(cond.value, "*")
+		cond.prefix = true
+		if cond.value == "" {
+			return l.errorf(start, "prefix value for %q must not be empty", cond.key)
+		}
+	}
+	return nil
+}
+
+// ====================
+// 语法分析
+// ====================
+//
+//	expr  := and ( OR and )*
+//	and   := unary ( [AND] unary )*
+//	unary := NOT unary | "(" expr ")" | cond
+
+type tagParser struct {
+	lexer *tagLexer
+	tok   tagToken
+}
+
+// parseTagQuery 解析 tag 查询表达式
+func parseTagQuery(input string) (*tagNode, error) {
+	p := &tagParser{lexer: &tagLexer{input: input}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokEOF {
+		return nil, p.lexer.errorf(0, "empty expression")
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.lexer.errorf(p.tok.pos, "unexpected %s", p.describe())
+	}
+	return node, nil
+}
+
+func (p *tagParser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *tagParser) describe() string {
+	switch p.tok.kind {
+	case tokEOF:
+		return "end of input"
+	case tokLParen:
+		return `"("`
+	case tokRParen:
+		return `")"`
+	case tokAnd:
+		return "AND"
+	case tokOr:
+		return "OR"
+	case tokNot:
+		return "NOT"
+	default:
+		return fmt.Sprintf("condition on %q", p.tok.cond.key)
+	}
+}
+
+func (p *tagParser) parseOr() (*tagNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []*tagNode{left}
+	for p.tok.kind == tokOr {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, right)
+	}
+	if len(children) == 1 {
+		return left, nil
+	}
+	return &tagNode{kind: tagOr, children: children}, nil
+}
+
+func (p *tagParser) parseAnd() (*tagNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	children := []*tagNode{left}
+	for {
+		switch p.tok.kind {
+		case tokAnd:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		case tokNot, tokLParen, tokCond:
+			// 相邻条件隐式 AND
+		default:
+			if len(children) == 1 {
+				return left, nil
+			}
+			return &tagNode{kind: tagAnd, children: children}, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, right)
+	}
+}
+
+func (p *tagParser) parseUnary() (*tagNode, error) {
+	switch p.tok.kind {
+	case tokNot:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &tagNode{kind: tagNot, children: []*tagNode{child}}, nil
+	case tokLParen:
+		pos := p.tok.pos
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.lexer.errorf(pos, "missing closing parenthesis")
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case tokCond:
+		node := p.tok.cond
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return node, nil
+	default:
+		return nil, p.lexer.errorf(p.tok.pos, "expected condition, got %s", p.describe())
+	}
+}
+
+// ====================
+// 编译为 ManticoreSearch 查询
+// ====================
+
+// tagFilter 是 Tags 过滤条件编译后的结果
+type tagFilter struct {
+	match *matchQuery   // tag_kv 字段上的全文查询
+	exprs []string      // tag_attrs 属性过滤表达式（AND 组合）
+	args  []interface{} // exprs 中占位符对应的参数
+}
+
+// parseTagFilters 将 TraceQueryParameters.Tags 解析为 tagFilter
+func parseTagFilters(tags map[string]string) (*tagFilter, error) {
+	conjuncts, err := parseTagConjuncts(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &tagFilter{match: newMatchQuery("tag_kv")}
+	for _, node := range conjuncts {
+		if isTextNode(node) {
+			f.match.addRaw(textExpr(node))
+			continue
+		}
+		expr, args, err := attrExpr(node)
+		if err != nil {
+			return nil, err
+		}
+		f.exprs = append(f.exprs, expr)
+		f.args = append(f.args, args...)
+	}
+	return f, nil
+}
+
+// parseTagConjuncts 将 Tags 解析为 AND 组合的条件列表（按 key 排序）
+func parseTagConjuncts(tags map[string]string) ([]*tagNode, error) {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var conjuncts []*tagNode
+	for _, key := range keys {
+		value := tags[key]
+		var (
+			node *tagNode
+			err  error
+		)
+		switch {
+		case key == errorFilterKey:
+			// 由 errorFilterOf 单独处理，走 is_error 属性
+			continue
+		case key == tagQueryKey:
+			node, err = parseTagQuery(value)
+		case strings.HasSuffix(key, ">") || strings.HasSuffix(key, "<") || strings.HasSuffix(key, "!"):
+			// logfmt 按 "=" 拆分 "a>=1" 得到 key "a>"，还原为完整条件
+			node, err = parseTagQuery(key + "=" + value)
+		default:
+			node = &tagNode{kind: tagCond, key: key, op: "=", value: value, quoted: true}
+			if strings.HasSuffix(value, "*") && len(value) > 1 {
+				node.value = strings.TrimSuffix(value, "*")
+				node.quoted = false
+				node.prefix = true
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		conjuncts = append(conjuncts, flattenAnd(node)...)
+	}
+	return conjuncts, nil
+}
+
+// flattenAnd 将 AND 节点展开为条件列表
+func flattenAnd(node *tagNode) []*tagNode {
+	if node.kind != tagAnd {
+		return []*tagNode{node}
+	}
+	var out []*tagNode
+	for _, child := range node.children {
+		out = append(out, flattenAnd(child)...)
+	}
+	return out
+}
+
+// isTextNode 判断节点能否完全用 tag_kv 全文查询表达（只包含等值条件的 AND/OR 组合）
+func isTextNode(node *tagNode) bool {
+	switch node.kind {
+	case tagCond:
+		return node.op == "=" && !node.prefix
+	case tagAnd, tagOr:
+		for _, child := range node.children {
+			if !isTextNode(child) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// textExpr 生成全文查询片段
+func textExpr(node *tagNode) string {
+	switch node.kind {
+	case tagCond:
+		if node.value == "" {
+			// 只要求 key 存在
+			return matchTerm(node.key)
+		}
+		return matchPhrase(node.key, node.value)
+	case tagAnd, tagOr:
+		sep := " "
+		if node.kind == tagOr {
+			sep = " | "
+		}
+		parts := make([]string, len(node.children))
+		for i, child := range node.children {
+			parts[i] = textExpr(child)
+		}
+		return "(" + strings.Join(parts, sep) + ")"
+	}
+	return ""
+}
+
+// attrExpr 生成 tag_attrs 上的属性过滤表达式
+func attrExpr(node *tagNode) (string, []interface{}, error) {
+	switch node.kind {
+	case tagNot:
+		expr, args, err := attrExpr(node.children[0])
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT " + expr, args, nil
+	case tagAnd, tagOr:
+		sep := " AND "
+		if node.kind == tagOr {
+			sep = " OR "
+		}
+		parts := make([]string, len(node.children))
+		var args []interface{}
+		for i, child := range node.children {
+			expr, childArgs, err := attrExpr(child)
+			if err != nil {
+				return "", nil, err
+			}
+			parts[i] = expr
+			args = append(args, childArgs...)
+		}
+		return "(" + strings.Join(parts, sep) + ")", args, nil
+	}
+
+	// tagCond
+	if node.prefix {
+		pattern := "^" + regexp.QuoteMeta(node.value)
+		switch node.op {
+		case "=":
+			return "REGEX(tag_attrs[?], ?)", []interface{}{node.key, pattern}, nil
+		case "!=":
+			return "NOT REGEX(tag_attrs[?], ?)", []interface{}{node.key, pattern}, nil
+		default:
+			return "", nil, fmt.Errorf("invalid tag query: operator %s does not support prefix value %q", node.op, node.value+"*")
+		}
+	}
+
+	value := tagLiteral(node)
+	switch node.op {
+	case "=", "!=":
+		if _, ok := value.(string); ok {
+			return "(tag_attrs[?] " + node.op + " ?)", []interface{}{node.key, value}, nil
+		}
+		// 数值 tag 可能被客户端以字符串上报（如 http.status_code="200"），同时比较数值和字符串形式
+		args := []interface{}{node.key, value, node.key, node.value}
+		if node.op == "=" {
+			return "(tag_attrs[?] = ? OR tag_attrs[?] = ?)", args, nil
+		}
+		return "(tag_attrs[?] != ? AND tag_attrs[?] != ?)", args, nil
+	default:
+		if _, ok := value.(string); ok {
+			return "", nil, fmt.Errorf("invalid tag query: operator %s on %q requires a numeric value, got %q", node.op, node.key, node.value)
+		}
+		return "(tag_attrs[?] " + node.op + " ?)", []interface{}{node.key, value}, nil
+	}
+}
+
+// tagLiteral 将条件值转换为 SQL 字面量：未加引号的数字转换为数值，其余为字符串
+func tagLiteral(node *tagNode) interface{} {
+	if !node.quoted {
+		if i, err := strconv.ParseInt(node.value, 10, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(node.value, 64); err == nil {
+			return f
+		}
+	}
+	return node.value
+}
+
+// ====================
+// 内存求值（用于尚未写入数据库的 span）
+// ====================
+
+// evalTagNode 在 span tags 上对表达式求值，语义与编译后的 SQL 一致
+func evalTagNode(node *tagNode, tags []model.KeyValue) bool {
+	switch node.kind {
+	case tagNot:
+		return !evalTagNode(node.children[0], tags)
+	case tagAnd:
+		for _, child := range node.children {
+			if !evalTagNode(child, tags) {
+				return false
+			}
+		}
+		return true
+	case tagOr:
+		for _, child := range node.children {
+			if evalTagNode(child, tags) {
+				return true
+			}
+		}
+		return false
+	}
+
+	kv, ok := model.KeyValues(tags).FindByKey(node.key)
+	if !ok {
+		// 与 tag_attrs 上的 SQL 一致：缺失的 key 只满足 !=
+		return node.op == "!="
+	}
+	actual := kv.AsString()
+
+	switch node.op {
+	case "=", "!=":
+		var eq bool
+		switch {
+		case node.prefix:
+			eq = strings.HasPrefix(actual, node.value)
+		case node.value == "":
+			eq = true
+		default:
+			eq = actual == node.value
+		}
+		return eq == (node.op == "=")
+	}
+
+	// 比较运算只对数值生效
+	if _, isString := tagLiteral(node).(string); isString {
+		return false
+	}
+	a, err := strconv.ParseFloat(actual, 64)
+	if err != nil {
+		return false
+	}
+	b, _ := strconv.ParseFloat(node.value, 64)
+	switch node.op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return false
+}
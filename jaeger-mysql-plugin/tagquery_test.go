@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTagQueryErrors(t *testing.T) {
+	tests := map[string]string{
+		"":                  "empty expression",
+		"error":             "expected comparison operator",
+		"error=":            "missing value",
+		"a=1 OR":            "expected condition",
+		"(a=1 OR b=2":       "missing closing parenthesis",
+		"a=1)":              `unexpected ")"`,
+		`msg="unterminated`: "unterminated quoted value",
+		"http.url=*":        "must not be empty",
+		"NOT":               "expected condition",
+		"a=1 AND AND b=2":   "expected condition",
+	}
+	for input, want := range tests {
+		_, err := parseTagQuery(input)
+		if err == nil {
+			t.Errorf("parseTagQuery(%q): expected error", input)
+			continue
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("parseTagQuery(%q) error = %q, want it to contain %q", input, err, want)
+		}
+	}
+}
+
+func TestParseTagQueryPrecedence(t *testing.T) {
+	// AND 优先级高于 OR，相邻条件隐式 AND
+	node, err := parseTagQuery(`a=1 b=2 OR NOT c="x y" AND d=foo*`)
+	if err != nil {
+		t.Fatalf("parseTagQuery: %v", err)
+	}
+	if node.kind != tagOr || len(node.children) != 2 {
+		t.Fatalf("root = %+v, want OR with 2 children", node)
+	}
+
+	left, right := node.children[0], node.children[1]
+	if left.kind != tagAnd || len(left.children) != 2 {
+		t.Errorf("left = %+v, want AND of 2", left)
+	}
+	if right.kind != tagAnd || right.children[0].kind != tagNot {
+		t.Fatalf("right = %+v, want AND starting with NOT", right)
+	}
+
+	c := right.children[0].children[0]
+	if c.key != "c" || c.value != "x y" || !c.quoted {
+		t.Errorf("quoted cond = %+v", c)
+	}
+	d := right.children[1]
+	if d.key != "d" || d.value != "foo" || !d.prefix {
+		t.Errorf("prefix cond = %+v", d)
+	}
+}
+
+func TestParseTagFiltersTextAndAttributes(t *testing.T) {
+	f, err := parseTagFilters(map[string]string{
+		"query": `(error=true OR level=error) NOT peer.service=redis http.url=/orders/* latency<0.5`,
+	})
+	if err != nil {
+		t.Fatalf("parseTagFilters: %v", err)
+	}
+
+	if got, want := f.match.String(), `@tag_kv ("error true" | "level error")`; got != want {
+		t.Errorf("match = %q, want %q", got, want)
+	}
+
+	wantExprs := []string{
+		"NOT (tag_attrs[?] = ?)",
+		"REGEX(tag_attrs[?], ?)",
+		"(tag_attrs[?] < ?)",
+	}
+	if !reflect.DeepEqual(f.exprs, wantExprs) {
+		t.Errorf("exprs = %q, want %q", f.exprs, wantExprs)
+	}
+
+	wantArgs := []interface{}{
+		"peer.service", "redis",
+		"http.url", `^/orders/`,
+		"latency", 0.5,
+	}
+	if !reflect.DeepEqual(f.args, wantArgs) {
+		t.Errorf("args = %#v, want %#v", f.args, wantArgs)
+	}
+}
+
+func TestParseTagFiltersLogfmtComparison(t *testing.T) {
+	f, err := parseTagFilters(map[string]string{"http.status_code>": "500"})
+	if err != nil {
+		t.Fatalf("parseTagFilters: %v", err)
+	}
+	if !f.match.empty() {
+		t.Errorf("match = %q, want empty", f.match.String())
+	}
+	if !reflect.DeepEqual(f.exprs, []string{"(tag_attrs[?] >= ?)"}) {
+		t.Errorf("exprs = %q", f.exprs)
+	}
+}
+
+func TestParseTagFiltersNumericEquality(t *testing.T) {
+	f, err := parseTagFilters(map[string]string{"query": `http.status_code=200 OR NOT retries!=0 OR code="404"`})
+	if err != nil {
+		t.Fatalf("parseTagFilters: %v", err)
+	}
+	wantExprs := []string{
+		"((tag_attrs[?] = ? OR tag_attrs[?] = ?) OR NOT (tag_attrs[?] != ? AND tag_attrs[?] != ?) OR (tag_attrs[?] = ?))",
+	}
+	if !reflect.DeepEqual(f.exprs, wantExprs) {
+		t.Errorf("exprs = %q, want %q", f.exprs, wantExprs)
+	}
+	wantArgs := []interface{}{
+		"http.status_code", int64(200), "http.status_code", "200",
+		"retries", int64(0), "retries", "0",
+		"code", "404",
+	}
+	if !reflect.DeepEqual(f.args, wantArgs) {
+		t.Errorf("args = %#v, want %#v", f.args, wantArgs)
+	}
+}
+
+func TestParseTagFiltersRequiresNumericComparison(t *testing.T) {
+	_, err := parseTagFilters(map[string]string{"query": "http.status_code>=high"})
+	if err == nil || !strings.Contains(err.Error(), "requires a numeric value") {
+		t.Errorf("error = %v, want numeric value error", err)
+	}
+
+	_, err = parseTagFilters(map[string]string{"query": `http.status_code>="500"`})
+	if err == nil {
+		t.Error("quoted value should not be treated as numeric")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// trace 对比
+// ====================
+//
+// 比较同一操作的两个 trace（通常是一个慢请求和一个正常请求）。两个 span 树按
+// 服务和操作的路径对齐：同一父节点下 (服务, 操作) 相同的兄弟 span 合并为一个节点，
+// 其子 span 再合并后继续向下对齐，因此循环调用 N 次与 M 次表现为同一节点的数量差异。
+//
+// 每个节点报告两边的 span 数量、总耗时和总 self time（未被子 span 覆盖的时间），
+// self time 的变化通常比总耗时更能定位变慢的位置。只在 B 中出现的节点为 added，
+// 只在 A 中出现的为 missing。
+
+// 节点在两个 trace 中的出现情况
+const (
+	diffStatusBoth    = "both"
+	diffStatusAdded   = "added"   // 只在 B 中
+	diffStatusMissing = "missing" // 只在 A 中
+)
+
+// traceDiffNode 对齐后的一个节点，时间为纳秒
+type traceDiffNode struct {
+	Service       string           `json:"service"`
+	Operation     string           `json:"operation"`
+	Path          string           `json:"path"` // 从根开始的 service:operation 路径，以 / 分隔
+	Status        string           `json:"status"`
+	CountA        int              `json:"countA"`
+	CountB        int              `json:"countB"`
+	CountDelta    int              `json:"countDelta"` // CountB - CountA
+	DurationA     int64            `json:"durationA"`  // 该节点所有 span 的耗时之和
+	DurationB     int64            `json:"durationB"`
+	DurationDelta int64            `json:"durationDelta"`
+	SelfTimeA     int64            `json:"selfTimeA"`
+	SelfTimeB     int64            `json:"selfTimeB"`
+	SelfTimeDelta int64            `json:"selfTimeDelta"`
+	Children      []*traceDiffNode `json:"children,omitempty"`
+}
+
+// traceDiffSide 对比的一侧 trace 的概要
+type traceDiffSide struct {
+	TraceID   string `json:"traceID"`
+	Service   string `json:"service"` // 根 span 的服务
+	Operation string `json:"operation"`
+	Duration  int64  `json:"duration"` // 根 span 耗时
+	SpanCount int    `json:"spanCount"`
+}
+
+// traceDiff 对比结果
+type traceDiff struct {
+	A             traceDiffSide    `json:"a"`
+	B             traceDiffSide    `json:"b"`
+	DurationDelta int64            `json:"durationDelta"` // 根 span 耗时变化
+	AddedSpans    int              `json:"addedSpans"`    // 各节点 CountDelta 中正值之和
+	MissingSpans  int              `json:"missingSpans"`  // 各节点 CountDelta 中负值的绝对值之和
+	Roots         []*traceDiffNode `json:"roots"`
+}
+
+// diffKey 对齐的依据
+type diffKey struct {
+	service   string
+	operation string
+}
+
+func nodeDiffKey(node *spanNode) diffKey {
+	return diffKey{service: node.span.Process.ServiceName, operation: node.span.OperationName}
+}
+
+// groupByDiffKey 按 (服务, 操作) 分组，保持首次出现（即开始时间）的顺序
+func groupByDiffKey(nodes []*spanNode) ([]diffKey, map[diffKey][]*spanNode) {
+	var keys []diffKey
+	groups := make(map[diffKey][]*spanNode)
+	for _, node := range nodes {
+		key := nodeDiffKey(node)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], node)
+	}
+	return keys, groups
+}
+
+// diffSpanNodes 对齐同一层级的两组节点
+func diffSpanNodes(a, b []*spanNode, parentPath string, result *traceDiff) []*traceDiffNode {
+	keysA, groupsA := groupByDiffKey(a)
+	keysB, groupsB := groupByDiffKey(b)
+	// A 中的节点按 A 的顺序在前，只在 B 中的节点按 B 的顺序在后
+	keys := keysA
+	for _, key := range keysB {
+		if _, ok := groupsA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	out := make([]*traceDiffNode, 0, len(keys))
+	for _, key := range keys {
+		nodesA, nodesB := groupsA[key], groupsB[key]
+		node := &traceDiffNode{
+			Service:   key.service,
+			Operation: key.operation,
+			Path:      key.service + ":" + key.operation,
+			Status:    diffStatusBoth,
+			CountA:    len(nodesA),
+			CountB:    len(nodesB),
+		}
+		if parentPath != "" {
+			node.Path = parentPath + "/" + node.Path
+		}
+		switch {
+		case len(nodesA) == 0:
+			node.Status = diffStatusAdded
+		case len(nodesB) == 0:
+			node.Status = diffStatusMissing
+		}
+
+		var childrenA, childrenB []*spanNode
+		for _, n := range nodesA {
+			node.DurationA += n.span.Duration.Nanoseconds()
+			node.SelfTimeA += spanSelfTime(n)
+			childrenA = append(childrenA, n.children...)
+		}
+		for _, n := range nodesB {
+			node.DurationB += n.span.Duration.Nanoseconds()
+			node.SelfTimeB += spanSelfTime(n)
+			childrenB = append(childrenB, n.children...)
+		}
+		node.CountDelta = node.CountB - node.CountA
+		node.DurationDelta = node.DurationB - node.DurationA
+		node.SelfTimeDelta = node.SelfTimeB - node.SelfTimeA
+		if node.CountDelta > 0 {
+			result.AddedSpans += node.CountDelta
+		} else {
+			result.MissingSpans -= node.CountDelta
+		}
+
+		// 合并后的子节点按开始时间重新排序，保证对齐顺序稳定
+		sortSpanNodes(childrenA)
+		sortSpanNodes(childrenB)
+		node.Children = diffSpanNodes(childrenA, childrenB, node.Path, result)
+		out = append(out, node)
+	}
+	return out
+}
+
+// diffTraceSide 返回 trace 的概要，根 span 取最早开始的根节点
+func diffTraceSide(trace *model.Trace, roots []*spanNode) traceDiffSide {
+	side := traceDiffSide{SpanCount: len(trace.Spans)}
+	if len(roots) > 0 {
+		root := roots[0].span
+		side.TraceID = root.TraceID.String()
+		side.Service = root.Process.ServiceName
+		side.Operation = root.OperationName
+		side.Duration = root.Duration.Nanoseconds()
+	}
+	return side
+}
+
+// computeTraceDiff 对比两个 trace
+func computeTraceDiff(a, b *model.Trace) *traceDiff {
+	rootsA, rootsB := buildSpanTree(a), buildSpanTree(b)
+	result := &traceDiff{
+		A: diffTraceSide(a, rootsA),
+		B: diffTraceSide(b, rootsB),
+	}
+	result.DurationDelta = result.B.Duration - result.A.Duration
+	result.Roots = diffSpanNodes(rootsA, rootsB, "", result)
+	return result
+}
+
+// DiffTraces 读取两个 trace 并对比，任一 trace 不存在时返回包装了 spanstore.ErrTraceNotFound 的错误
+func (r *MySQLSpanReader) DiffTraces(ctx context.Context, a, b model.TraceID) (*traceDiff, error) {
+	started := time.Now()
+	traceA, err := r.GetTrace(ctx, a)
+	if err != nil {
+		return nil, fmt.Errorf("trace %s: %w", a, err)
+	}
+	traceB, err := r.GetTrace(ctx, b)
+	if err != nil {
+		return nil, fmt.Errorf("trace %s: %w", b, err)
+	}
+	result := computeTraceDiff(traceA, traceB)
+	r.logger.Debug().Str("trace_a", a.String()).Str("trace_b", b.String()).
+		Int("added", result.AddedSpans).Int("missing", result.MissingSpans).
+		Dur("elapsed", time.Since(started)).Msg("Traces compared")
+	return result, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+// diffNodesByPath 将对比结果展开为 path -> 节点
+func diffNodesByPath(nodes []*traceDiffNode, out map[string]*traceDiffNode) map[string]*traceDiffNode {
+	for _, node := range nodes {
+		out[node.Path] = node
+		diffNodesByPath(node.Children, out)
+	}
+	return out
+}
+
+func TestComputeTraceDiff(t *testing.T) {
+	childOf := model.SpanRefType_CHILD_OF
+	normal := &model.Trace{Spans: []*model.Span{
+		treeSpan(1, 0, childOf, "gateway", "GET /checkout", 0, 50),
+		treeSpan(2, 1, childOf, "order", "load", 5, 20),
+		treeSpan(3, 2, childOf, "mysql", "SELECT", 10, 5),
+		treeSpan(4, 1, childOf, "cache", "GET", 30, 2),
+	}}
+	slow := &model.Trace{Spans: []*model.Span{
+		treeSpan(1, 0, childOf, "gateway", "GET /checkout", 0, 200),
+		treeSpan(2, 1, childOf, "order", "load", 5, 150),
+		// 同一父节点下的重复调用合并为一个节点
+		treeSpan(3, 2, childOf, "mysql", "SELECT", 10, 40),
+		treeSpan(5, 2, childOf, "mysql", "SELECT", 60, 80),
+		treeSpan(6, 1, childOf, "auth", "verify", 160, 10),
+	}}
+
+	diff := computeTraceDiff(normal, slow)
+	if diff.A.Duration != (50*time.Millisecond).Nanoseconds() || diff.B.SpanCount != 5 {
+		t.Errorf("unexpected sides %+v %+v", diff.A, diff.B)
+	}
+	if diff.DurationDelta != (150 * time.Millisecond).Nanoseconds() {
+		t.Errorf("duration delta = %v", time.Duration(diff.DurationDelta))
+	}
+	if diff.AddedSpans != 2 || diff.MissingSpans != 1 {
+		t.Errorf("added = %d, missing = %d, want 2 and 1", diff.AddedSpans, diff.MissingSpans)
+	}
+
+	nodes := diffNodesByPath(diff.Roots, make(map[string]*traceDiffNode))
+	mysql := nodes["gateway:GET /checkout/order:load/mysql:SELECT"]
+	if mysql == nil || mysql.Status != diffStatusBoth || mysql.CountA != 1 || mysql.CountB != 2 || mysql.CountDelta != 1 {
+		t.Fatalf("unexpected mysql node %+v", mysql)
+	}
+	if mysql.DurationDelta != (115 * time.Millisecond).Nanoseconds() {
+		t.Errorf("mysql duration delta = %v, want 115ms", time.Duration(mysql.DurationDelta))
+	}
+	// order:load 的 self time：A 为 20-5=15ms，B 为 150-40-80=30ms
+	if order := nodes["gateway:GET /checkout/order:load"]; order.SelfTimeDelta != (15 * time.Millisecond).Nanoseconds() {
+		t.Errorf("order self time delta = %v, want 15ms", time.Duration(order.SelfTimeDelta))
+	}
+	if n := nodes["gateway:GET /checkout/cache:GET"]; n == nil || n.Status != diffStatusMissing || n.CountDelta != -1 {
+		t.Errorf("unexpected cache node %+v", n)
+	}
+	if n := nodes["gateway:GET /checkout/auth:verify"]; n == nil || n.Status != diffStatusAdded || n.DurationB != (10*time.Millisecond).Nanoseconds() {
+		t.Errorf("unexpected auth node %+v", n)
+	}
+
+	// A 中的节点在前，只在 B 中的节点在后
+	children := diff.Roots[0].Children
+	if len(children) != 3 || children[0].Service != "order" || children[1].Service != "cache" || children[2].Service != "auth" {
+		t.Errorf("unexpected child order %v", children)
+	}
+}
+
+func TestTraceDiffEndpoint(t *testing.T) {
+	f, reader := newTestReader(t)
+	found := model.NewTraceID(0, 1).String()
+	f.onFunc("WHERE trace_id = ?", spanColumns, func(args []interface{}) [][]interface{} {
+		if args[0] != found {
+			return nil
+		}
+		return [][]interface{}{spanRow(treeSpan(1, 0, model.SpanRefType_CHILD_OF, "gateway", "GET /", 0, 10))}
+	})
+	handler := newAPIServer(reader.store).handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/traces/diff?a=1&b=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/traces/diff?a=1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("missing b: status = %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/traces/diff?a=1&b=2", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown b: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	_, err := reader.DiffTraces(context.Background(), model.NewTraceID(0, 2), model.NewTraceID(0, 1))
+	if !errors.Is(err, spanstore.ErrTraceNotFound) {
+		t.Errorf("DiffTraces error = %v, want ErrTraceNotFound", err)
+	}
+}
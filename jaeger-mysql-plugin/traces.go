@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// Trace 摘要表（jaeger_traces）
+// ====================
+//
+// jaeger_spans 只能按单个 span 的属性过滤，"耗时超过 2s 的 trace" 实际上是
+// "有 span 耗时超过 2s 的 trace"。批量写入时同时维护 trace 级摘要：
+// 根服务、根操作、开始时间、总耗时、span 数、服务列表和错误标记，
+// 搜索可以直接在 trace 级别过滤和排序。
+// 同一 trace 的 span 可能分多批到达，摘要先在内存 LRU 中累积，
+// 不在内存中时从 jaeger_traces 读回已有摘要再合并。
+
+var (
+	// 是否维护 trace 摘要表
+	traceSummaryEnabled = getBoolEnv("TRACE_SUMMARY_ENABLED", true)
+	// 内存中保留的 trace 摘要数
+	traceSummaryCacheSize = getIntEnv("TRACE_SUMMARY_CACHE_SIZE", 10000)
+	// 内存中 trace 摘要的保留时间（超过后从数据库读回）
+	traceSummaryCacheTTL = getDurationEnv("TRACE_SUMMARY_CACHE_TTL", 10*time.Minute)
+	// FindTraces 中 DurationMin/DurationMax 的语义：span（任一 span 耗时）或 trace（trace 总耗时）
+	traceDurationFilter = getStringEnv("TRACE_DURATION_FILTER", "span")
+	// trace 级耗时过滤时，从摘要表中取出的候选 trace 数上限
+	traceSummaryCandidates = getIntEnv("TRACE_SUMMARY_CANDIDATES", 1000)
+)
+
+// traceSummaryColumns 读取摘要时的列，与 scanTraceSummary 顺序一致
+const traceSummaryColumns = `trace_id, root_service, root_operation, start_time, end_time,
+		span_count, services, is_error`
+
+// traceSummary 是一个 trace 的汇总信息
+type traceSummary struct {
+	TraceID       string   `json:"traceID"`
+	RootService   string   `json:"rootService"`
+	RootOperation string   `json:"rootOperation"`
+	StartTime     int64    `json:"startTime"` // 纳秒
+	EndTime       int64    `json:"endTime"`   // 纳秒
+	SpanCount     int      `json:"spanCount"`
+	Services      []string `json:"services"`
+	Error         bool     `json:"error"`
+}
+
+// Duration trace 总耗时（最早开始到最晚结束）
+func (t *traceSummary) Duration() int64 {
+	return t.EndTime - t.StartTime
+}
+
+// add 将一个 span 合并到摘要中
+func (t *traceSummary) add(span *model.Span) {
+	start := span.StartTime.UnixNano()
+	end := start + span.Duration.Nanoseconds()
+	if t.SpanCount == 0 || start < t.StartTime {
+		t.StartTime = start
+	}
+	if end > t.EndTime {
+		t.EndTime = end
+	}
+	t.SpanCount++
+
+	service := span.Process.ServiceName
+	if i := sort.SearchStrings(t.Services, service); i == len(t.Services) || t.Services[i] != service {
+		t.Services = append(t.Services, "")
+		copy(t.Services[i+1:], t.Services[i:])
+		t.Services[i] = service
+	}
+
+	if span.ParentSpanID() == 0 {
+		t.RootService = service
+		t.RootOperation = span.OperationName
+	}
+	if spanIsError(span.Tags) {
+		t.Error = true
+	}
+}
+
+// clone 返回摘要的副本
+func (t *traceSummary) clone() *traceSummary {
+	c := *t
+	c.Services = append([]string(nil), t.Services...)
+	return &c
+}
+
+// traceSummaries 维护写入端的 trace 摘要
+type traceSummaries struct {
+	mu    sync.Mutex // 串行化"读取-合并-写回"
+	cache *lruCache[string, *traceSummary]
+}
+
+func newTraceSummaries() *traceSummaries {
+	if !traceSummaryEnabled {
+		return nil
+	}
+	return &traceSummaries{
+		cache: newLRUCache[string, *traceSummary](traceSummaryCacheSize, traceSummaryCacheTTL),
+	}
+}
+
+// updateTraceSummaries 将本批 span 合并到 trace 摘要并写回 jaeger_traces
+func (s *MySQLStore) updateTraceSummaries(ctx context.Context, spans []*model.Span) error {
+	ts := s.summaries
+	if ts == nil || len(spans) == 0 {
+		return nil
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	// 按 trace 分组，找出内存中没有的摘要
+	byTrace := make(map[string][]*model.Span)
+	var missing []string
+	for _, span := range spans {
+		id := span.TraceID.String()
+		if _, ok := byTrace[id]; !ok {
+			if _, cached := ts.cache.Get(id); !cached {
+				missing = append(missing, id)
+			}
+		}
+		byTrace[id] = append(byTrace[id], span)
+	}
+
+	loaded, err := loadTraceSummaries(ctx, s, missing)
+	if err != nil {
+		return err
+	}
+
+	updated := make([]*traceSummary, 0, len(byTrace))
+	for id, traceSpans := range byTrace {
+		summary, ok := ts.cache.Get(id)
+		if ok {
+			summary = summary.clone()
+		} else if summary = loaded[id]; summary == nil {
+			summary = &traceSummary{TraceID: id}
+		}
+		for _, span := range traceSpans {
+			summary.add(span)
+		}
+		updated = append(updated, summary)
+	}
+
+	if err := upsertTraceSummaries(ctx, s, updated); err != nil {
+		return err
+	}
+	for _, summary := range updated {
+		ts.cache.Put(summary.TraceID, summary)
+	}
+	return nil
+}
+
+// loadTraceSummaries 从 jaeger_traces 读取已有摘要
+func loadTraceSummaries(ctx context.Context, s *MySQLStore, traceIDs []string) (map[string]*traceSummary, error) {
+	out := make(map[string]*traceSummary, len(traceIDs))
+	if len(traceIDs) == 0 {
+		return out, nil
+	}
+
+	args := make([]interface{}, len(traceIDs))
+	for i, id := range traceIDs {
+		args[i] = id
+	}
+	query := fmt.Sprintf(`SELECT %s FROM jaeger_traces WHERE trace_id IN (%s) LIMIT %d`,
+		traceSummaryColumns, placeholders(len(traceIDs)), len(traceIDs))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load trace summaries failed: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		summary, err := scanTraceSummary(rows)
+		if err != nil {
+			continue
+		}
+		out[summary.TraceID] = summary
+	}
+	return out, rows.Err()
+}
+
+// upsertTraceSummaries 以 REPLACE 写回摘要（id 由 trace ID 哈希得到）
+func upsertTraceSummaries(ctx context.Context, s *MySQLStore, summaries []*traceSummary) error {
+	if len(summaries) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`REPLACE INTO jaeger_traces (id, trace_id, root_service, root_operation,
+		start_time, end_time, duration, span_count, services, is_error) VALUES `)
+	args := make([]interface{}, 0, len(summaries)*10)
+	for i, t := range summaries {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args,
+			stableID(t.TraceID), t.TraceID, t.RootService, t.RootOperation,
+			t.StartTime, t.EndTime, t.Duration(), t.SpanCount,
+			strings.Join(t.Services, "\n"), boolToInt(t.Error),
+		)
+	}
+
+	if _, err := s.db.ExecContext(ctx, sb.String(), args...); err != nil {
+		return fmt.Errorf("upsert trace summaries failed: %w", err)
+	}
+	return nil
+}
+
+// rowScanner 抽象 *sql.Rows 和 *sql.Row 的 Scan
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTraceSummary 按 traceSummaryColumns 的顺序扫描一行摘要
+func scanTraceSummary(rows rowScanner) (*traceSummary, error) {
+	var (
+		t        traceSummary
+		services string
+		isError  int
+	)
+	if err := rows.Scan(&t.TraceID, &t.RootService, &t.RootOperation, &t.StartTime, &t.EndTime,
+		&t.SpanCount, &services, &isError); err != nil {
+		return nil, err
+	}
+	if services != "" {
+		t.Services = strings.Split(services, "\n")
+	}
+	t.Error = isError != 0
+	return &t, nil
+}
+
+// ====================
+// Trace 级搜索
+// ====================
+
+// traceSummaryQuery trace 级搜索条件
+type traceSummaryQuery struct {
+	ServiceName   string        // 包含该服务的 trace（任意位置）
+	RootService   string        // 根 span 的服务
+	RootOperation string        // 根 span 的操作
+	StartTimeMin  time.Time     // trace 开始时间下限
+	StartTimeMax  time.Time     // trace 开始时间上限
+	DurationMin   time.Duration // trace 总耗时下限
+	DurationMax   time.Duration // trace 总耗时上限
+	MinSpans      int           // span 数下限
+	ErrorsOnly    bool          // 只返回含错误 span 的 trace
+	SortBy        string        // start_time（默认）、duration 或 span_count，均为倒序
+	Limit         int
+}
+
+// 摘要搜索的返回条数
+const (
+	defaultTraceSummaryLimit = 20
+	maxTraceSummaryLimit     = 1000
+)
+
+// traceSummarySortColumns 允许的排序列
+var traceSummarySortColumns = map[string]string{
+	"":           "start_time",
+	"start_time": "start_time",
+	"duration":   "duration",
+	"span_count": "span_count",
+}
+
+// buildTraceSummarySQL 构建摘要搜索 SQL
+func buildTraceSummarySQL(q *traceSummaryQuery) (traceSearchSQL, error) {
+	sortColumn, ok := traceSummarySortColumns[q.SortBy]
+	if !ok {
+		return traceSearchSQL{}, fmt.Errorf("invalid sort %q: must be one of start_time, duration, span_count", q.SortBy)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultTraceSummaryLimit
+	}
+	if limit > maxTraceSummaryLimit {
+		return traceSearchSQL{}, fmt.Errorf("limit %d exceeds maximum %d", limit, maxTraceSummaryLimit)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT " + traceSummaryColumns + " FROM jaeger_traces WHERE start_time >= ? AND start_time <= ?")
+	args := []interface{}{q.StartTimeMin.UnixNano(), q.StartTimeMax.UnixNano()}
+
+	if q.ServiceName != "" {
+		sb.WriteString(" AND MATCH(?)")
+		args = append(args, "@services "+matchPhrase(q.ServiceName))
+	}
+	if q.RootService != "" {
+		sb.WriteString(" AND root_service = ?")
+		args = append(args, q.RootService)
+	}
+	if q.RootOperation != "" {
+		sb.WriteString(" AND root_operation = ?")
+		args = append(args, q.RootOperation)
+	}
+	if q.DurationMin > 0 {
+		sb.WriteString(" AND duration >= ?")
+		args = append(args, q.DurationMin.Nanoseconds())
+	}
+	if q.DurationMax > 0 {
+		sb.WriteString(" AND duration <= ?")
+		args = append(args, q.DurationMax.Nanoseconds())
+	}
+	if q.MinSpans > 0 {
+		sb.WriteString(" AND span_count >= ?")
+		args = append(args, q.MinSpans)
+	}
+	if q.ErrorsOnly {
+		sb.WriteString(" AND is_error = 1")
+	}
+
+	fmt.Fprintf(&sb, " ORDER BY %s DESC, trace_id ASC LIMIT %d OPTION max_matches=%d", sortColumn, limit, limit)
+
+	return traceSearchSQL{query: sb.String(), args: args}, nil
+}
+
+// FindTraceSummaries 在 trace 摘要表上搜索
+func (r *MySQLSpanReader) FindTraceSummaries(ctx context.Context, q *traceSummaryQuery) ([]*traceSummary, error) {
+	sq, err := buildTraceSummarySQL(q)
+	if err != nil {
+		return nil, invalidQuery(err)
+	}
+
+	rows, err := r.db.QueryContext(ctx, sq.query, sq.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*traceSummary
+	for rows.Next() {
+		summary, err := scanTraceSummary(rows)
+		if err != nil {
+			r.logger.Warn().Err(err).Msg("Failed to scan trace summary")
+			continue
+		}
+		// 全文匹配服务名可能跨服务边界，这里精确校验
+		if q.ServiceName != "" && !containsString(summary.Services, q.ServiceName) {
+			continue
+		}
+		out = append(out, summary)
+	}
+	return out, rows.Err()
+}
+
+// ====================
+// 辅助函数
+// ====================
+
+// placeholders 生成 n 个逗号分隔的 ? 占位符
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// containsString 判断有序或无序切片中是否包含 s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// boolToInt 将布尔值转换为 0/1
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func summarySpan(spanID, parentID uint64, service, operation string, start time.Time, d time.Duration) *model.Span {
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, 7),
+		SpanID:        model.NewSpanID(spanID),
+		OperationName: operation,
+		StartTime:     start,
+		Duration:      d,
+		Process:       &model.Process{ServiceName: service},
+	}
+	if parentID != 0 {
+		span.References = []model.SpanRef{model.NewChildOfRef(span.TraceID, model.NewSpanID(parentID))}
+	}
+	return span
+}
+
+var traceSummaryTestColumns = []string{
+	"trace_id", "root_service", "root_operation", "start_time", "end_time",
+	"span_count", "services", "is_error",
+}
+
+func TestTraceSummaryAdd(t *testing.T) {
+	base := time.Unix(1000, 0)
+	child := summarySpan(2, 1, "db", "SELECT", base.Add(10*time.Millisecond), 50*time.Millisecond)
+	child.Tags = []model.KeyValue{model.Bool("error", true)}
+
+	var ts traceSummary
+	ts.add(child)
+	ts.add(summarySpan(1, 0, "api", "GET /orders", base, 100*time.Millisecond))
+	ts.add(summarySpan(3, 1, "api", "render", base.Add(90*time.Millisecond), 30*time.Millisecond))
+
+	if ts.RootService != "api" || ts.RootOperation != "GET /orders" {
+		t.Errorf("root = %s/%s, want api/GET /orders", ts.RootService, ts.RootOperation)
+	}
+	if got := time.Duration(ts.Duration()); got != 120*time.Millisecond {
+		t.Errorf("duration = %v, want 120ms", got)
+	}
+	if ts.SpanCount != 3 {
+		t.Errorf("span count = %d, want 3", ts.SpanCount)
+	}
+	if !reflect.DeepEqual(ts.Services, []string{"api", "db"}) {
+		t.Errorf("services = %v", ts.Services)
+	}
+	if !ts.Error {
+		t.Error("expected error flag")
+	}
+}
+
+func TestUpdateTraceSummariesMergesBatches(t *testing.T) {
+	f, reader := newTestReader(t)
+	store := reader.store
+	ctx := context.Background()
+	base := time.Unix(1000, 0)
+
+	if err := store.updateTraceSummaries(ctx, []*model.Span{
+		summarySpan(2, 1, "db", "SELECT", base.Add(time.Millisecond), time.Millisecond),
+	}); err != nil {
+		t.Fatalf("updateTraceSummaries: %v", err)
+	}
+	if err := store.updateTraceSummaries(ctx, []*model.Span{
+		summarySpan(1, 0, "api", "GET /orders", base, time.Second),
+	}); err != nil {
+		t.Fatalf("updateTraceSummaries: %v", err)
+	}
+
+	// 第二批命中内存摘要，不再读数据库
+	if got := len(f.matching("FROM jaeger_traces")); got != 1 {
+		t.Errorf("expected 1 summary load, got %d", got)
+	}
+	writes := f.matching("REPLACE INTO jaeger_traces")
+	if len(writes) != 2 {
+		t.Fatalf("expected 2 REPLACE, got %d", len(writes))
+	}
+	args := writes[1].args
+	want := []interface{}{
+		stableID(model.NewTraceID(0, 7).String()), model.NewTraceID(0, 7).String(),
+		"api", "GET /orders", base.UnixNano(), base.Add(time.Second).UnixNano(),
+		time.Second.Nanoseconds(), 2, "api\ndb", 0,
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestUpdateTraceSummariesLoadsStoredSummary(t *testing.T) {
+	f, reader := newTestReader(t)
+	store := reader.store
+	base := time.Unix(1000, 0)
+	traceID := model.NewTraceID(0, 7).String()
+
+	f.on("FROM jaeger_traces", traceSummaryTestColumns, []interface{}{
+		traceID, "api", "GET /orders", base.UnixNano(), base.Add(time.Second).UnixNano(),
+		int64(5), "api\ncache", int64(1),
+	})
+
+	if err := store.updateTraceSummaries(context.Background(), []*model.Span{
+		summarySpan(9, 1, "db", "SELECT", base.Add(2*time.Second), time.Second),
+	}); err != nil {
+		t.Fatalf("updateTraceSummaries: %v", err)
+	}
+
+	summary, ok := store.summaries.cache.Get(traceID)
+	if !ok {
+		t.Fatal("summary not cached")
+	}
+	if summary.SpanCount != 6 || summary.RootService != "api" || !summary.Error {
+		t.Errorf("summary = %+v", summary)
+	}
+	if time.Duration(summary.Duration()) != 3*time.Second {
+		t.Errorf("duration = %v, want 3s", time.Duration(summary.Duration()))
+	}
+	if !reflect.DeepEqual(summary.Services, []string{"api", "cache", "db"}) {
+		t.Errorf("services = %v", summary.Services)
+	}
+}
+
+func TestBuildTraceSummarySQL(t *testing.T) {
+	q, err := buildTraceSummarySQL(&traceSummaryQuery{
+		ServiceName:  "order",
+		RootService:  "gateway",
+		StartTimeMin: time.Unix(100, 0),
+		StartTimeMax: time.Unix(200, 0),
+		DurationMin:  2 * time.Second,
+		MinSpans:     10,
+		ErrorsOnly:   true,
+		SortBy:       "duration",
+		Limit:        50,
+	})
+	if err != nil {
+		t.Fatalf("buildTraceSummarySQL: %v", err)
+	}
+	for _, want := range []string{
+		"MATCH(?)", "root_service = ?", "duration >= ?", "span_count >= ?", "is_error = 1",
+		"ORDER BY duration DESC", "LIMIT 50 OPTION max_matches=50",
+	} {
+		if !strings.Contains(q.query, want) {
+			t.Errorf("query missing %q:\n%s", want, q.query)
+		}
+	}
+
+	if _, err := buildTraceSummarySQL(&traceSummaryQuery{SortBy: "trace_id; DROP"}); err == nil {
+		t.Error("expected error for invalid sort")
+	}
+}
+
+func TestFindTraceSummariesExactService(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("FROM jaeger_traces", traceSummaryTestColumns,
+		[]interface{}{"a", "api", "GET", int64(1), int64(2), int64(2), "api\norder", int64(0)},
+		[]interface{}{"b", "api", "GET", int64(1), int64(2), int64(2), "api\norder-worker", int64(0)},
+	)
+
+	got, err := reader.FindTraceSummaries(context.Background(), &traceSummaryQuery{
+		ServiceName:  "order",
+		StartTimeMax: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("FindTraceSummaries: %v", err)
+	}
+	if len(got) != 1 || got[0].TraceID != "a" {
+		t.Errorf("got %+v, want only trace a", got)
+	}
+}
+
+func TestFindTracesTraceDurationFilter(t *testing.T) {
+	defer func(v string) { traceDurationFilter = v }(traceDurationFilter)
+	traceDurationFilter = "trace"
+
+	f, reader := newTestReader(t)
+	f.on("FROM jaeger_traces", traceSummaryTestColumns,
+		[]interface{}{"t1", "order", "GET", int64(1), int64(3e9), int64(4), "order", int64(0)},
+	)
+
+	_, err := reader.FindTraceIDs(context.Background(), &spanstore.TraceQueryParameters{
+		ServiceName:  "order",
+		StartTimeMin: time.Unix(0, 0),
+		StartTimeMax: time.Unix(100, 0),
+		DurationMin:  2 * time.Second,
+		NumTraces:    20,
+	})
+	if err != nil {
+		t.Fatalf("FindTraceIDs: %v", err)
+	}
+
+	spanQueries := f.matching("FROM jaeger_spans")
+	if len(spanQueries) != 1 {
+		t.Fatalf("expected 1 span query, got %d", len(spanQueries))
+	}
+	q := spanQueries[0].query
+	if !strings.Contains(q, "trace_id IN (?)") {
+		t.Errorf("span query not restricted to candidates:\n%s", q)
+	}
+	if strings.Contains(q, "duration >=") {
+		t.Errorf("span query should not filter span duration:\n%s", q)
+	}
+}
+
+func TestSummaryEndpointsStatusCodes(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.onError("FROM jaeger_traces", errors.New("connection refused"))
+	f.onError("SUM(is_error)", errors.New("connection refused"))
+	handler := newAPIServer(reader.store).handler()
+
+	for url, want := range map[string]int{
+		"/api/traces/summaries?limit=100000000": http.StatusBadRequest,
+		"/api/traces/summaries?sort=trace_id":   http.StatusBadRequest,
+		"/api/traces/summaries":                 http.StatusInternalServerError,
+		"/api/errors/summary?limit=100000000":   http.StatusBadRequest,
+		"/api/errors/summary":                   http.StatusInternalServerError,
+		"/api/traces/search?limit=100000":       http.StatusBadRequest,
+		"/api/traces/search?cursor=bogus":       http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d (body: %s)", url, rec.Code, want, rec.Body.String())
+		}
+	}
+	if n := len(f.matching("LIMIT 100000000")); n != 0 {
+		t.Errorf("oversized limit reached the database %d times", n)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// ====================
+// 虚拟依赖边
+// ====================
+//
+// 数据库、缓存、第三方 API 等没有接入 tracing 的下游不会产生子 span，
+// 在依赖图中完全不可见。对没有子 span 的客户端（client / producer）span，
+// 按以下 tag 的优先级取调用对象作为虚拟叶子节点，生成 服务 → 调用对象 的边：
+//
+//	peer.service > db.system > messaging.system > net.peer.name
+//
+// 虚拟节点名带有类型前缀（service:orders-db、db:mysql、messaging:kafka、
+// peer:api.example.com），不会与真实服务混淆。有子 span 的客户端 span 已由真实的
+// 父子关系计数，不再生成虚拟边，避免重复。调用对象与当前服务同名时忽略。
+//
+// 扩展依赖 API 默认返回虚拟边（virtual=false 时隐藏）；GetDependencies 和
+// jaeger_dependencies 只包含真实服务之间的边，DEPENDENCIES_VIRTUAL_EDGES 启用后才包含。
+
+// 是否在 GetDependencies（及预聚合结果）中包含虚拟依赖边
+var dependenciesVirtualEdges = getBoolEnv("DEPENDENCIES_VIRTUAL_EDGES", false)
+
+// virtualPeerTags 按优先级排列的调用对象 tag 及虚拟节点名前缀
+var virtualPeerTags = []struct {
+	key    string
+	prefix string
+}{
+	{"peer.service", "service:"},
+	{"db.system", "db:"},
+	{"messaging.system", "messaging:"},
+	{"net.peer.name", "peer:"},
+}
+
+// virtualPeerOf 从 tag_attrs JSON 中取客户端 span 的调用对象，返回带前缀的虚拟节点名；
+// 非客户端 span 或调用对象就是 service 本身时返回空字符串
+func virtualPeerOf(kind, service, attrsJSON string) string {
+	if kind != "client" && kind != "producer" {
+		return ""
+	}
+	if attrsJSON == "" {
+		return ""
+	}
+	var attrs map[string]interface{}
+	if err := json.Unmarshal([]byte(attrsJSON), &attrs); err != nil {
+		return ""
+	}
+	for _, tag := range virtualPeerTags {
+		var peer string
+		switch v := attrs[tag.key].(type) {
+		case nil:
+			continue
+		case string:
+			peer = v
+		default:
+			peer = fmt.Sprint(v)
+		}
+		if peer == "" {
+			continue
+		}
+		if peer == service {
+			return ""
+		}
+		return tag.prefix + peer
+	}
+	return ""
+}
+
+// visitVirtualEdges 为没有子 span 的客户端 span 生成到虚拟叶子节点的边
+func visitVirtualEdges(group []depSpan, hasChild map[*depSpan]bool, childFrom int64, visit dependencyVisitor) {
+	for i := range group {
+		span := &group[i]
+		if span.peer == "" || hasChild[span] || span.startTime < childFrom {
+			continue
+		}
+		leaf := depSpan{
+			key:       span.key,
+			service:   span.peer,
+			operation: span.operation,
+			startTime: span.startTime,
+			duration:  span.duration,
+			isError:   span.isError,
+			virtual:   true,
+		}
+		visit(span, &leaf, model.SpanRefType_CHILD_OF)
+	}
+}
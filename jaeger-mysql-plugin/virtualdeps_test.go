@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+func TestVirtualPeerOf(t *testing.T) {
+	tests := []struct {
+		name string
+		kind string
+		tags []model.KeyValue
+		want string
+	}{
+		{"server span", "server", []model.KeyValue{model.String("peer.service", "redis")}, ""},
+		{"no tags", "client", nil, ""},
+		{"peer.service first", "client", []model.KeyValue{
+			model.String("db.system", "mysql"), model.String("peer.service", "orders-db"),
+		}, "service:orders-db"},
+		{"db.system", "client", []model.KeyValue{model.String("db.system", "redis")}, "db:redis"},
+		{"messaging", "producer", []model.KeyValue{model.String("messaging.system", "kafka")}, "messaging:kafka"},
+		{"host", "client", []model.KeyValue{model.String("net.peer.name", "api.example.com")}, "peer:api.example.com"},
+		{"self", "client", []model.KeyValue{model.String("peer.service", "order")}, ""},
+	}
+	for _, tt := range tests {
+		if got := virtualPeerOf(tt.kind, "order", marshalTagAttrs(tt.tags)); got != tt.want {
+			t.Errorf("%s: virtualPeerOf = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// virtualFixture 包含一条虚拟边（order -> db:mysql）和一条真实边（order -> payment）
+func virtualFixture() [][]interface{} {
+	childOf := func(id uint64) []model.SpanRef {
+		return []model.SpanRef{{SpanID: model.NewSpanID(id), RefType: model.SpanRefType_CHILD_OF}}
+	}
+	return [][]interface{}{
+		depRowWith("t1", 1, nil, "order", "GET /orders", 100, 0, false),
+		// 没有子 span 的数据库调用：order -> db:mysql
+		withKind(depRowWith("t1", 2, childOf(1), "order", "SELECT", 110, 0, false),
+			"client", model.String("db.system", "mysql")),
+		// 有子 span 的客户端调用只计真实的边：order -> payment
+		withKind(depRowWith("t1", 3, childOf(1), "order", "POST /pay", 120, 0, false),
+			"client", model.String("peer.service", "payment")),
+		depRowWith("t1", 4, childOf(3), "payment", "pay", 130, 0, false),
+		// 调用对象与自身服务同名，忽略
+		withKind(depRowWith("t1", 5, childOf(1), "order", "self", 140, 0, false),
+			"client", model.String("peer.service", "order")),
+	}
+}
+
+func TestCountDependenciesVirtualEdges(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("trace_id > ?", depScanColumns, virtualFixture()...)
+
+	// 默认只统计真实服务之间的边
+	counts, err := countDependencies(context.Background(), reader.store,
+		time.Unix(0, 0), time.Unix(0, 0), time.Unix(0, 200))
+	if err != nil {
+		t.Fatalf("countDependencies: %v", err)
+	}
+	want := dependencyCounts{{parent: "order", child: "payment"}: 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+
+	defer func(v bool) { dependenciesVirtualEdges = v }(dependenciesVirtualEdges)
+	dependenciesVirtualEdges = true
+	counts, err = countDependencies(context.Background(), reader.store,
+		time.Unix(0, 0), time.Unix(0, 0), time.Unix(0, 200))
+	if err != nil {
+		t.Fatalf("countDependencies: %v", err)
+	}
+	want[dependencyKey{parent: "order", child: "db:mysql"}] = 1
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts with virtual edges = %v, want %v", counts, want)
+	}
+}
+
+func TestGetRichDependenciesVirtualEdges(t *testing.T) {
+	f, reader := newTestReader(t)
+	f.on("COUNT(*) AS spans", []string{"spans"}, []interface{}{int64(5)})
+	f.on("trace_id > ?", depScanColumns, virtualFixture()...)
+	deps := reader.store.DependencyReader().(*MySQLDependencyReader)
+	query := &dependencyQuery{StartTime: time.Unix(0, 0), EndTime: time.Unix(0, 200), Virtual: true}
+
+	result, err := deps.GetRichDependencies(context.Background(), query)
+	if err != nil {
+		t.Fatalf("GetRichDependencies: %v", err)
+	}
+	if len(result.Links) != 2 || result.Links[0].Child != "db:mysql" || !result.Links[0].Virtual {
+		t.Errorf("expected virtual edge to db:mysql, got %+v", result.Links)
+	}
+
+	query.Virtual = false
+	result, err = deps.GetRichDependencies(context.Background(), query)
+	if err != nil {
+		t.Fatalf("GetRichDependencies: %v", err)
+	}
+	if len(result.Links) != 1 || result.Links[0].Child != "payment" {
+		t.Errorf("expected only the real edge, got %+v", result.Links)
+	}
+}